@@ -51,6 +51,16 @@ $.when( $.ready ).then(function() {
 		},
 	});
 
+	// retreieve list of network interfaces to make connections from
+	$.ajax({
+		url: '/api/control/interfaces',
+		success: function(data) {
+			(data.params || []).forEach(function(iface) {
+				$("#interface option[value='*']").before(`<option value="`+iface+`">`+iface+`</option>`);
+			});
+		},
+	});
+
 	// prepare receiving results via WebSocket
 	webSocket = new WebSocket("http://localhost:8080/api/results/")
 	webSocket.onmessage = (event) => {
@@ -158,7 +168,18 @@ function startChecks() {
 			checks: checksToDo,
 			ipv4: $("#af4").is(":checked"),
 			ipv6: $("#af6").is(":checked"),
+			interface: $("#interface").val() == "*" ? "" : $("#interface").val(),
+			each_interface: $("#interface").val() == "*",
+			source: $("#source").val().trim(),
 		}),
+		success: function(data) {
+			if( data.code != "OK" ) {
+				alert(data.message);
+				$("#startbutton").html(`Start`);
+				$("#startbutton").prop("disabled", false);
+				$("#stopbutton").prop("disabled", true);
+			}
+		},
 	});
 
 	// prevent double-clicking the button
@@ -253,8 +274,8 @@ function formatResult(data) {
 <div class="row result_level_`+levelToName(data.level)+`" `+(disp ? "" : "style='display:none;'")+`>
   <div class="col"><div class="row filter-here">
 		<div class="col-sm-2">`+data.timestamp.slice("YYYY-MM-DDT".length)+`</div>
-		<div class="col-sm-3 text-truncate">`+data.mnemonic+`</div>
-		<div class="col-sm-7">`+data.details+`</div>
+		<div class="col-sm-3 text-truncate">`+(data.interface ? data.interface+": " : "")+data.mnemonic+`</div>
+		<div class="col-sm-7`+(data.mnemonic.endsWith("_TABLE") || data.mnemonic == "INTERFACE_SUMMARY" ? " font-monospace text-nowrap" : "")+`">`+data.details+`</div>
 	</div></div>
 </div>`;
 }
@@ -0,0 +1,298 @@
+package checks
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// CaptivePortalCheck detects captive portals (hotel, airport, ... login pages) by fetching
+// known content over HTTP, and by asking the captive portal API (RFC 8908) if the network announces one
+type CaptivePortalCheck struct {
+	netiscopeCheckBase
+}
+
+// where systemd-networkd keeps the captive portal API URLs learnt from DHCP and router advertisements (RFC 8910)
+var networkdStateDirs = []string{"/run/systemd/netif/links", "/run/systemd/netif/leases"}
+
+// the most we read from a probe or API response
+const maxCaptivePortalBody = 64 * 1024
+
+// the portal found in the current run, if any: the failures of the other checks are annotated with it
+// the captive portal check runs before all the others, so this doesn't change while they run
+var (
+	captivePortalDetected bool
+	captivePortalURL      string
+)
+
+// places in a substituted page that typically point to the portal
+var portalURLPatterns = []*regexp.Regexp{
+	regexp.MustCompile(`(?is)<LoginURL>\s*([^<\s]+)\s*</LoginURL>`), // WISPr
+	regexp.MustCompile(`(?is)<meta[^>]+http-equiv=["']?refresh["']?[^>]*content=["']?\s*\d*\s*;\s*url=([^"'>\s]+)`),
+	regexp.MustCompile(`(?is)(?:window|document|top)\.location(?:\.href)?\s*=\s*["']([^"']+)["']`),
+}
+
+// the answer of a captive portal API (RFC 8908)
+type captivePortalAPIResponse struct {
+	Captive          *bool  `json:"captive"`
+	UserPortalURL    string `json:"user-portal-url"`
+	VenueInfoURL     string `json:"venue-info-url"`
+	CanExtendSession bool   `json:"can-extend-session"`
+	SecondsRemaining *int   `json:"seconds-remaining"`
+	BytesRemaining   *int   `json:"bytes-remaining"`
+}
+
+// Start executes the captive portal check
+func (check *CaptivePortalCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	for _, probe := range util.GetCaptivePortalProbes() {
+		if len(probe) != 2 {
+			check.log(LogLevelError, "CAPTIVE_PORTAL_CONFIG_ERROR", "Wrong probe configuration: "+strings.Join(probe, ","))
+			continue
+		}
+		for _, af := range []string{"4", "6"} {
+			if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) || check.stopping {
+				continue
+			}
+			check.probe(af, strings.TrimSpace(probe[0]), strings.TrimSpace(probe[1]))
+		}
+	}
+
+	apiURLs := util.GetCaptivePortalAPIURLs()
+	if len(apiURLs) == 0 {
+		apiURLs = check.discoverAPIURLs()
+	}
+	for _, apiURL := range apiURLs {
+		if check.stopping {
+			break
+		}
+		check.queryAPI(apiURL)
+	}
+
+	switch {
+	case !captivePortalDetected:
+		check.log(LogLevelInfo, "CAPTIVE_PORTAL_NONE", "No captive portal detected")
+	case captivePortalURL != "":
+		check.log(LogLevelError, "CAPTIVE_PORTAL_DETECTED", "Captive portal detected at "+captivePortalURL)
+	default:
+		check.log(LogLevelError, "CAPTIVE_PORTAL_DETECTED", "Captive portal detected, its URL is unknown")
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// fetch a probe URL and compare the response to what's expected: a status code (like 204) or some content
+// redirects are not followed, as those are what a portal typically answers with
+func (check *CaptivePortalCheck) probe(af string, probeURL string, expected string) {
+	transport := NewTransport(af, time.Duration(util.GetCaptivePortalTimeout())*time.Millisecond)
+	defer transport.CloseIdleConnections()
+	client := transport.HTTPClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	resp, err := client.Get(probeURL)
+	if err != nil {
+		check.log(
+			LogLevelWarning,
+			"CAPTIVE_PORTAL_PROBE_ERROR",
+			fmt.Sprintf("Fetching %s over IPv%s failed (%s): %v", probeURL, af, ClassifyConnectionError(err), err),
+		)
+		return
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxCaptivePortalBody))
+	if err != nil {
+		check.log(
+			LogLevelWarning,
+			"CAPTIVE_PORTAL_PROBE_ERROR",
+			fmt.Sprintf("Reading the response from %s over IPv%s failed: %v", probeURL, af, err),
+		)
+		return
+	}
+
+	// redirected somewhere
+	if location, err := resp.Location(); err == nil {
+		check.portalFound(
+			"CAPTIVE_PORTAL_REDIRECT",
+			location.String(),
+			fmt.Sprintf("%s over IPv%s (%s) was redirected (%s)", probeURL, af, transport.Connection(), resp.Status),
+		)
+		return
+	}
+
+	// the expected status code or content
+	var good bool
+	if code, err := strconv.Atoi(expected); err == nil && len(expected) == 3 {
+		good = resp.StatusCode == code
+		expected = "status " + expected
+	} else {
+		good = resp.StatusCode == http.StatusOK && strings.Contains(string(body), expected)
+		expected = fmt.Sprintf("%+q", expected)
+	}
+	if good {
+		check.log(
+			LogLevelDetail,
+			"CAPTIVE_PORTAL_PROBE_OK",
+			fmt.Sprintf("%s over IPv%s (%s) returned the expected %s", probeURL, af, transport.Connection(), expected),
+		)
+		return
+	}
+
+	portal := findPortalURL(string(body))
+	if portal != "" {
+		if base, err := url.Parse(probeURL); err == nil {
+			if ref, err := base.Parse(portal); err == nil {
+				portal = ref.String()
+			}
+		}
+	}
+	check.portalFound(
+		"CAPTIVE_PORTAL_SUBSTITUTION",
+		portal,
+		fmt.Sprintf(
+			"%s over IPv%s (%s) returned %s with %d bytes instead of the expected %s",
+			probeURL, af, transport.Connection(), resp.Status, len(body), expected,
+		),
+	)
+}
+
+// look for the portal URL in a substituted page
+func findPortalURL(body string) string {
+	for _, pattern := range portalURLPatterns {
+		if match := pattern.FindStringSubmatch(body); match != nil {
+			return strings.ReplaceAll(match[1], "&amp;", "&")
+		}
+	}
+	return ""
+}
+
+// record and report the signs of a portal
+func (check *CaptivePortalCheck) portalFound(mnemonic string, portal string, details string) {
+	captivePortalDetected = true
+	if portal != "" {
+		if captivePortalURL == "" {
+			captivePortalURL = portal
+		}
+		details += ", the portal is at " + portal
+	}
+	check.log(LogLevelWarning, mnemonic, details)
+}
+
+// find the captive portal API URLs learnt from DHCP (option 114) or router advertisements (option 37),
+// as far as systemd-networkd knows them; only those of the selected interface, if there's one
+func (check *CaptivePortalCheck) discoverAPIURLs() (found []string) {
+	var files []string
+	for _, dir := range networkdStateDirs {
+		if iface := util.GetInterface(); iface != "" {
+			// the state files are named after the interface index
+			if ifi, err := net.InterfaceByName(iface); err == nil {
+				files = append(files, filepath.Join(dir, strconv.Itoa(ifi.Index)))
+			}
+			continue
+		}
+		matches, _ := filepath.Glob(filepath.Join(dir, "*"))
+		files = append(files, matches...)
+	}
+
+	for _, file := range files {
+		fd, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+		scanner := bufio.NewScanner(fd)
+		for scanner.Scan() {
+			value, ok := strings.CutPrefix(scanner.Text(), "CAPTIVE_PORTAL=")
+			if ok && value != "" && !slices.Contains(found, value) {
+				check.log(LogLevelDetail, "CAPTIVE_PORTAL_API_ANNOUNCED", fmt.Sprintf("The network announces the captive portal API %s (%s)", value, file))
+				found = append(found, value)
+			}
+		}
+		fd.Close()
+	}
+	if len(found) == 0 {
+		check.log(LogLevelDetail, "CAPTIVE_PORTAL_API_NONE", "The network doesn't announce a captive portal API")
+	}
+	return
+}
+
+// ask a captive portal API (RFC 8908) whether we are captive
+func (check *CaptivePortalCheck) queryAPI(apiURL string) {
+	if !strings.HasPrefix(strings.ToLower(apiURL), "https://") {
+		check.log(LogLevelWarning, "CAPTIVE_PORTAL_API_NOT_HTTPS", "The captive portal API must be used over HTTPS, but it is "+apiURL)
+	}
+
+	transport := NewTransport("", time.Duration(util.GetCaptivePortalTimeout())*time.Millisecond)
+	defer transport.CloseIdleConnections()
+	req, err := http.NewRequest("GET", apiURL, nil)
+	if err != nil {
+		check.log(LogLevelError, "CAPTIVE_PORTAL_API_ERROR", fmt.Sprintf("Invalid captive portal API URL %s: %v", apiURL, err))
+		return
+	}
+	req.Header.Set("Accept", "application/captive+json")
+	resp, err := transport.HTTPClient().Do(req)
+	if err != nil {
+		check.log(
+			LogLevelWarning,
+			"CAPTIVE_PORTAL_API_ERROR",
+			fmt.Sprintf("Querying the captive portal API %s failed (%s): %v", apiURL, ClassifyConnectionError(err), err),
+		)
+		return
+	}
+	defer resp.Body.Close()
+
+	var answer captivePortalAPIResponse
+	err = json.NewDecoder(io.LimitReader(resp.Body, maxCaptivePortalBody)).Decode(&answer)
+	if err != nil || resp.StatusCode != http.StatusOK || answer.Captive == nil {
+		check.log(
+			LogLevelWarning,
+			"CAPTIVE_PORTAL_API_INVALID",
+			fmt.Sprintf("The captive portal API %s (%s) gave an invalid answer (%s): %v", apiURL, transport.Connection(), resp.Status, err),
+		)
+		return
+	}
+
+	details := fmt.Sprintf("The captive portal API %s (%s) says captive=%t", apiURL, transport.Connection(), *answer.Captive)
+	if answer.VenueInfoURL != "" {
+		details += ", venue information at " + answer.VenueInfoURL
+	}
+	if answer.SecondsRemaining != nil {
+		details += fmt.Sprintf(", %v remaining", time.Duration(*answer.SecondsRemaining)*time.Second)
+	}
+	if answer.BytesRemaining != nil {
+		details += fmt.Sprintf(", %d bytes remaining", *answer.BytesRemaining)
+	}
+	if answer.CanExtendSession {
+		details += ", the session can be extended"
+	}
+	if *answer.Captive {
+		check.portalFound("CAPTIVE_PORTAL_API_CAPTIVE", answer.UserPortalURL, details)
+	} else {
+		check.log(LogLevelInfo, "CAPTIVE_PORTAL_API_NOT_CAPTIVE", details)
+	}
+}
+
+// note that probably a portal is behind the failures of other checks
+func annotateCaptivePortal(check string, level LogLevelType, details string) string {
+	if !captivePortalDetected || check == "captive_portal" || level < LogLevelWarning || level > LogLevelFatal {
+		return details
+	}
+	if captivePortalURL != "" {
+		return details + " (probably caused by the captive portal at " + captivePortalURL + ")"
+	}
+	return details + " (probably caused by the captive portal)"
+}
@@ -1,9 +1,11 @@
 package checks
 
 import (
+	"errors"
 	"fmt"
 	"github.com/robert-kisteleki/netiscope/util"
 	"runtime"
+	"slices"
 	"strings"
 	"sync"
 )
@@ -12,6 +14,9 @@ func init() {
 	AllResults = make(chan ResultItem)
 }
 
+// errUnsupportedPlatform tells that something is only implemented on some platforms (typically Linux)
+var errUnsupportedPlatform = errors.New("not supported on this platform")
+
 type NetiscopeCheck interface {
 	configure()
 	start()
@@ -60,7 +65,8 @@ func (check *netiscopeCheckBase) log(
 	mnemonic string,
 	details string,
 ) {
-	AllResults <- NewFinding(check.name, level, mnemonic, details)
+	countFinding(check.name, level)
+	AllResults <- NewFinding(check.name, level, mnemonic, annotateCaptivePortal(check.name, level, details))
 }
 
 func (check *netiscopeCheckBase) finish() {
@@ -79,17 +85,39 @@ func (check *netiscopeCheckBase) getNameAsMnemonic() string {
 // what checks are available
 var knownChecks []string = []string{
 	"network_interfaces",
+	"captive_portal",
 	"dns_local_resolvers",
+	"local_name_resolution",
 	"dns_open_resolvers",
 	"dns_root_servers",
+	"dns_root_zone",
+	"dns_trace",
+	"dns_benchmark",
+	"dns_resolver_capabilities",
+	"dns_ecs_leak",
+	"dns_randomisation",
+	"egress_identity",
+	"stun",
+	"traceroute",
 	"port_filtering",
 	"doh_providers",
+	"encrypted_dns_blocking",
 	"ssh_host_keys",
-	"path_mtu",
+	"path_mtu_icmp",
+	"path_mtu_http",
+	"happy_eyeballs",
+	"popular_services",
 }
 
 var runningChecks []NetiscopeCheck
 var version string
+var stopRequested bool
+
+// the number of findings per check and level in the current run, when comparing runs on different interfaces
+var (
+	runCounters      map[string]*[7]int
+	runCountersMutex sync.Mutex
+)
 
 // ExecuteChecks runs all the defined checks
 func ExecuteChecks(checksToDo []string) {
@@ -98,6 +126,12 @@ func ExecuteChecks(checksToDo []string) {
 		return
 	}
 
+	// the captive portal check runs first, so that the failures of the others can be attributed to a portal
+	captivePortalDetected, captivePortalURL = false, ""
+	if i := slices.Index(checksToDo, "captive_portal"); i > 0 {
+		checksToDo = slices.Concat([]string{"captive_portal"}, checksToDo[:i], checksToDo[i+1:])
+	}
+
 	var wg sync.WaitGroup
 	runningChecks = make([]NetiscopeCheck, 0)
 	for i := range checksToDo {
@@ -105,8 +139,12 @@ func ExecuteChecks(checksToDo []string) {
 		check, found := initializeCheckByName(checkName)
 		if found {
 			check.configure()
-			wg.Add(1)
 			runningChecks = append(runningChecks, check)
+			if checkName == "captive_portal" {
+				check.start()
+				continue
+			}
+			wg.Add(1)
 
 			go func(check NetiscopeCheck) {
 				defer wg.Done()
@@ -119,6 +157,88 @@ func ExecuteChecks(checksToDo []string) {
 	wg.Wait()
 }
 
+// ExecuteChecksOnEachInterface runs the checks once on each usable network interface,
+// then compares the results side by side
+func ExecuteChecksOnEachInterface(checksToDo []string) {
+	interfaces := UsableInterfaces()
+	if len(interfaces) == 0 {
+		AdminCheck.log(LogLevelWarning, "NO_INTERFACES", "No usable network interfaces found")
+		return
+	}
+
+	results := make(map[string]map[string][7]int)
+	for _, iface := range interfaces {
+		if stopRequested {
+			break
+		}
+		util.SetRunInterface(iface)
+		AdminCheck.log(LogLevelAdmin, "INTERFACE", fmt.Sprintf("Running the checks on interface %s", iface))
+
+		runCountersMutex.Lock()
+		runCounters = make(map[string]*[7]int)
+		runCountersMutex.Unlock()
+
+		ExecuteChecks(checksToDo)
+
+		runCountersMutex.Lock()
+		results[iface] = make(map[string][7]int)
+		for check, counters := range runCounters {
+			results[iface][check] = *counters
+		}
+		runCounters = nil
+		runCountersMutex.Unlock()
+	}
+	util.SetRunInterface("")
+
+	reportInterfaceComparison(checksToDo, interfaces, results)
+}
+
+// count a finding of a check in the current run, if counting is on
+func countFinding(check string, level LogLevelType) {
+	runCountersMutex.Lock()
+	defer runCountersMutex.Unlock()
+	if runCounters == nil || check == AdminCheck.name {
+		return
+	}
+	if runCounters[check] == nil {
+		runCounters[check] = new([7]int)
+	}
+	runCounters[check][level]++
+}
+
+// show the number of warnings and errors of each check on each interface side by side
+func reportInterfaceComparison(checksToDo []string, interfaces []string, results map[string]map[string][7]int) {
+	header := append([]string{"CHECK"}, interfaces...)
+	var table [][]string
+	for _, check := range append(slices.Clone(checksToDo), "total") {
+		row := []string{check}
+		for _, iface := range interfaces {
+			counters, ran := results[iface][check]
+			if check == "total" {
+				ran = len(results[iface]) > 0
+				for _, checkCounters := range results[iface] {
+					counters[LogLevelWarning] += checkCounters[LogLevelWarning]
+					counters[LogLevelError] += checkCounters[LogLevelError] + checkCounters[LogLevelFatal]
+				}
+			} else {
+				counters[LogLevelError] += counters[LogLevelFatal]
+			}
+			switch {
+			case !ran:
+				row = append(row, "-")
+			case counters[LogLevelWarning] == 0 && counters[LogLevelError] == 0:
+				row = append(row, "ok")
+			default:
+				row = append(row, fmt.Sprintf("%d warnings, %d errors", counters[LogLevelWarning], counters[LogLevelError]))
+			}
+		}
+		table = append(table, row)
+	}
+	for _, line := range formatTable(header, table) {
+		AdminCheck.log(LogLevelAdmin, "INTERFACE_SUMMARY", line)
+	}
+}
+
 func PrintResults() {
 	jsonFormat := util.UseJSONFormat()
 	levelCounter := make([]int, 7)
@@ -149,6 +269,30 @@ func initializeCheckByName(name string) (NetiscopeCheck, bool) {
 		check = &DNSOpenResolverCheck{netiscopeCheckBase: data}
 	case "dns_root_servers":
 		check = &DNSRootServersCheck{netiscopeCheckBase: data}
+	case "dns_root_zone":
+		check = &DNSRootZoneCheck{netiscopeCheckBase: data}
+	case "dns_trace":
+		check = &DNSTraceCheck{netiscopeCheckBase: data}
+	case "dns_benchmark":
+		check = &DNSBenchmarkCheck{netiscopeCheckBase: data}
+	case "dns_resolver_capabilities":
+		check = &DNSResolverCapabilitiesCheck{netiscopeCheckBase: data}
+	case "dns_ecs_leak":
+		check = &DNSECSLeakCheck{netiscopeCheckBase: data}
+	case "dns_randomisation":
+		check = &DNSRandomisationCheck{netiscopeCheckBase: data}
+	case "encrypted_dns_blocking":
+		check = &EncryptedDNSBlockingCheck{netiscopeCheckBase: data}
+	case "local_name_resolution":
+		check = &LocalNameResolutionCheck{netiscopeCheckBase: data}
+	case "path_mtu_icmp":
+		check = &PathMTUICMPCheck{netiscopeCheckBase: data}
+	case "traceroute":
+		check = &TracerouteCheck{netiscopeCheckBase: data}
+	case "stun":
+		check = &STUNCheck{netiscopeCheckBase: data}
+	case "egress_identity":
+		check = &EgressIdentityCheck{netiscopeCheckBase: data}
 	case "port_filtering":
 		check = &PortFilteringCheck{netiscopeCheckBase: data}
 	case "doh_providers":
@@ -157,6 +301,12 @@ func initializeCheckByName(name string) (NetiscopeCheck, bool) {
 		check = &SSHHostKeysCheck{netiscopeCheckBase: data}
 	case "path_mtu_http":
 		check = &PathMTUHTTPCheck{netiscopeCheckBase: data}
+	case "captive_portal":
+		check = &CaptivePortalCheck{netiscopeCheckBase: data}
+	case "happy_eyeballs":
+		check = &HappyEyeballsCheck{netiscopeCheckBase: data}
+	case "popular_services":
+		check = &PopularServicesCheck{netiscopeCheckBase: data}
 	default:
 		return nil, false
 	}
@@ -165,6 +315,7 @@ func initializeCheckByName(name string) (NetiscopeCheck, bool) {
 
 func Start(ver string) {
 	version = ver
+	stopRequested = false
 	AdminCheck.log(LogLevelAdmin, "START", fmt.Sprintf("Started (version %s, %s)", version, runtime.Version()))
 }
 
@@ -177,6 +328,7 @@ func Finish(closeChannel bool) {
 
 func Stop() {
 	AdminCheck.log(LogLevelAdmin, "STOP", "Stopping checks")
+	stopRequested = true
 	for _, check := range runningChecks {
 		check.stop()
 	}
@@ -190,6 +342,15 @@ func CheckIPForNetwork(
 	checkCDN bool,
 	logExtra string,
 ) {
+	// special addresses (like private or loopback ones) in answers are a sign of filtering or DNS rebinding
+	if class := util.ClassifyIP(ip); !class.IsGlobal() {
+		check.log(
+			LogLevelWarning,
+			"NETWORK_SPECIAL_ADDRESS",
+			fmt.Sprintf("The IP %s for %s is a special address (%s)%s", ip, network, class, logExtra),
+		)
+	}
+
 	contains, err := util.IsIPInNetworkCIDRBlock(ip, network)
 	switch {
 	case err != nil:
@@ -1,10 +1,14 @@
 package checks
 
 import (
+	"crypto/tls"
+	"encoding/hex"
 	"fmt"
 	"net"
-	"os"
+	"net/netip"
+	"slices"
 	"strings"
+	"time"
 
 	"github.com/miekg/dns"
 )
@@ -14,9 +18,37 @@ import (
   much of the client code is reused from the examples in https://github.com/miekg/exdns/
 */
 
+// DNSQueryOptions collects the settings of a DNS query
+// NSID: ask for NSID?
+// RD: ask for recursion?
+// DO: ask for DNSSEC records (DNSSEC OK)?
+// CD: ask the resolver not to validate (checking disabled)?
+// ZeroID: set query ID to zero? usually no, but DoH prefers that
+// TCP: use TCP instead of UDP?
+// TLS: use DNS over TLS (port 853 unless the server specifies one)?
+// TLSServerName: the name to verify the server certificate against in case of TLS
+// EDNS: add an EDNS OPT record even if no option needs it?
+// UDPSize: the EDNS buffer size to advertise (default: 4096)
+// Cookie: a client cookie (hex encoded) to send, if not empty
+// ClientSubnet: an EDNS client subnet (CIDR) to send, if not empty
+type DNSQueryOptions struct {
+	NSID          bool
+	RD            bool
+	DO            bool
+	CD            bool
+	ZeroID        bool
+	TCP           bool
+	TLS           bool
+	TLSServerName string
+	EDNS          bool
+	UDPSize       uint16
+	Cookie        string
+	ClientSubnet  string
+}
+
 // DNSQuery handles a DNS query/response against a particular server/resolver
 // target: is the name to look up
-// qType: is either A or AAAA
+// qType: is the query type, optionally prefixed by the class (see prepareDNSQuery)
 // server: is the resolver we're asking
 // nsid: ask for NSID?
 // rd: ask for recursion?
@@ -24,6 +56,7 @@ import (
 // zeroID: set query ID to zero? usually no, but DoH prefers that
 // @return:
 // result: a list of results and options (IPs or SOA or NSID records and such)
+// as well as the RTT of the query; this is filled in even if the response code is an error
 // dnserror: code upon error
 func DNSQuery(
 	check *netiscopeCheckBase,
@@ -35,50 +68,122 @@ func DNSQuery(
 	do bool,
 	zeroID bool,
 ) (result map[string][]string, dnserror error) {
+	return DNSQueryWithOptions(
+		check, target, qType, server,
+		DNSQueryOptions{NSID: nsid, RD: rd, DO: do, ZeroID: zeroID},
+	)
+}
 
-	// TODO the result is not really flexible enough
+// DNSQueryWithOptions is like DNSQuery but allows all options to be set
+// a truncated UDP response is retried over TCP
+func DNSQueryWithOptions(
+	check *netiscopeCheckBase,
+	target string,
+	qType string,
+	server string,
+	options DNSQueryOptions,
+) (result map[string][]string, dnserror error) {
 
-	dnserror = nil
-	server = net.JoinHostPort(server, "53")
+	// TODO the result is not really flexible enough, DNSQueryRaw gives the records themselves
+
+	response, rtt, dnserror := DNSQueryRaw(check, target, qType, server, options)
+	if response == nil {
+		return
+	}
+	result = parseDNSResponse(check, response)
+	result["RTT"] = []string{rtt.String()}
+	return
+}
 
-	query := prepareDNSQuery(check, target, qType, nsid, rd, do, zeroID)
+// DNSQueryRaw is like DNSQueryWithOptions but returns the response itself, for the records
+// that don't fit into the result list, like the parameters of SVCB records
+// the response is returned even if the response code is an error
+func DNSQueryRaw(
+	check *netiscopeCheckBase,
+	target string,
+	qType string,
+	server string,
+	options DNSQueryOptions,
+) (response *dns.Msg, rtt time.Duration, dnserror error) {
 
-	c := new(dns.Client)
-	c.Net = "udp"
+	if options.TLS {
+		server = dnsServerAddressWithPort(server, "853")
+	} else {
+		server = dnsServerAddress(server)
+	}
 
-	response, rtt, err := c.Exchange(&query, server)
+	query, err := prepareDNSQuery(check, target, qType, options)
 	if err != nil {
 		dnserror = err
 		return
 	}
+
+	transport := NewTransport("", 0)
+	network := "udp"
+	if options.TCP {
+		network = "tcp"
+	}
+	if options.TLS {
+		network = "tcp-tls"
+	}
+	c := transport.DNSClient(network)
+	if options.TLS {
+		c.TLSConfig = &tls.Config{ServerName: options.TLSServerName}
+	}
+
+	response, rtt, err = transport.Exchange(c, &query, server)
+	if err == nil && response.Truncated && network == "udp" {
+		check.log(
+			LogLevelDetail,
+			"DNS_QUERY_TRUNCATED",
+			fmt.Sprintf("Response from %s was truncated, retrying over TCP", server),
+		)
+		c = transport.DNSClient("tcp")
+		response, rtt, err = transport.Exchange(c, &query, server)
+	}
+	if err != nil {
+		return nil, rtt, err
+	}
 	if response.Id != query.Id {
-		dnserror = fmt.Errorf("DNS ID mismatch (%v vs %v)", response.Id, query.Id)
-		return
+		return nil, rtt, fmt.Errorf("DNS ID mismatch (%v vs %v)", response.Id, query.Id)
 	}
+
 	if response.Rcode != dns.RcodeSuccess {
 		dnserror = fmt.Errorf("DNS response error (%v)", dns.RcodeToString[response.Rcode])
 		return
 	}
 
-	result = parseDNSResponse(check, response)
-
 	check.log(
 		LogLevelDetail,
 		"DNS_QUERY_STATS",
-		fmt.Sprintf("Query time: %v, server: %s (%s), size: %d bytes", rtt, server, c.Net, response.Len()),
+		fmt.Sprintf("Query time: %v, server: %s (%s, %s), size: %d bytes", rtt, server, c.Net, transport.Connection(), response.Len()),
 	)
 
 	return
 }
 
+// dnsServerAddress turns a server into host:port form, using port 53 unless
+// the server already specifies a port (as in "[::1]:5353" or "127.0.0.1:5353")
+func dnsServerAddress(server string) string {
+	return dnsServerAddressWithPort(server, "53")
+}
+
+// dnsServerAddressWithPort is like dnsServerAddress but with a different default port
+func dnsServerAddressWithPort(server string, port string) string {
+	if _, _, err := net.SplitHostPort(server); err == nil {
+		return server
+	}
+	return net.JoinHostPort(server, port)
+}
+
 // CreateDNSQuery creates a DNS query and returns its on-the-wire encoding
 // target: is the name to look up
-// qType: is either A or AAAA
+// qType: is the query type, optionally prefixed by the class (see prepareDNSQuery)
 // nsid: ask for NSID?
 // rd: ask for recursion?
 // do: ask for DNSSEC OK?
 // zeroID: use zero as query ID?
-// @return: an assembled DNS query in on-the-wire format
+// @return: an assembled DNS query in on-the-wire format, or an error
 func CreateDNSQuery(
 	check *netiscopeCheckBase,
 	target string,
@@ -87,12 +192,16 @@ func CreateDNSQuery(
 	rd bool,
 	do bool,
 	zeroID bool,
-) []byte {
+) ([]byte, error) {
 
-	query := prepareDNSQuery(check, target, qType, nsid, rd, do, zeroID)
-	buf, _ := query.Pack()
-
-	return buf
+	query, err := prepareDNSQuery(
+		check, target, qType,
+		DNSQueryOptions{NSID: nsid, RD: rd, DO: do, ZeroID: zeroID},
+	)
+	if err != nil {
+		return nil, err
+	}
+	return query.Pack()
 }
 
 // ParseDNSResponse takes an on-the-wire response and extracts the results we're interested in
@@ -115,77 +224,120 @@ func ParseDNSResponse(
 }
 
 // prepare a DNS query from a given set of parameters
-// target: is the name to look up
-// qType: is either A or AAAA
-// nsid: ask for NSID?
-// rd: ask for recursion?
-// do: ask for DNSSEC OK?
-// zeroID: use zero as query ID?
-// @return: the DNS query (using the type of the underlying DNS package)
+// target: is the name to look up; its case is kept as is
+// qType: is the query type (A, AAAA, TXT, MX, HTTPS, ...), optionally prefixed
+// by the class like "CH TXT" (the default class is IN)
+// options: see DNSQueryOptions
+// @return: the DNS query (using the type of the underlying DNS package) or an error
 func prepareDNSQuery(
 	check *netiscopeCheckBase,
 	target string,
 	qType string,
-	nsid bool,
-	rd bool,
-	do bool,
-	zeroID bool,
-) dns.Msg {
+	options DNSQueryOptions,
+) (query dns.Msg, err error) {
 
-	query := dns.Msg{
+	query = dns.Msg{
 		MsgHdr: dns.MsgHdr{
-			RecursionDesired: rd,
+			RecursionDesired: options.RD,
+			CheckingDisabled: options.CD,
 		},
 		Question: make([]dns.Question, 1),
 	}
 	query.Rcode = dns.RcodeSuccess
 
-	qt := dns.TypeA
-	qc := dns.ClassINET
-	switch qType {
-	case "A":
-		qt = dns.TypeA
-	case "AAAA":
-		qt = dns.TypeAAAA
-	case "TXT":
-		qt = dns.TypeTXT
-		qc = dns.ClassCHAOS
-	case "SOA":
-		qt = dns.TypeSOA
-	case "NS":
-		qt = dns.TypeNS
-	default:
+	qt, qc, err := parseDNSQueryType(qType)
+	if err != nil {
 		check.log(
-			LogLevelFatal,
-			"DNS",
-			fmt.Sprintf("Don't know how to query DNS for %s", qType),
+			LogLevelError,
+			"DNS_UNSUPPORTED_QUERY_TYPE",
+			fmt.Sprintf("Don't know how to query DNS for %s: %v", qType, err),
 		)
-		panic(1)
+		return
 	}
 
-	// NSID
-	if nsid {
-		o := &dns.OPT{
-			Hdr: dns.RR_Header{
-				Name:   ".",
-				Rrtype: dns.TypeOPT,
-			},
+	// EDNS options go to an OPT record
+	o := &dns.OPT{
+		Hdr: dns.RR_Header{
+			Name:   ".",
+			Rrtype: dns.TypeOPT,
+		},
+	}
+	if options.NSID {
+		o.Option = append(o.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID})
+	}
+	if options.Cookie != "" {
+		o.Option = append(o.Option, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: options.Cookie})
+	}
+	if options.ClientSubnet != "" {
+		subnet, perr := netip.ParsePrefix(options.ClientSubnet)
+		if perr != nil {
+			err = fmt.Errorf("invalid client subnet %s: %v", options.ClientSubnet, perr)
+			return
 		}
-		e := &dns.EDNS0_NSID{
-			Code: dns.EDNS0NSID,
+		family := uint16(1)
+		if subnet.Addr().Is6() {
+			family = 2
 		}
-		o.Option = append(o.Option, e)
-		o.SetUDPSize(dns.DefaultMsgSize)
+		o.Option = append(o.Option, &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        family,
+			SourceNetmask: uint8(subnet.Bits()),
+			Address:       subnet.Masked().Addr().AsSlice(),
+		})
+	}
+	if options.DO {
+		o.SetDo()
+	}
+	if options.EDNS || options.DO || len(o.Option) > 0 {
+		size := options.UDPSize
+		if size == 0 {
+			size = dns.DefaultMsgSize
+		}
+		o.SetUDPSize(size)
 		query.Extra = append(query.Extra, o)
 	}
 
-	query.Question[0] = dns.Question{Name: dns.Fqdn(target), Qtype: qt, Qclass: uint16(qc)}
+	// the name is not lowercased, to allow testing 0x20 (mixed case) preservation
+	query.Question[0] = dns.Question{Name: dns.Fqdn(target), Qtype: qt, Qclass: qc}
 
-	if !zeroID {
+	if !options.ZeroID {
 		query.Id = dns.Id()
 	}
 
-	return query
+	return
+}
+
+// parse a query type like "AAAA" or "CH TXT" into the type and class to use
+func parseDNSQueryType(qType string) (qt uint16, qc uint16, err error) {
+	qc = dns.ClassINET
+	fields := strings.Fields(strings.ToUpper(qType))
+	switch len(fields) {
+	case 1:
+	case 2:
+		class, ok := dns.StringToClass[fields[0]]
+		if !ok && fields[0] == "CHAOS" {
+			class, ok = dns.ClassCHAOS, true
+		}
+		if !ok {
+			err = fmt.Errorf("unknown class %s", fields[0])
+			return
+		}
+		qc = class
+	default:
+		err = fmt.Errorf("invalid query type")
+		return
+	}
+
+	qt, ok := dns.StringToType[fields[len(fields)-1]]
+	if !ok {
+		err = fmt.Errorf("unknown query type %s", fields[len(fields)-1])
+		return
+	}
+	switch qt {
+	case dns.TypeAXFR, dns.TypeIXFR, dns.TypeOPT, dns.TypeANY, dns.TypeNone:
+		err = fmt.Errorf("unsupported query type %s", fields[len(fields)-1])
+	}
+	return
 }
 
 // parse a DNS response (using the type of the underlying DNS package)
@@ -208,36 +360,87 @@ func parseDNSResponse(
 			result["SOA"] = append(result["SOA"], fmt.Sprintf("%s %d", t.Ns, t.Serial))
 		case *dns.NS:
 			result["NS"] = append(result["NS"], t.Ns)
+		case *dns.CNAME:
+			result["CNAME"] = append(result["CNAME"], t.Target)
+		case *dns.PTR:
+			result["PTR"] = append(result["PTR"], t.Ptr)
+		case *dns.MX:
+			result["MX"] = append(result["MX"], fmt.Sprintf("%d %s", t.Preference, t.Mx))
+		case *dns.TXT:
+			result["TXT"] = append(result["TXT"], strings.Join(t.Txt, ""))
+		case *dns.CAA:
+			result["CAA"] = append(result["CAA"], fmt.Sprintf("%d %s %s", t.Flag, t.Tag, t.Value))
+		case *dns.DNSKEY:
+			result["DNSKEY"] = append(result["DNSKEY"], fmt.Sprintf("%d %d %d %d", t.Flags, t.Protocol, t.Algorithm, t.KeyTag()))
+		case *dns.RRSIG:
+			// signatures are not interesting on their own, but it's good to know they're there
+			result["RRSIG"] = append(result["RRSIG"], dns.TypeToString[t.TypeCovered])
 		default:
-			check.log(
-				LogLevelFatal,
-				"DNS",
-				fmt.Sprintf("Don't know how to handle result type %v", t),
-			)
-			fmt.Fprintf(os.Stderr, "Don't know how to handle result type (%s)\n", answer.Header())
-			panic(1)
+			// anything else (like HTTPS or SVCB) is reported in presentation format
+			rrtype := dns.TypeToString[answer.Header().Rrtype]
+			rdata := strings.TrimPrefix(answer.String(), answer.Header().String())
+			result[rrtype] = append(result[rrtype], rdata)
 		}
 	}
 
+	// NS records in the authority section are (usually) a referral
 	for _, answer := range response.Ns {
 		switch t := answer.(type) {
 		case *dns.NS:
 			result["NS"] = append(result["NS"], t.Ns)
+			if !slices.Contains(result["REFERRAL"], t.Hdr.Name) {
+				result["REFERRAL"] = append(result["REFERRAL"], t.Hdr.Name)
+			}
 		}
 	}
 
+	// addresses in the additional section are glue: "name address"
 	for _, extra := range response.Extra {
-		// TODO find a way to extract NSID more elegantly than this hack
-		s := extra.String()
-		for _, line := range strings.Split(s, "\n") {
-			if strings.HasPrefix(line, "; NSID:") {
-				for _, token := range strings.Split(line, " ") {
-					if strings.HasPrefix(token, "(") {
-						s1 := strings.ReplaceAll(token, "(", "")
-						s2 := strings.ReplaceAll(s1, ")", "")
-						result["NSID"] = append(result["NSID"], s2)
-					}
+		switch t := extra.(type) {
+		case *dns.A:
+			result["GLUE"] = append(result["GLUE"], t.Hdr.Name+" "+t.A.String())
+		case *dns.AAAA:
+			result["GLUE"] = append(result["GLUE"], t.Hdr.Name+" "+t.AAAA.String())
+		}
+	}
+
+	result["RCODE"] = []string{dns.RcodeToString[response.Rcode]}
+
+	// header flags that were set in the response
+	flags := map[string]bool{
+		"aa": response.Authoritative,
+		"tc": response.Truncated,
+		"rd": response.RecursionDesired,
+		"ra": response.RecursionAvailable,
+		"ad": response.AuthenticatedData,
+		"cd": response.CheckingDisabled,
+	}
+	for _, flag := range []string{"aa", "tc", "rd", "ra", "ad", "cd"} {
+		if flags[flag] {
+			result["FLAGS"] = append(result["FLAGS"], flag)
+		}
+	}
+
+	// the question as it was returned, to see if the case of the name was kept
+	for _, question := range response.Question {
+		result["QNAME"] = append(result["QNAME"], question.Name)
+	}
+
+	// EDNS: buffer size and the interesting options
+	if opt := response.IsEdns0(); opt != nil {
+		result["EDNS"] = []string{fmt.Sprint(opt.UDPSize())}
+		for _, option := range opt.Option {
+			switch e := option.(type) {
+			case *dns.EDNS0_NSID:
+				nsid, err := hex.DecodeString(e.Nsid)
+				if err != nil {
+					nsid = []byte(e.Nsid)
 				}
+				result["NSID"] = append(result["NSID"], string(nsid))
+			case *dns.EDNS0_COOKIE:
+				result["COOKIE"] = append(result["COOKIE"], e.Cookie)
+			case *dns.EDNS0_SUBNET:
+				result["ECS"] = append(result["ECS"], fmt.Sprintf("%s/%d/%d", e.Address, e.SourceNetmask, e.SourceScope))
 			}
 		}
 	}
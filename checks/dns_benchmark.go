@@ -0,0 +1,241 @@
+package checks
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// DNSBenchmarkCheck measures the latency of local, open and DoH resolvers
+type DNSBenchmarkCheck struct {
+	netiscopeCheckBase
+}
+
+// Start executes the DNS resolver benchmark
+func (check *DNSBenchmarkCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	name := util.GetDNSBenchmarkName()
+	if name == "" {
+		check.log(LogLevelFatal, "DNS_BENCHMARK_NO_NAME", "There is no name to use for benchmarking")
+		return
+	}
+
+	check.benchmarkLocalResolvers(name)
+	check.benchmarkOpenResolvers(name)
+	check.benchmarkDoHProviders(name)
+
+	check.netiscopeCheckBase.finish()
+}
+
+// benchmark the resolvers listed in resolv.conf
+func (check *DNSBenchmarkCheck) benchmarkLocalResolvers(name string) {
+	rc, err := parseResolvConf(util.GetResolvConfPath())
+	if err != nil {
+		check.log(LogLevelError, "NO_RESOLV_CONF", "Could not load DNS resolver data from resolv.conf")
+		return
+	}
+	for _, resolver := range filterResolversByAF(rc.resolversV4, rc.resolversV6) {
+		check.benchmarkDNSResolver("local", resolver, name)
+	}
+}
+
+// benchmark the configured open resolvers
+func (check *DNSBenchmarkCheck) benchmarkOpenResolvers(name string) {
+	for _, provider := range util.GetOpenResolverList() {
+		_, v4list, v6list, err := parseOpenResolverProvider(provider)
+		if err != nil {
+			check.log(LogLevelError, "INVALID_OPEN_DNS_RESOLVER", err.Error())
+			continue
+		}
+		for _, resolver := range filterResolversByAF(v4list, v6list) {
+			check.benchmarkDNSResolver("open", resolver, name)
+		}
+	}
+}
+
+// benchmark the configured DoH providers
+func (check *DNSBenchmarkCheck) benchmarkDoHProviders(name string) {
+	for _, provider := range util.GetDoHProviders() {
+		if len(provider) < 3 {
+			continue
+		}
+		af, format, pbase := provider[0], provider[1], provider[2]
+		if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) {
+			continue
+		}
+
+		qtype := "A"
+		if af == "6" {
+			qtype = "AAAA"
+		}
+		// the connection is reused between queries, like a DoH client does
+		transport := NewTransport(af, time.Duration(util.GetDNSBenchmarkTimeout())*time.Millisecond)
+		client := transport.HTTPClient()
+		check.benchmarkResolver(
+			"DoH", fmt.Sprintf("%s (%s, IPv%s)", pbase, format, af), name,
+			func(qname string) (time.Duration, error) {
+				req, err := newDoHRequest(&check.netiscopeCheckBase, format, pbase, qtype, qname)
+				if err != nil {
+					return 0, err
+				}
+				started := time.Now()
+				resp, err := client.Do(req)
+				if err != nil {
+					return 0, err
+				}
+				defer resp.Body.Close()
+				if _, err = io.ReadAll(resp.Body); err != nil {
+					return 0, err
+				}
+				if resp.StatusCode != http.StatusOK {
+					return 0, fmt.Errorf("HTTP status %s", resp.Status)
+				}
+				return time.Since(started), nil
+			},
+		)
+		transport.CloseIdleConnections()
+	}
+}
+
+// benchmark one plain DNS resolver
+func (check *DNSBenchmarkCheck) benchmarkDNSResolver(kind string, resolver string, name string) {
+	check.benchmarkResolver(
+		kind, resolver, name,
+		func(qname string) (time.Duration, error) {
+			answers, err := DNSQuery(&check.netiscopeCheckBase, qname, "A", resolver, false, true, false, false)
+			// an error response (like NXDOMAIN for cache busting queries) is still an answer
+			rtt, perr := time.ParseDuration(firstOrEmpty(answers["RTT"]))
+			if perr != nil {
+				return 0, err
+			}
+			return rtt, nil
+		},
+	)
+}
+
+// benchmark a resolver with repeated (likely cached) and cache busting queries
+// kind: what kind of resolver this is (local, open, DoH)
+// resolver: the resolver's name, for logging
+// name: the name to query
+// query: makes one query and returns its RTT
+func (check *DNSBenchmarkCheck) benchmarkResolver(
+	kind string,
+	resolver string,
+	name string,
+	query func(qname string) (time.Duration, error),
+) {
+	check.log(
+		LogLevelInfo,
+		"DNS_BENCHMARK_RESOLVER",
+		fmt.Sprintf("Benchmarking %s resolver %s", kind, resolver),
+	)
+
+	count := util.GetDNSBenchmarkQueries()
+	for _, series := range []string{"repeated", "cache busting"} {
+		var samples []time.Duration
+		timeouts := 0
+		for i := 0; i < count; i++ {
+			if check.stopping {
+				return
+			}
+			qname := name
+			if series == "cache busting" {
+				qname = randomLabel(12) + "." + util.GetDNSBenchmarkCacheBustingDomain()
+			}
+			rtt, err := query(qname)
+			if err != nil {
+				if isTimeout(err) {
+					timeouts++
+				}
+				check.log(
+					LogLevelDetail,
+					"DNS_BENCHMARK_QUERY_ERROR",
+					fmt.Sprintf("Query for %s via %s resolver %s failed: %v", qname, kind, resolver, err),
+				)
+				continue
+			}
+			samples = append(samples, rtt)
+		}
+
+		stats := ComputeLatencyStats(samples, count)
+		check.log(
+			LogLevelInfo,
+			"DNS_BENCHMARK_STATS",
+			fmt.Sprintf("%s resolver %s, %s queries: %s, %d timed out", kind, resolver, series, stats, timeouts),
+		)
+		check.evaluateBenchmark(kind, resolver, series, stats, timeouts)
+	}
+}
+
+// compare the benchmark results to the configured thresholds
+// timeouts: how many of the queries timed out, other failures (like refused connections) don't count
+func (check *DNSBenchmarkCheck) evaluateBenchmark(
+	kind string,
+	resolver string,
+	series string,
+	stats LatencyStats,
+	timeouts int,
+) {
+	if stats.Received == 0 {
+		check.log(
+			LogLevelError,
+			"DNS_BENCHMARK_NO_ANSWERS",
+			fmt.Sprintf("%s resolver %s did not answer any of the %s queries", kind, resolver, series),
+		)
+		return
+	}
+
+	level := LogLevelType(LogLevelInfo)
+	var reasons []string
+	judge := func(what string, value float64, unit string, warning int, errorLimit int) {
+		switch {
+		case errorLimit > 0 && value > float64(errorLimit):
+			level = LogLevelError
+			reasons = append(reasons, fmt.Sprintf("%s %.0f%s is above %d%s", what, value, unit, errorLimit, unit))
+		case warning > 0 && value > float64(warning):
+			level = max(level, LogLevelWarning)
+			reasons = append(reasons, fmt.Sprintf("%s %.0f%s is above %d%s", what, value, unit, warning, unit))
+		}
+	}
+	judge("median", float64(stats.Median.Milliseconds()), "ms", util.GetDNSBenchmarkThreshold("median_warning", 100), util.GetDNSBenchmarkThreshold("median_error", 500))
+	judge("p95", float64(stats.P95.Milliseconds()), "ms", util.GetDNSBenchmarkThreshold("p95_warning", 300), util.GetDNSBenchmarkThreshold("p95_error", 1000))
+	judge("timeout rate", 100*float64(timeouts)/float64(stats.Sent), "%", util.GetDNSBenchmarkThreshold("timeout_warning", 0), util.GetDNSBenchmarkThreshold("timeout_error", 20))
+
+	if level == LogLevelInfo {
+		check.log(
+			LogLevelInfo,
+			"DNS_BENCHMARK_OK",
+			fmt.Sprintf("%s resolver %s is fast enough for %s queries", kind, resolver, series),
+		)
+		return
+	}
+	check.log(
+		level,
+		"DNS_BENCHMARK_SLOW",
+		fmt.Sprintf("%s resolver %s is slow for %s queries: %s", kind, resolver, series, strings.Join(reasons, ", ")),
+	)
+}
+
+// tell if a query failed because there was no answer in time
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// generate a random DNS label of a given length
+func randomLabel(length int) string {
+	chars := []rune("abcdefghijklmnopqrstuvwxyz0123456789")
+	var builder strings.Builder
+	for i := 0; i < length; i++ {
+		builder.WriteRune(chars[rand.Intn(len(chars))])
+	}
+	return builder.String()
+}
@@ -0,0 +1,61 @@
+package checks
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestIsTimeout(t *testing.T) {
+	// a resolver that never answers, and a port where nothing listens
+	silent, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silent.Close()
+	silentTCP, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer silentTCP.Close()
+	closed := net.JoinHostPort("127.0.0.1", strconv.Itoa(freeTCPPort(t)))
+
+	exchange := func(server string) error {
+		transport := NewTransport("4", 100*time.Millisecond)
+		query := new(dns.Msg)
+		query.SetQuestion("example.com.", dns.TypeA)
+		_, _, err := transport.Exchange(transport.DNSClient("udp"), query, server)
+		return err
+	}
+	get := func(address string) error {
+		client := NewTransport("4", 100*time.Millisecond).HTTPClient()
+		_, err := client.Get("http://" + address + "/")
+		return err
+	}
+
+	tests := []struct {
+		name    string
+		err     error
+		timeout bool
+	}{
+		{"DNS query without an answer", exchange(silent.LocalAddr().String()), true},
+		{"DNS query refused", exchange(closed), false},
+		{"DoH query without an answer", get(silentTCP.Addr().String()), true},
+		{"DoH connection refused", get(closed), false},
+		{"DNS error response", errors.New("DNS response error (SERVFAIL)"), false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if test.err == nil {
+				t.Fatal("the query did not fail")
+			}
+			if isTimeout(test.err) != test.timeout {
+				t.Errorf("timeout(%v) = %v, want %v", test.err, !test.timeout, test.timeout)
+			}
+		})
+	}
+}
@@ -0,0 +1,297 @@
+package checks
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/netip"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+/*
+  Discovery of Designated Resolvers (DDR, RFC 9462): a resolver can advertise
+  its encrypted (DoH, DoT) endpoints via SVCB records of _dns.resolver.arpa
+*/
+
+const ddrName = "_dns.resolver.arpa"
+const ddrTimeout = 10 * time.Second
+
+// designatedResolver is an encrypted endpoint advertised by a resolver
+type designatedResolver struct {
+	priority uint16
+	target   string
+	alpn     []string
+	port     uint16
+	dohPath  string
+	hints    []string
+}
+
+// discover and test the designated resolvers of each local resolver
+func (check *DNSLocalResolversCheck) discoverDesignatedResolvers() {
+	for _, resolver := range filterResolversByAF(check.rcResolversV4, check.rcResolversV6) {
+		if check.stopping {
+			return
+		}
+
+		var records []*dns.SVCB
+		response, _, err := DNSQueryRaw(&check.netiscopeCheckBase, ddrName, "SVCB", resolver, DNSQueryOptions{RD: true})
+		if err == nil {
+			for _, rr := range response.Answer {
+				if svcb, ok := rr.(*dns.SVCB); ok {
+					records = append(records, svcb)
+				}
+			}
+		}
+		if len(records) == 0 {
+			check.log(
+				LogLevelInfo,
+				"DDR_NOT_SUPPORTED",
+				fmt.Sprintf("Resolver %s does not advertise designated resolvers", resolver),
+			)
+			continue
+		}
+
+		for _, svcb := range records {
+			dr := newDesignatedResolver(svcb)
+			if dr.priority == 0 {
+				check.log(
+					LogLevelDetail,
+					"DDR_ALIAS_MODE",
+					fmt.Sprintf("Resolver %s advertises an alias to %s, which is not used for DDR", resolver, dr.target),
+				)
+				continue
+			}
+			check.log(
+				LogLevelInfo,
+				"DDR_DESIGNATED_RESOLVER",
+				fmt.Sprintf(
+					"Resolver %s advertises designated resolver %s (priority %d, alpn %v, port %d, dohpath %+q, hints %v)",
+					resolver, dr.target, dr.priority, dr.alpn, dr.port, dr.dohPath, dr.hints,
+				),
+			)
+			check.testDesignatedResolver(resolver, dr)
+		}
+	}
+}
+
+// take the priority, target and parameters of an SVCB record
+func newDesignatedResolver(svcb *dns.SVCB) (dr designatedResolver) {
+	dr.priority = svcb.Priority
+	dr.target = strings.TrimSuffix(svcb.Target, ".")
+	for _, value := range svcb.Value {
+		switch v := value.(type) {
+		case *dns.SVCBAlpn:
+			dr.alpn = v.Alpn
+		case *dns.SVCBPort:
+			dr.port = v.Port
+		case *dns.SVCBDoHPath:
+			dr.dohPath = v.Template
+		case *dns.SVCBIPv4Hint:
+			for _, ip := range v.Hint {
+				dr.hints = append(dr.hints, ip.String())
+			}
+		case *dns.SVCBIPv6Hint:
+			for _, ip := range v.Hint {
+				dr.hints = append(dr.hints, ip.String())
+			}
+		}
+	}
+	return
+}
+
+// verify and test the DoH and DoT endpoints of a designated resolver
+func (check *DNSLocalResolversCheck) testDesignatedResolver(resolver string, dr designatedResolver) {
+	addr := check.designatedResolverAddress(resolver, dr)
+	if addr == "" {
+		check.log(
+			LogLevelWarning,
+			"DDR_NO_ADDRESS",
+			fmt.Sprintf("Could not find a usable address for designated resolver %s", dr.target),
+		)
+		return
+	}
+
+	doh := dr.dohPath != "" && (slices.Contains(dr.alpn, "h2") || slices.Contains(dr.alpn, "http/1.1"))
+	dot := slices.Contains(dr.alpn, "dot")
+	if !doh && !dot {
+		check.log(
+			LogLevelInfo,
+			"DDR_NO_TESTABLE_PROTOCOL",
+			fmt.Sprintf("Designated resolver %s only offers protocols that are not tested: %v", dr.target, dr.alpn),
+		)
+		return
+	}
+
+	port := dr.designatedPort(doh)
+	check.verifyDesignatedResolver(resolver, dr, net.JoinHostPort(addr, port))
+	if doh {
+		check.testDesignatedDoH(dr, addr, port)
+	}
+	if dot {
+		check.testDesignatedDoT(dr, addr, dr.designatedPort(false))
+	}
+}
+
+// the port of the DoH (doh = true) or DoT endpoint
+func (dr designatedResolver) designatedPort(doh bool) string {
+	switch {
+	case dr.port != 0:
+		return strconv.Itoa(int(dr.port))
+	case doh:
+		return "443"
+	default:
+		return "853"
+	}
+}
+
+// find an address for the designated resolver: one of the hints, or the target looked up via the resolver
+// @return the address or empty string
+func (check *DNSLocalResolversCheck) designatedResolverAddress(resolver string, dr designatedResolver) string {
+	addrs := dr.hints
+	if len(addrs) == 0 {
+		for _, qtype := range []string{"A", "AAAA"} {
+			answers, err := DNSQuery(&check.netiscopeCheckBase, dr.target, qtype, resolver, false, true, false, false)
+			if err == nil {
+				addrs = append(addrs, answers[qtype]...)
+			}
+		}
+	}
+	for _, addr := range addrs {
+		if (util.IsIPv4(addr) && !util.SkipIPv4()) || (util.IsIPv6(addr) && !util.SkipIPv6()) {
+			return addr
+		}
+	}
+	return ""
+}
+
+// RFC 9462 verified discovery: the certificate of the designated resolver has to cover the IP address of the resolver
+func (check *DNSLocalResolversCheck) verifyDesignatedResolver(resolver string, dr designatedResolver, endpoint string) {
+	conn, err := NewTransport("", ddrTimeout).DialTLS(endpoint, &tls.Config{ServerName: dr.target})
+	if err != nil {
+		check.log(
+			LogLevelWarning,
+			"DDR_TLS_ERROR",
+			fmt.Sprintf("TLS connection to designated resolver %s at %s failed: %v", dr.target, endpoint, err),
+		)
+		return
+	}
+	defer conn.Close()
+
+	certs := conn.ConnectionState().PeerCertificates
+	if len(certs) > 0 && certs[0].VerifyHostname(resolver) == nil {
+		check.log(
+			LogLevelInfo,
+			"DDR_VERIFIED",
+			fmt.Sprintf("The certificate of designated resolver %s covers resolver address %s", dr.target, resolver),
+		)
+		return
+	}
+	if !util.ClassifyIP(resolver).IsGlobal() {
+		// RFC 9462 section 4.2: private resolver addresses cannot be in certificates, clients may upgrade without verification
+		check.log(
+			LogLevelInfo,
+			"DDR_NOT_VERIFIED",
+			fmt.Sprintf(
+				"The certificate of designated resolver %s does not cover private resolver address %s: clients can only upgrade opportunistically",
+				dr.target, resolver,
+			),
+		)
+		return
+	}
+	check.log(
+		LogLevelWarning,
+		"DDR_NOT_VERIFIED",
+		fmt.Sprintf(
+			"The certificate of designated resolver %s does not cover resolver address %s: clients should not upgrade automatically",
+			dr.target, resolver,
+		),
+	)
+}
+
+// look up the configured names via the designated DoH endpoint
+func (check *DNSLocalResolversCheck) testDesignatedDoH(dr designatedResolver, addr string, port string) {
+	// the path is a URI template like "/dns-query{?dns}"
+	path, _, _ := strings.Cut(dr.dohPath, "{")
+	url := "https://" + net.JoinHostPort(dr.target, port) + path
+
+	// connect to the address we found, not to what the target may resolve to otherwise
+	transport := NewTransport("", ddrTimeout).WithDestination(netip.MustParseAddr(addr))
+	defer transport.CloseIdleConnections()
+	client := transport.HTTPClient()
+
+	qtype := "A"
+	if util.IsIPv6(addr) {
+		qtype = "AAAA"
+	}
+	for _, name := range util.GetDNSNamesToLookup() {
+		if check.stopping {
+			return
+		}
+		req, err := newDoHRequest(&check.netiscopeCheckBase, "rfc8484", url, qtype, name)
+		if err != nil {
+			check.log(LogLevelError, "DDR_DOH_REQUEST_ERROR", fmt.Sprintf("Error: %v", err))
+			continue
+		}
+		resp, err := client.Do(req)
+		if err != nil {
+			check.log(LogLevelError, "DDR_DOH_GET_ERROR", fmt.Sprintf("Lookup of %s via %s failed: %v", name, url, err))
+			continue
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			check.log(LogLevelError, "DDR_DOH_READ_ERROR", fmt.Sprintf("Lookup of %s via %s failed: %v", name, url, err))
+			continue
+		}
+		addrs, err := parseDoHRFC8484Response(&check.netiscopeCheckBase, body)
+		if err != nil {
+			check.log(LogLevelError, "DDR_DOH_RESULT_ERROR", fmt.Sprintf("Lookup of %s via %s failed: %v", name, url, err))
+			continue
+		}
+		check.log(LogLevelInfo, "DDR_DOH_RESULT_OK", fmt.Sprintf("Result for %s via %s: %v", name, url, addrs))
+		for _, ip := range addrs {
+			CheckIPForNetwork(&check.netiscopeCheckBase, ip, name, true, fmt.Sprintf(" (via designated resolver: %s)", url))
+		}
+	}
+}
+
+// look up the configured names via the designated DoT endpoint
+func (check *DNSLocalResolversCheck) testDesignatedDoT(dr designatedResolver, addr string, port string) {
+	endpoint := net.JoinHostPort(addr, port)
+	qtype := "A"
+	if util.IsIPv6(addr) {
+		qtype = "AAAA"
+	}
+	for _, name := range util.GetDNSNamesToLookup() {
+		if check.stopping {
+			return
+		}
+		answers, err := DNSQueryWithOptions(
+			&check.netiscopeCheckBase, name, qtype, endpoint,
+			DNSQueryOptions{RD: true, TLS: true, TLSServerName: dr.target},
+		)
+		if err != nil {
+			check.log(
+				LogLevelError,
+				"DDR_DOT_ERROR",
+				fmt.Sprintf("Lookup of %s via %s (%s) failed: %v", name, dr.target, endpoint, err),
+			)
+			continue
+		}
+		check.log(
+			LogLevelInfo,
+			"DDR_DOT_RESULT_OK",
+			fmt.Sprintf("Result for %s via %s (%s): %v", name, dr.target, endpoint, answers[qtype]),
+		)
+		for _, ip := range answers[qtype] {
+			CheckIPForNetwork(&check.netiscopeCheckBase, ip, name, true, fmt.Sprintf(" (via designated resolver: %s)", dr.target))
+		}
+	}
+}
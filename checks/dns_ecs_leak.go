@@ -0,0 +1,190 @@
+package checks
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// DNSECSLeakCheck determines if resolvers send client subnet (ECS) information to authoritative servers
+type DNSECSLeakCheck struct {
+	netiscopeCheckBase
+	resolvers []string
+}
+
+// the prefix of ECS echo TXT answers, as used by o-o.myaddr.l.google.com
+const ecsEchoPrefix = "edns0-client-subnet "
+
+func (check *DNSECSLeakCheck) configure() {
+	// explicitly configured resolvers (like a local stand-in) replace the local and open ones
+	check.resolvers = util.GetECSLeakResolvers()
+	if len(check.resolvers) > 0 {
+		return
+	}
+
+	rc, err := parseResolvConf(util.GetResolvConfPath())
+	if err != nil {
+		check.log(LogLevelError, "NO_RESOLV_CONF", "Could not load DNS resolver data from resolv.conf")
+	} else {
+		check.resolvers = append(check.resolvers, filterResolversByAF(rc.resolversV4, rc.resolversV6)...)
+	}
+	for _, provider := range util.GetOpenResolverList() {
+		_, v4list, v6list, err := parseOpenResolverProvider(provider)
+		if err != nil {
+			check.log(LogLevelError, "INVALID_OPEN_DNS_RESOLVER", err.Error())
+			continue
+		}
+		check.resolvers = append(check.resolvers, filterResolversByAF(v4list, v6list)...)
+	}
+}
+
+// Start executes the ECS leak check
+func (check *DNSECSLeakCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	echo := util.GetECSLeakEchoName()
+	if len(echo) != 2 {
+		check.log(LogLevelFatal, "DNS_ECS_CONFIG_ERROR", "Wrong echo name configuration: "+strings.Join(echo, ","))
+		return
+	}
+	name, qtype := echo[0], strings.ToUpper(echo[1])
+
+	var subnets []netip.Prefix
+	for _, subnet := range util.GetECSLeakClientSubnets() {
+		prefix, err := netip.ParsePrefix(subnet)
+		if err != nil {
+			check.log(LogLevelError, "DNS_ECS_CONFIG_ERROR", fmt.Sprintf("Invalid client subnet %s: %v", subnet, err))
+			continue
+		}
+		subnets = append(subnets, prefix.Masked())
+	}
+
+	for _, resolver := range check.resolvers {
+		if check.stopping {
+			break
+		}
+		check.checkWithoutECS(resolver, name, qtype)
+		for _, subnet := range subnets {
+			if check.stopping {
+				break
+			}
+			check.checkWithECS(resolver, name, qtype, subnet)
+		}
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// query without ECS: does the resolver add the client's subnet on its own?
+func (check *DNSECSLeakCheck) checkWithoutECS(resolver string, name string, qtype string) {
+	echoed, ok := check.queryEcho(resolver, name, qtype, "")
+	if !ok {
+		return
+	}
+	if len(echoed) == 0 {
+		check.log(
+			LogLevelInfo,
+			"DNS_ECS_NOT_ADDED",
+			fmt.Sprintf("Resolver %s does not send client subnet information on its own", resolver),
+		)
+		return
+	}
+	for _, prefix := range echoed {
+		check.log(
+			LogLevelWarning,
+			"DNS_ECS_ADDED",
+			fmt.Sprintf(
+				"Resolver %s sends client subnet %s to authoritative servers: a /%d prefix of the client address leaks",
+				resolver, prefix, prefix.Bits(),
+			),
+		)
+	}
+}
+
+// query with ECS: does the resolver forward, truncate, replace or strip the subnet?
+func (check *DNSECSLeakCheck) checkWithECS(resolver string, name string, qtype string, subnet netip.Prefix) {
+	echoed, ok := check.queryEcho(resolver, name, qtype, subnet.String())
+	if !ok {
+		return
+	}
+	if len(echoed) == 0 {
+		check.log(
+			LogLevelInfo,
+			"DNS_ECS_STRIPPED",
+			fmt.Sprintf("Resolver %s does not forward client subnet %s", resolver, subnet),
+		)
+		return
+	}
+	for _, prefix := range echoed {
+		switch {
+		case prefix == subnet:
+			check.log(
+				LogLevelWarning,
+				"DNS_ECS_FORWARDED",
+				fmt.Sprintf("Resolver %s forwards client subnet %s as is: a /%d prefix leaks", resolver, subnet, prefix.Bits()),
+			)
+		case prefix.Bits() < subnet.Bits() && prefix.Contains(subnet.Addr()):
+			check.log(
+				LogLevelWarning,
+				"DNS_ECS_TRUNCATED",
+				fmt.Sprintf("Resolver %s forwards client subnet %s truncated to %s: a /%d prefix leaks", resolver, subnet, prefix, prefix.Bits()),
+			)
+		default:
+			check.log(
+				LogLevelWarning,
+				"DNS_ECS_REPLACED",
+				fmt.Sprintf("Resolver %s replaces client subnet %s with %s: a /%d prefix leaks", resolver, subnet, prefix, prefix.Bits()),
+			)
+		}
+	}
+}
+
+// ask the echo name via a resolver, optionally with a client subnet
+// @return the subnets the authoritative server received, and if the query succeeded at all
+func (check *DNSECSLeakCheck) queryEcho(
+	resolver string,
+	name string,
+	qtype string,
+	subnet string,
+) (echoed []netip.Prefix, ok bool) {
+	answers, err := DNSQueryWithOptions(
+		&check.netiscopeCheckBase, name, qtype, resolver,
+		DNSQueryOptions{RD: true, ClientSubnet: subnet},
+	)
+	if err != nil {
+		check.log(
+			LogLevelWarning,
+			"DNS_ECS_QUERY_ERROR",
+			fmt.Sprintf("Looking up %s %s via %s failed: %v", name, qtype, resolver, err),
+		)
+		return nil, false
+	}
+	if len(answers[qtype]) == 0 {
+		check.log(
+			LogLevelWarning,
+			"DNS_ECS_NO_ANSWER",
+			fmt.Sprintf("Resolver %s returned no %s answer for %s", resolver, qtype, name),
+		)
+		return nil, false
+	}
+
+	for _, answer := range answers[qtype] {
+		value, found := strings.CutPrefix(strings.Trim(answer, "\""), ecsEchoPrefix)
+		if !found {
+			continue
+		}
+		prefix, err := netip.ParsePrefix(strings.TrimSpace(value))
+		if err != nil {
+			check.log(
+				LogLevelDetail,
+				"DNS_ECS_ECHO_INVALID",
+				fmt.Sprintf("Cannot parse echoed client subnet %+q from %s", value, name),
+			)
+			continue
+		}
+		echoed = append(echoed, prefix)
+	}
+	return echoed, true
+}
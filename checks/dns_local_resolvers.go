@@ -27,47 +27,79 @@ func (check *DNSLocalResolversCheck) start() {
 		return
 	}
 	check.testLocalResolvers()
+	if util.GetDDREnabled() {
+		check.discoverDesignatedResolvers()
+	}
 
 	check.netiscopeCheckBase.finish()
 }
 
-// read and collect useful entries from resolv.conf
-// return: success or not
-func (check *DNSLocalResolversCheck) loadResolvers() bool {
-	resolvconf, err := os.Open("/etc/resolv.conf")
+// resolvConf holds the useful entries of resolv.conf
+type resolvConf struct {
+	domain      string
+	resolversV4 []string
+	resolversV6 []string
+	search      []string
+	modTime     time.Time
+}
+
+// read and collect useful entries from a resolv.conf style file
+func parseResolvConf(path string) (rc resolvConf, err error) {
+	resolvconf, err := os.Open(path)
 	if err != nil {
-		return false
+		return
 	}
+	defer resolvconf.Close()
 
 	rcstat, err := resolvconf.Stat()
 	if err != nil {
-		return false
+		return
 	}
-	check.log(
-		LogLevelInfo,
-		"RESOLVCONF_DATE",
-		fmt.Sprintf("resolv.conf was last modified %s ago (at %s)",
-			DurationToHuman(time.Since(rcstat.ModTime())),
-			rcstat.ModTime().Format(time.RFC3339),
-		),
-	)
+	rc.modTime = rcstat.ModTime()
 
 	scanner := bufio.NewScanner(resolvconf)
 	for scanner.Scan() {
-		line := scanner.Text()
-		if strings.HasPrefix(line, "domain") {
-			check.rcDomain = strings.Fields(line)[1]
-		} else if strings.HasPrefix(line, "nameserver") {
-			resolver := strings.Fields(line)[1]
-			if util.IsIPv6(resolver) {
-				check.rcResolversV6 = append(check.rcResolversV6, resolver)
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 {
+			continue
+		}
+		switch fields[0] {
+		case "domain":
+			rc.domain = fields[1]
+		case "nameserver":
+			if util.IsIPv6(fields[1]) {
+				rc.resolversV6 = append(rc.resolversV6, fields[1])
 			} else {
-				check.rcResolversV4 = append(check.rcResolversV4, resolver)
+				rc.resolversV4 = append(rc.resolversV4, fields[1])
 			}
-		} else if strings.HasPrefix(line, "search") {
-			check.rcSearch = append(check.rcSearch, strings.Fields(line)[1])
+		case "search":
+			rc.search = append(rc.search, fields[1:]...)
 		}
 	}
+	err = scanner.Err()
+	return
+}
+
+// read and collect useful entries from resolv.conf
+// return: success or not
+func (check *DNSLocalResolversCheck) loadResolvers() bool {
+	rc, err := parseResolvConf(util.GetResolvConfPath())
+	if err != nil {
+		return false
+	}
+	check.rcDomain = rc.domain
+	check.rcResolversV4 = rc.resolversV4
+	check.rcResolversV6 = rc.resolversV6
+	check.rcSearch = rc.search
+
+	check.log(
+		LogLevelInfo,
+		"RESOLVCONF_DATE",
+		fmt.Sprintf("resolv.conf was last modified %s ago (at %s)",
+			DurationToHuman(time.Since(rc.modTime)),
+			rc.modTime.Format(time.RFC3339),
+		),
+	)
 
 	check.log(LogLevelInfo, "DOMAIN", fmt.Sprintf("Current domain is: %s", check.rcDomain))
 	if !util.SkipIPv4() {
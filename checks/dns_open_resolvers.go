@@ -16,43 +16,47 @@ func (check *DNSOpenResolverCheck) start() {
 
 	providers := util.GetOpenResolverList()
 	for _, provider := range providers {
-		parts := strings.Split(strings.ReplaceAll(provider, " ", ""), ",")
-		if len(parts) < 2 {
-			check.log(
-				LogLevelError,
-				"INVALID_OPEN_DNS_RESOLVER",
-				fmt.Sprintf("Invalid open DNS resolver format for provider %s", provider),
-			)
+		name, v4list, v6list, err := parseOpenResolverProvider(provider)
+		if err != nil {
+			check.log(LogLevelError, "INVALID_OPEN_DNS_RESOLVER", err.Error())
 			continue
 		}
 
-		v4list := []string{}
-		v6list := []string{}
-		for _, part := range parts[1:] {
-			if strings.Contains(part, ":") {
-				v6list = append(v6list, part)
-			} else {
-				v4list = append(v4list, part)
-			}
-		}
-
 		if len(v4list) > 0 {
-			checkOpenResolver(&check.netiscopeCheckBase, parts[0], "IPv4", v4list)
+			checkOpenResolver(&check.netiscopeCheckBase, name, "IPv4", v4list)
 		}
 		if len(v6list) > 0 {
-			checkOpenResolver(&check.netiscopeCheckBase, parts[0], "IPv6", v6list)
-		}
-		if len(v4list) == 0 && len(v6list) == 0 {
-			check.log(
-				LogLevelError,
-				"INVALID_OPEN_DNS_RESOLVER",
-				fmt.Sprintf("No IP addresses defined for open resolver %s", parts[0]),
-			)
+			checkOpenResolver(&check.netiscopeCheckBase, name, "IPv6", v6list)
 		}
 	}
 	check.netiscopeCheckBase.finish()
 }
 
+// parse an open resolver provider definition from the config file
+// e.g. "Google,8.8.8.8,8.8.4.4,2001:4860:4860::8888"
+// @return the name of the provider and its IPv4 and IPv6 addresses
+func parseOpenResolverProvider(provider string) (name string, v4list []string, v6list []string, err error) {
+	parts := strings.Split(strings.ReplaceAll(provider, " ", ""), ",")
+	if len(parts) < 2 {
+		err = fmt.Errorf("Invalid open DNS resolver format for provider %s", provider)
+		return
+	}
+
+	name = parts[0]
+	for _, part := range parts[1:] {
+		if util.IsIPv6(part) {
+			v6list = append(v6list, part)
+		} else {
+			v4list = append(v4list, part)
+		}
+	}
+
+	if len(v4list) == 0 && len(v6list) == 0 {
+		err = fmt.Errorf("No IP addresses defined for open resolver %s", name)
+	}
+	return
+}
+
 func checkOpenResolver(
 	check *netiscopeCheckBase,
 	provider string,
@@ -56,26 +56,36 @@ func (check *DNSOverHTTPSProvidersCheck) start() {
 					qtype = "AAAA"
 				}
 
-				// try to get some results
-				client := &http.Client{}
-				req, err := http.NewRequest("GET", buildDoHQueryURL(check, format, pbase, qtype, name, true), nil)
+				// try to get some results, actually over the address family of the provider
+				transport := NewTransport(af, 0)
+				client := transport.HTTPClient()
+				req, err := newDoHRequest(&check.netiscopeCheckBase, format, pbase, qtype, name)
 				if err != nil {
 					check.log(LogLevelError, "DOH_PROVIDER_REQUEST_ERROR", fmt.Sprintf("Error: %v", err))
 					continue
 				}
-				switch format {
-				case "json":
-					req.Header.Add("Accept", "application/dns-json")
-				case "rfc8484":
-					req.Header.Add("Accept", "application/dns-message")
-				}
 				resp, err := client.Do(req)
 				if err != nil {
-					check.log(LogLevelError, "DOH_PROVIDER_GET_ERROR", fmt.Sprintf("Error: %v", err))
+					transport.CloseIdleConnections()
+					// tell at what stage the request failed: this helps to find out how DoH is blocked
+					mnemonic := "DOH_PROVIDER_GET_ERROR"
+					switch ClassifyConnectionError(err) {
+					case "DNS":
+						mnemonic = "DOH_PROVIDER_DNS_ERROR"
+					case "TIMEOUT":
+						mnemonic = "DOH_PROVIDER_TIMEOUT"
+					case "CONNECT":
+						mnemonic = "DOH_PROVIDER_CONNECT_ERROR"
+					case "TLS":
+						mnemonic = "DOH_PROVIDER_TLS_ERROR"
+					}
+					check.log(LogLevelError, mnemonic, fmt.Sprintf("Error: %v", err))
 					continue
 				}
-				defer resp.Body.Close()
 				body, err := io.ReadAll(resp.Body)
+				resp.Body.Close()
+				// each lookup uses a new connection, don't keep this one open until the end of the check
+				transport.CloseIdleConnections()
 				if err != nil {
 					check.log(LogLevelError, "DOH_PROVIDER_READ_ERROR", fmt.Sprintf("Error: %v", err))
 					continue
@@ -94,7 +104,7 @@ func (check *DNSOverHTTPSProvidersCheck) start() {
 				check.log(
 					LogLevelInfo,
 					fmt.Sprintf("DOH_PROVIDER_LOOKUP_IPV%s_RESULT_OK", af),
-					fmt.Sprintf("Result for %s: %v", name, addrs),
+					fmt.Sprintf("Result for %s (%s): %v", name, transport.Connection(), addrs),
 				)
 
 				// verify if answers are in predefined known CIDR ranges
@@ -108,23 +118,51 @@ func (check *DNSOverHTTPSProvidersCheck) start() {
 	check.netiscopeCheckBase.finish()
 }
 
+// prepare an HTTP request for a DoH query, with the proper URL and headers for the format
+func newDoHRequest(
+	check *netiscopeCheckBase,
+	format string,
+	provider string,
+	qtype string,
+	name string,
+) (*http.Request, error) {
+	url, err := buildDoHQueryURL(check, format, provider, qtype, name, true)
+	if err != nil {
+		return nil, err
+	}
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	switch format {
+	case "json":
+		req.Header.Add("Accept", "application/dns-json")
+	case "rfc8484":
+		req.Header.Add("Accept", "application/dns-message")
+	}
+	return req, nil
+}
+
 // given a format, the provider's base URL and the parameters, build the DoH query URL
 func buildDoHQueryURL(
-	check *DNSOverHTTPSProvidersCheck,
+	check *netiscopeCheckBase,
 	format string,
 	provider string,
 	qtype string,
 	name string,
 	do bool,
-) string {
+) (string, error) {
 	switch format {
 	case "json":
-		return fmt.Sprintf("%s?name=%s&type=%s&do=%v", provider, name, qtype, do)
+		return fmt.Sprintf("%s?name=%s&type=%s&do=%v", provider, name, qtype, do), nil
 	case "rfc8484":
-		wire := CreateDNSQuery(&check.netiscopeCheckBase, name, qtype, true, true, true, true)
-		return fmt.Sprintf("%s?dns=%s", provider, base64.RawURLEncoding.EncodeToString(wire))
+		wire, err := CreateDNSQuery(check, name, qtype, true, true, true, true)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("%s?dns=%s", provider, base64.RawURLEncoding.EncodeToString(wire)), nil
 	default:
-		return ""
+		return "", fmt.Errorf("unknown DoH format %s", format)
 	}
 }
 
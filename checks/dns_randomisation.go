@@ -0,0 +1,193 @@
+package checks
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// DNSRandomisationCheck scores the source port and query ID randomness of resolvers, like DNS-OARC's porttest
+type DNSRandomisationCheck struct {
+	netiscopeCheckBase
+	resolvers []string
+}
+
+// what the randomisation responder reports about one query it received
+type dnsRandomisationSample struct {
+	source string
+	port   int
+	id     int
+	qname  string // in the case it was received, if the responder tells
+}
+
+func (check *DNSRandomisationCheck) configure() {
+	// explicitly configured resolvers (like the responder itself) replace the local ones
+	check.resolvers = util.GetDNSRandomisationResolvers()
+	if len(check.resolvers) > 0 {
+		return
+	}
+
+	rc, err := parseResolvConf(util.GetResolvConfPath())
+	if err != nil {
+		check.log(LogLevelError, "NO_RESOLV_CONF", "Could not load DNS resolver data from resolv.conf")
+		return
+	}
+	check.resolvers = filterResolversByAF(rc.resolversV4, rc.resolversV6)
+}
+
+// Start executes the randomisation check
+func (check *DNSRandomisationCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	zone := util.GetDNSRandomisationZone()
+	if zone == "" {
+		check.log(LogLevelFatal, "DNS_RANDOMISATION_NO_ZONE", "There is no zone served by a randomisation responder defined")
+		return
+	}
+
+	for _, resolver := range check.resolvers {
+		if check.stopping {
+			break
+		}
+		samples := check.sendBurst(resolver, zone, util.GetDNSRandomisationQueries())
+		if len(samples) < 2 {
+			check.log(
+				LogLevelWarning,
+				"DNS_RANDOMISATION_NOT_ENOUGH_ANSWERS",
+				fmt.Sprintf("Resolver %s returned only %d usable answers, cannot score randomisation", resolver, len(samples)),
+			)
+			continue
+		}
+
+		var sources []string
+		var ports, ids []int
+		for _, sample := range samples {
+			if !slices.Contains(sources, sample.source) {
+				sources = append(sources, sample.source)
+			}
+			ports = append(ports, sample.port)
+			ids = append(ids, sample.id)
+		}
+		check.log(
+			LogLevelInfo,
+			"DNS_RANDOMISATION_SOURCES",
+			fmt.Sprintf("Resolver %s queried the responder from %v", resolver, sources),
+		)
+		check.scoreRandomness(resolver, "SOURCE_PORT", "source port", ports)
+		check.scoreRandomness(resolver, "QUERY_ID", "query ID", ids)
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// send a burst of queries for unique names via a resolver
+// @return what the responder saw of the queries that reached it
+func (check *DNSRandomisationCheck) sendBurst(resolver string, zone string, count int) (samples []dnsRandomisationSample) {
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	for i := 0; i < count; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if check.stopping {
+				return
+			}
+			name := randomLabel(12) + "." + zone
+			answers, err := DNSQuery(&check.netiscopeCheckBase, name, "TXT", resolver, false, true, false, false)
+			if err != nil {
+				check.log(
+					LogLevelDetail,
+					"DNS_RANDOMISATION_QUERY_ERROR",
+					fmt.Sprintf("Query for %s via %s failed: %v", name, resolver, err),
+				)
+				return
+			}
+			for _, answer := range answers["TXT"] {
+				sample, err := parseRandomisationAnswer(answer)
+				if err != nil {
+					continue
+				}
+				mutex.Lock()
+				samples = append(samples, sample)
+				mutex.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+	return
+}
+
+// parse a responder answer like "source=192.0.2.1 port=12345 id=54321 qname=abc.porttest.example.net."
+func parseRandomisationAnswer(answer string) (sample dnsRandomisationSample, err error) {
+	fields := make(map[string]string)
+	for _, field := range strings.Fields(strings.Trim(answer, "\"")) {
+		key, value, found := strings.Cut(field, "=")
+		if found {
+			fields[key] = value
+		}
+	}
+	if fields["source"] == "" {
+		return sample, fmt.Errorf("not a randomisation answer: %+q", answer)
+	}
+	sample.source = fields["source"]
+	sample.qname = fields["qname"]
+	if sample.port, err = strconv.Atoi(fields["port"]); err != nil {
+		return
+	}
+	sample.id, err = strconv.Atoi(fields["id"])
+	return
+}
+
+// score a series of 16 bit values and report the result
+func (check *DNSRandomisationCheck) scoreRandomness(resolver string, mnemonic string, what string, values []int) {
+	rating, distinct, stddev, bits := randomnessScore(values)
+	level := LogLevelType(LogLevelInfo)
+	if rating == "POOR" {
+		level = LogLevelError
+	}
+	check.log(
+		level,
+		"DNS_RANDOMISATION_"+mnemonic+"_"+rating,
+		fmt.Sprintf(
+			"Resolver %s %s randomness is %s: %d distinct of %d, standard deviation %.0f, about %.1f bits",
+			resolver, what, rating, distinct, len(values), stddev, bits,
+		),
+	)
+}
+
+// score a series of 16 bit values the same way porttest does: based on their standard deviation
+// @return GREAT, GOOD or POOR, the number of distinct values, the standard deviation and the estimated bits of randomness
+func randomnessScore(values []int) (rating string, distinct int, stddev float64, bits float64) {
+	seen := make(map[int]bool)
+	mean := 0.0
+	for _, value := range values {
+		seen[value] = true
+		mean += float64(value)
+	}
+	mean /= float64(len(values))
+	variance := 0.0
+	for _, value := range values {
+		variance += (float64(value) - mean) * (float64(value) - mean)
+	}
+	stddev = math.Sqrt(variance / float64(len(values)))
+
+	// the standard deviation of a uniform distribution over N values is N/sqrt(12)
+	if stddev > 0 {
+		bits = max(0, math.Log2(stddev*math.Sqrt(12)))
+	}
+
+	switch {
+	case stddev >= 3980:
+		rating = "GREAT"
+	case stddev >= 296:
+		rating = "GOOD"
+	default:
+		rating = "POOR"
+	}
+	return rating, len(seen), stddev, bits
+}
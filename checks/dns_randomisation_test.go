@@ -0,0 +1,132 @@
+package checks
+
+import (
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseRandomisationAnswer(t *testing.T) {
+	tests := []struct {
+		name   string
+		answer string
+		want   dnsRandomisationSample
+		err    bool
+	}{
+		{"IPv4", `"source=192.0.2.1 port=12345 id=54321"`, dnsRandomisationSample{"192.0.2.1", 12345, 54321, ""}, false},
+		{"IPv6 unquoted", "source=2001:db8::1 port=53 id=0", dnsRandomisationSample{"2001:db8::1", 53, 0, ""}, false},
+		{"fields in another order", `"id=7 source=192.0.2.1 port=1024"`, dnsRandomisationSample{"192.0.2.1", 1024, 7, ""}, false},
+		{"query name", `"source=192.0.2.1 port=1024 id=7 qname=aBc.PortTest.test."`, dnsRandomisationSample{"192.0.2.1", 1024, 7, "aBc.PortTest.test."}, false},
+		{"ECS echo", `"edns0-client-subnet 192.0.2.0/24"`, dnsRandomisationSample{}, true},
+		{"no port", `"source=192.0.2.1 id=1"`, dnsRandomisationSample{}, true},
+		{"bad id", `"source=192.0.2.1 port=1 id=x"`, dnsRandomisationSample{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sample, err := parseRandomisationAnswer(test.answer)
+			if (err != nil) != test.err {
+				t.Fatalf("error = %v, want error: %v", err, test.err)
+			}
+			if err == nil && sample != test.want {
+				t.Errorf("sample = %+v, want %+v", sample, test.want)
+			}
+		})
+	}
+}
+
+func TestRandomnessScore(t *testing.T) {
+	// two values 2*s apart have a standard deviation of s
+	tests := []struct {
+		name     string
+		values   []int
+		rating   string
+		distinct int
+		stddev   float64
+	}{
+		{"constant", []int{53, 53, 53}, "POOR", 1, 0},
+		{"sequential", []int{1000, 1001, 1002, 1003}, "POOR", 4, math.Sqrt(1.25)},
+		{"just below good", []int{0, 590}, "POOR", 2, 295},
+		{"good", []int{0, 592}, "GOOD", 2, 296},
+		{"just below great", []int{0, 7958}, "GOOD", 2, 3979},
+		{"great", []int{0, 7960}, "GREAT", 2, 3980},
+		{"full range", []int{0, 65535}, "GREAT", 2, 32767.5},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rating, distinct, stddev, bits := randomnessScore(test.values)
+			if rating != test.rating || distinct != test.distinct || math.Abs(stddev-test.stddev) > 1e-9 {
+				t.Errorf("score = %s, %d distinct, stddev %f; want %s, %d, %f", rating, distinct, stddev, test.rating, test.distinct, test.stddev)
+			}
+			if want := math.Max(0, math.Log2(test.stddev*math.Sqrt(12))); test.stddev > 0 && math.Abs(bits-want) > 1e-9 {
+				t.Errorf("bits = %f, want %f", bits, want)
+			}
+		})
+	}
+}
+
+func TestDNSRandomisationResponder(t *testing.T) {
+	port := freeTCPPort(t)
+	startServer(t, "-proto", "DNS", "-port", strconv.Itoa(port), "-zone", "porttest.test")
+	server := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+
+	// send queries from new sockets, so the source ports vary
+	query := func(name string) (sample dnsRandomisationSample, localPort int, id uint16, err error) {
+		conn, err := dns.DialTimeout("udp", server, time.Second)
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		msg := new(dns.Msg)
+		msg.SetQuestion(name, dns.TypeTXT)
+		if err = conn.WriteMsg(msg); err != nil {
+			return
+		}
+		conn.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+		response, err := conn.ReadMsg()
+		if err != nil {
+			return
+		}
+		for _, answer := range response.Answer {
+			if txt, ok := answer.(*dns.TXT); ok {
+				sample, err = parseRandomisationAnswer(txt.Txt[0])
+			}
+		}
+		return sample, conn.LocalAddr().(*net.UDPAddr).Port, msg.Id, err
+	}
+
+	// wait for the responder to start
+	var err error
+	for range 25 {
+		if _, _, _, err = query("start.porttest.test."); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("the DNS responder does not answer: %v", err)
+	}
+
+	var ids []int
+	for range 20 {
+		// the query name is echoed in the case it was received
+		name := strings.ToUpper(randomLabel(6)) + randomLabel(6) + ".PortTest.test."
+		sample, localPort, id, err := query(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sample.source != "127.0.0.1" || sample.port != localPort || sample.id != int(id) || sample.qname != name {
+			t.Errorf("responder saw %+v, want source 127.0.0.1, port %d, id %d, query name %s", sample, localPort, id, name)
+		}
+		ids = append(ids, sample.id)
+	}
+
+	// the query IDs of the DNS library are random
+	if rating, _, stddev, _ := randomnessScore(ids); rating != "GREAT" {
+		t.Errorf("query ID randomness is %s (standard deviation %.0f), want GREAT", rating, stddev)
+	}
+}
@@ -0,0 +1,268 @@
+package checks
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// DNSResolverCapabilitiesCheck makes a capability profile of local and open resolvers
+type DNSResolverCapabilitiesCheck struct {
+	netiscopeCheckBase
+	profiles [][]string
+}
+
+// the capabilities we look for, in the order they are reported
+var dnsResolverCapabilities = []string{"EDNS", "TCP", "DNSSEC", "COOKIE", "ECS", "0X20", "NSID", "VERSION"}
+
+// Start executes the resolver capabilities check
+func (check *DNSResolverCapabilitiesCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	name := util.GetDNSCapabilitiesName()
+	if name == "" {
+		check.log(LogLevelFatal, "DNS_CAPABILITY_NO_NAME", "There is no name to use for the capability checks")
+		return
+	}
+
+	var resolvers []string
+	rc, err := parseResolvConf(util.GetResolvConfPath())
+	if err != nil {
+		check.log(LogLevelError, "NO_RESOLV_CONF", "Could not load DNS resolver data from resolv.conf")
+	} else {
+		resolvers = append(resolvers, filterResolversByAF(rc.resolversV4, rc.resolversV6)...)
+	}
+	for _, provider := range util.GetOpenResolverList() {
+		_, v4list, v6list, err := parseOpenResolverProvider(provider)
+		if err != nil {
+			check.log(LogLevelError, "INVALID_OPEN_DNS_RESOLVER", err.Error())
+			continue
+		}
+		resolvers = append(resolvers, filterResolversByAF(v4list, v6list)...)
+	}
+
+	for _, resolver := range resolvers {
+		if check.stopping {
+			break
+		}
+		check.profileResolver(resolver, name)
+	}
+
+	check.reportTable()
+	check.netiscopeCheckBase.finish()
+}
+
+// determine the capabilities of one resolver
+func (check *DNSResolverCapabilitiesCheck) profileResolver(resolver string, name string) {
+	check.log(
+		LogLevelInfo,
+		"DNS_CAPABILITY_RESOLVER",
+		fmt.Sprintf("Profiling resolver %s", resolver),
+	)
+
+	profile := []string{resolver}
+	for _, capability := range dnsResolverCapabilities {
+		if check.stopping {
+			return
+		}
+		var value string
+		var supported bool
+		switch capability {
+		case "EDNS":
+			value, supported = check.probeEDNS(resolver, name)
+		case "TCP":
+			value, supported = check.probeTCP(resolver, name)
+		case "DNSSEC":
+			value, supported = check.probeDNSSEC(resolver)
+		case "COOKIE":
+			value, supported = check.probeCookie(resolver, name)
+		case "ECS":
+			value, supported = check.probeECS(resolver, name)
+		case "0X20":
+			value, supported = check.probe0x20(resolver)
+		case "NSID":
+			value, supported = check.probeNSID(resolver, name)
+		case "VERSION":
+			value, supported = check.probeVersion(resolver)
+		}
+
+		// missing EDNS or TCP support breaks things, the rest is informational
+		level := LogLevelType(LogLevelInfo)
+		if !supported && (capability == "EDNS" || capability == "TCP") {
+			level = LogLevelWarning
+		}
+		check.log(
+			level,
+			"DNS_CAPABILITY_"+capability,
+			fmt.Sprintf("Resolver %s: %s %s", resolver, capability, value),
+		)
+		profile = append(profile, value)
+	}
+	check.profiles = append(check.profiles, profile)
+}
+
+// does the resolver support EDNS, and what buffer size does it advertise?
+func (check *DNSResolverCapabilitiesCheck) probeEDNS(resolver string, name string) (string, bool) {
+	answers, err := DNSQueryWithOptions(&check.netiscopeCheckBase, name, "A", resolver, DNSQueryOptions{RD: true, EDNS: true, UDPSize: 1232})
+	switch {
+	case answers == nil:
+		return fmt.Sprintf("unknown (%v)", err), false
+	case len(answers["EDNS"]) == 0:
+		return "no", false
+	default:
+		return "yes (" + answers["EDNS"][0] + ")", true
+	}
+}
+
+// does the resolver answer over TCP?
+func (check *DNSResolverCapabilitiesCheck) probeTCP(resolver string, name string) (string, bool) {
+	_, err := DNSQueryWithOptions(&check.netiscopeCheckBase, name, "A", resolver, DNSQueryOptions{RD: true, TCP: true})
+	if err != nil {
+		return fmt.Sprintf("no (%v)", err), false
+	}
+	return "yes", true
+}
+
+// does the resolver validate DNSSEC: AD for a properly signed name, SERVFAIL for a broken one?
+func (check *DNSResolverCapabilitiesCheck) probeDNSSEC(resolver string) (string, bool) {
+	signed, signedErr := DNSQueryWithOptions(&check.netiscopeCheckBase, util.GetDNSCapabilitiesSignedName(), "A", resolver, DNSQueryOptions{RD: true, DO: true})
+	broken, brokenErr := DNSQueryWithOptions(&check.netiscopeCheckBase, util.GetDNSCapabilitiesBrokenName(), "A", resolver, DNSQueryOptions{RD: true, DO: true})
+
+	authenticated := slices.Contains(signed["FLAGS"], "ad")
+	rejected := firstOrEmpty(broken["RCODE"]) == "SERVFAIL"
+	switch {
+	case signedErr != nil:
+		// SERVFAIL for the broken name means nothing if the signed one fails as well
+		return fmt.Sprintf("unknown (%v)", signedErr), false
+	case broken == nil:
+		return fmt.Sprintf("unknown (%v)", brokenErr), false
+	case authenticated && rejected:
+		return "validating", true
+	case rejected:
+		return "validating (no AD flag)", true
+	case authenticated:
+		return "partial (broken signatures are accepted)", false
+	default:
+		return "no", false
+	}
+}
+
+// does the resolver support DNS cookies (RFC 7873)?
+func (check *DNSResolverCapabilitiesCheck) probeCookie(resolver string, name string) (string, bool) {
+	clientCookie := make([]byte, 8)
+	rand.Read(clientCookie)
+	answers, err := DNSQueryWithOptions(
+		&check.netiscopeCheckBase, name, "A", resolver,
+		DNSQueryOptions{RD: true, Cookie: hex.EncodeToString(clientCookie)},
+	)
+	cookie := firstOrEmpty(answers["COOKIE"])
+	switch {
+	case answers == nil:
+		return fmt.Sprintf("unknown (%v)", err), false
+	case len(cookie) > 16:
+		return "yes", true
+	case cookie != "":
+		return "client cookie only", false
+	default:
+		return "no", false
+	}
+}
+
+// does the resolver deal with EDNS client subnet (RFC 7871)?
+func (check *DNSResolverCapabilitiesCheck) probeECS(resolver string, name string) (string, bool) {
+	answers, err := DNSQueryWithOptions(
+		&check.netiscopeCheckBase, name, "A", resolver,
+		DNSQueryOptions{RD: true, ClientSubnet: util.GetDNSCapabilitiesClientSubnet()},
+	)
+	if answers == nil {
+		return fmt.Sprintf("unknown (%v)", err), false
+	}
+	if ecs := firstOrEmpty(answers["ECS"]); ecs != "" {
+		return "yes (" + ecs + ")", true
+	}
+	return "no", false
+}
+
+// does the resolver randomise the case of the names it asks upstream (0x20 encoding)?
+// resolvers copy the question into the answer, so this needs the randomisation responder to tell what it received
+func (check *DNSResolverCapabilitiesCheck) probe0x20(resolver string) (string, bool) {
+	zone := util.GetDNSRandomisationZone()
+	if zone == "" {
+		return "unknown (no randomisation responder zone)", false
+	}
+	name := strings.ToLower(randomLabel(12) + "." + dns.Fqdn(zone))
+	answers, err := DNSQueryWithOptions(&check.netiscopeCheckBase, name, "TXT", resolver, DNSQueryOptions{RD: true})
+	if answers == nil {
+		return fmt.Sprintf("unknown (%v)", err), false
+	}
+	for _, answer := range answers["TXT"] {
+		if sample, err := parseRandomisationAnswer(answer); err == nil && sample.qname != "" {
+			return qnameCaseRandomised(name, sample.qname)
+		}
+	}
+	return "unknown (no answer from the responder)", false
+}
+
+// compare the name asked in lowercase with the one the responder received
+func qnameCaseRandomised(asked string, received string) (string, bool) {
+	switch {
+	case !strings.EqualFold(asked, received):
+		return fmt.Sprintf("unknown (the responder received %s)", received), false
+	case asked != received:
+		return "yes", true
+	default:
+		return "no", false
+	}
+}
+
+// does the resolver support NSID (RFC 5001)?
+func (check *DNSResolverCapabilitiesCheck) probeNSID(resolver string, name string) (string, bool) {
+	answers, err := DNSQueryWithOptions(&check.netiscopeCheckBase, name, "A", resolver, DNSQueryOptions{RD: true, NSID: true})
+	if answers == nil {
+		return fmt.Sprintf("unknown (%v)", err), false
+	}
+	if nsid := firstOrEmpty(answers["NSID"]); nsid != "" {
+		return fmt.Sprintf("%+q", nsid), true
+	}
+	return "no", false
+}
+
+// does the resolver tell its version?
+func (check *DNSResolverCapabilitiesCheck) probeVersion(resolver string) (string, bool) {
+	answers, err := DNSQuery(&check.netiscopeCheckBase, "version.bind", "CH TXT", resolver, false, false, false, false)
+	if answers == nil {
+		return fmt.Sprintf("unknown (%v)", err), false
+	}
+	if version := firstOrEmpty(answers["TXT"]); version != "" {
+		return fmt.Sprintf("%+q", version), true
+	}
+	return "no", false
+}
+
+// report the capabilities of all resolvers in a compact table
+func (check *DNSResolverCapabilitiesCheck) reportTable() {
+	if len(check.profiles) == 0 {
+		return
+	}
+
+	header := append([]string{"RESOLVER"}, dnsResolverCapabilities...)
+	for _, line := range formatTable(header, check.profiles) {
+		check.log(LogLevelInfo, "DNS_CAPABILITY_TABLE", line)
+	}
+}
+
+// select resolvers according to the enabled address families
+func filterResolversByAF(v4list []string, v6list []string) (resolvers []string) {
+	if !util.SkipIPv4() {
+		resolvers = append(resolvers, v4list...)
+	}
+	if !util.SkipIPv6() {
+		resolvers = append(resolvers, v6list...)
+	}
+	return
+}
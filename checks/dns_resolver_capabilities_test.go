@@ -0,0 +1,25 @@
+package checks
+
+import "testing"
+
+func TestQnameCaseRandomised(t *testing.T) {
+	tests := []struct {
+		name      string
+		asked     string
+		received  string
+		value     string
+		supported bool
+	}{
+		{"case kept", "abc123.porttest.test.", "abc123.porttest.test.", "no", false},
+		{"case randomised", "abc123.porttest.test.", "aBC123.PorTtest.tEsT.", "yes", true},
+		{"another name", "abc123.porttest.test.", "porttest.test.", "unknown (the responder received porttest.test.)", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value, supported := qnameCaseRandomised(test.asked, test.received)
+			if value != test.value || supported != test.supported {
+				t.Errorf("0x20 = %q (%v), want %q (%v)", value, supported, test.value, test.supported)
+			}
+		})
+	}
+}
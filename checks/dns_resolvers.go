@@ -91,7 +91,7 @@ func queryNameFromResolver(
 		}
 	}
 
-	if len(answersA)+len(answersAAAA) == 0 {
+	if len(answersA["A"])+len(answersAAAA["AAAA"]) == 0 {
 		check.log(
 			LogLevelError,
 			"RESOLVER_ZERO_ANSWER",
@@ -113,6 +113,52 @@ func queryNameFromResolver(
 		CheckIPForNetwork(check, fmt.Sprint(ip), name, true, fmt.Sprintf(" (via resolver: %s)", resolver))
 	}
 
+	// ask for any other configured query types for this name
+	result := ResultSuccess
+	for _, qtype := range util.GetDNSQueryTypesForName(name) {
+		if queryTypeFromResolver(check, name, qtype, resolver) == ResultFailure {
+			result = ResultFailure
+		}
+	}
+
+	return result
+}
+
+// ask one resolver for one particular query type of a name
+// return ResultCode to indicate if it was successful
+func queryTypeFromResolver(
+	check *netiscopeCheckBase,
+	name string,
+	qtype string,
+	resolver string,
+) ResultCode {
+	mnemo := strings.ReplaceAll(qtype, " ", "_")
+	rtype := strings.Fields(qtype)[len(strings.Fields(qtype))-1]
+
+	answers, err := DNSQuery(check, name, qtype, resolver, false, true, true, false)
+	if err != nil {
+		check.log(
+			LogLevelError,
+			"RESOLVER_ERROR_"+mnemo,
+			fmt.Sprintf("Resolver %s failed to answer %s query for %s: %v", resolver, qtype, name, err),
+		)
+		return ResultFailure
+	}
+
+	if len(answers[rtype]) == 0 {
+		check.log(
+			LogLevelWarning,
+			"RESOLVER_NO_ANSWER_"+mnemo,
+			fmt.Sprintf("Resolver %s gave no %s answers to query %s", resolver, qtype, name),
+		)
+		return ResultSuccess
+	}
+
+	check.log(
+		LogLevelInfo,
+		"RESOLVER_ANSWERS_"+mnemo,
+		fmt.Sprintf("Resolver %s's %s answer(s) to query %s is: %v", resolver, qtype, name, answers[rtype]),
+	)
 	return ResultSuccess
 }
 
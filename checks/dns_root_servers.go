@@ -2,7 +2,6 @@ package checks
 
 import (
 	"fmt"
-	"math/rand"
 	"strings"
 	"time"
 
@@ -47,6 +46,12 @@ func (check *DNSRootServersCheck) start() {
 }
 
 func (check *DNSRootServersCheck) configure() {
+	rootDNSServers = loadRootDNSServers(&check.netiscopeCheckBase)
+}
+
+// load the list of root DNS servers from the config file
+// this is shared by all checks that need to talk to the root servers
+func loadRootDNSServers(check *netiscopeCheckBase) (servers []rootDNSServerCheckType) {
 	for _, item := range util.LoadRootDNSServerData() {
 		if len(item) < 3 {
 			check.log(
@@ -57,6 +62,7 @@ func (check *DNSRootServersCheck) configure() {
 					item,
 				),
 			)
+			continue
 		}
 		root := rootDNSServerCheckType{
 			Letter:    item[0],
@@ -65,8 +71,9 @@ func (check *DNSRootServersCheck) configure() {
 			Pingable4: len(item) < 5 || item[3] == "true",
 			Pingable6: len(item) < 5 || item[4] == "true",
 		}
-		rootDNSServers = append(rootDNSServers, root)
+		servers = append(servers, root)
 	}
+	return
 }
 
 // check a particular DNS root server on IPv4 or IPv6
@@ -222,13 +229,8 @@ func queryRootDNSServer(
 
 	// generate a few random TLD names
 	var randomTLDs []string
-	chars := []rune("abcdefghijklmnopqrstuvwxyz0123456789")
 	for i := 0; i < util.GetRandomTLDAmount(); i++ {
-		var builder strings.Builder
-		for i := 0; i < 12; i++ {
-			builder.WriteRune(chars[rand.Intn(len(chars))])
-		}
-		randomTLDs = append(randomTLDs, builder.String())
+		randomTLDs = append(randomTLDs, randomLabel(12))
 	}
 
 	// look up random TLDs
@@ -0,0 +1,578 @@
+package checks
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"maps"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// DNSRootZoneCheck transfers the root zone and verifies its integrity
+type DNSRootZoneCheck struct {
+	netiscopeCheckBase
+	trustAnchors []*dns.DS
+}
+
+// ZONEMD scheme and hash algorithms (RFC 8976)
+const (
+	zonemdSchemeSimple = 1
+	zonemdHashSHA384   = 1
+	zonemdHashSHA512   = 2
+)
+
+func (check *DNSRootZoneCheck) configure() {
+	check.trustAnchors = make([]*dns.DS, 0)
+	for _, anchor := range util.GetRootZoneTrustAnchors() {
+		rr, err := dns.NewRR(anchor)
+		if err != nil {
+			check.log(
+				LogLevelError,
+				"ROOT_ZONE_CONFIG_ERROR",
+				fmt.Sprintf("Invalid trust anchor %s: %v", anchor, err),
+			)
+			continue
+		}
+		ds, ok := rr.(*dns.DS)
+		if !ok {
+			check.log(
+				LogLevelError,
+				"ROOT_ZONE_CONFIG_ERROR",
+				fmt.Sprintf("Trust anchor is not a DS record: %s", anchor),
+			)
+			continue
+		}
+		check.trustAnchors = append(check.trustAnchors, ds)
+	}
+}
+
+// Start executes the root zone transfer check
+func (check *DNSRootZoneCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	servers := util.GetRootZoneTransferServers()
+	if len(servers) == 0 {
+		check.log(LogLevelWarning, "ROOT_ZONE_NO_SERVERS", "No servers defined to transfer the root zone from")
+	}
+
+	for _, server := range servers {
+		if check.stopping {
+			break
+		}
+		check.checkRootZoneFromServer(server)
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// transfer the root zone from one server and evaluate what we got
+func (check *DNSRootZoneCheck) checkRootZoneFromServer(server string) {
+	check.log(
+		LogLevelInfo,
+		"ROOT_ZONE_TRANSFER",
+		fmt.Sprintf("Transferring the root zone from %s", server),
+	)
+
+	zone, remote, err := transferZone(".", server, time.Duration(util.GetRootZoneTransferTimeout())*time.Millisecond)
+	if err != nil {
+		check.log(
+			LogLevelError,
+			"ROOT_ZONE_TRANSFER_ERROR",
+			fmt.Sprintf("Failed to transfer the root zone from %s: %v", server, err),
+		)
+		return
+	}
+
+	soa := findApexSOA(zone)
+	if soa == nil {
+		check.log(
+			LogLevelError,
+			"ROOT_ZONE_NO_SOA",
+			fmt.Sprintf("The root zone transferred from %s (%s) has no SOA record", server, remote),
+		)
+		return
+	}
+	check.log(
+		LogLevelInfo,
+		"ROOT_ZONE_TRANSFER_OK",
+		fmt.Sprintf("Transferred %d records from %s (%s), SOA serial is %d", len(zone), server, remote, soa.Serial),
+	)
+
+	check.verifyZONEMD(server, zone, soa)
+	check.verifyDNSSEC(server, zone)
+	if util.GetRootZoneCompareSerials() {
+		check.compareSerialWithRootServers(server, soa.Serial)
+	}
+}
+
+// verify the ZONEMD record(s) of the zone against the zone's contents
+func (check *DNSRootZoneCheck) verifyZONEMD(server string, zone []dns.RR, soa *dns.SOA) {
+	var zonemds []*dns.ZONEMD
+	for _, rr := range zone {
+		if z, ok := rr.(*dns.ZONEMD); ok && rr.Header().Name == "." {
+			zonemds = append(zonemds, z)
+		}
+	}
+	if len(zonemds) == 0 {
+		check.log(
+			LogLevelWarning,
+			"ROOT_ZONE_NO_ZONEMD",
+			fmt.Sprintf("The root zone from %s has no ZONEMD record", server),
+		)
+		return
+	}
+
+	verified := false
+	for _, zonemd := range zonemds {
+		if zonemd.Serial != soa.Serial {
+			check.log(
+				LogLevelError,
+				"ROOT_ZONE_ZONEMD_SERIAL_MISMATCH",
+				fmt.Sprintf("ZONEMD serial %d does not match SOA serial %d (from %s)", zonemd.Serial, soa.Serial, server),
+			)
+			continue
+		}
+		digest, err := zoneDigest(zone, zonemd.Scheme, zonemd.Hash)
+		if err != nil {
+			check.log(
+				LogLevelDetail,
+				"ROOT_ZONE_ZONEMD_UNSUPPORTED",
+				fmt.Sprintf("Skipping ZONEMD from %s: %v", server, err),
+			)
+			continue
+		}
+		if strings.EqualFold(digest, zonemd.Digest) {
+			verified = true
+			check.log(
+				LogLevelInfo,
+				"ROOT_ZONE_ZONEMD_OK",
+				fmt.Sprintf("ZONEMD digest (scheme %d, hash %d) of the root zone from %s is valid", zonemd.Scheme, zonemd.Hash, server),
+			)
+		} else {
+			check.log(
+				LogLevelError,
+				"ROOT_ZONE_ZONEMD_MISMATCH",
+				fmt.Sprintf("ZONEMD digest (scheme %d, hash %d) of the root zone from %s does not match its contents", zonemd.Scheme, zonemd.Hash, server),
+			)
+		}
+	}
+
+	if !verified {
+		check.log(
+			LogLevelError,
+			"ROOT_ZONE_ZONEMD_FAIL",
+			fmt.Sprintf("Could not verify the ZONEMD digest of the root zone from %s", server),
+		)
+	}
+}
+
+// verify the DNSSEC signatures in the zone, starting from the configured trust anchors
+func (check *DNSRootZoneCheck) verifyDNSSEC(server string, zone []dns.RR) {
+	rrsets, sigs := groupRRSets(zone)
+
+	// the apex DNSKEY set has to be signed by a key that matches one of the trust anchors
+	var keys []*dns.DNSKEY
+	for _, rr := range rrsets["./DNSKEY"] {
+		keys = append(keys, rr.(*dns.DNSKEY))
+	}
+	anchored := check.anchoredKeys(keys)
+	if len(anchored) == 0 {
+		check.log(
+			LogLevelError,
+			"ROOT_ZONE_DNSSEC_NO_ANCHOR",
+			fmt.Sprintf("None of the DNSKEYs of the root zone from %s match the configured trust anchors", server),
+		)
+		return
+	}
+	now := time.Now()
+	if !verifyRRSetSignatures(rrsets["./DNSKEY"], sigs["./DNSKEY"], anchored, now) {
+		check.log(
+			LogLevelError,
+			"ROOT_ZONE_DNSSEC_DNSKEY_UNVERIFIED",
+			fmt.Sprintf("The DNSKEY set of the root zone from %s is not signed by a key matching the trust anchors", server),
+		)
+		return
+	}
+
+	// only the now validated zone signing keys are used for the rest of the zone
+	var zoneKeys []*dns.DNSKEY
+	for _, key := range keys {
+		if key.Flags&dns.ZONE != 0 && key.Flags&dns.REVOKE == 0 {
+			zoneKeys = append(zoneKeys, key)
+		}
+	}
+
+	result := verifyZoneSignatures(".", rrsets, sigs, zoneKeys, now)
+	for _, key := range result.unsigned {
+		check.log(
+			LogLevelDetail,
+			"ROOT_ZONE_DNSSEC_UNSIGNED_RRSET",
+			fmt.Sprintf("No signature for %s in the root zone from %s", key, server),
+		)
+	}
+	for _, key := range result.invalid {
+		check.log(
+			LogLevelDetail,
+			"ROOT_ZONE_DNSSEC_BAD_RRSET",
+			fmt.Sprintf("No valid signature for %s in the root zone from %s", key, server),
+		)
+	}
+
+	failures := len(result.unsigned) + len(result.invalid)
+	total := result.valid + failures + 1 // and the DNSKEY set
+	if failures == 0 {
+		check.log(
+			LogLevelInfo,
+			"ROOT_ZONE_DNSSEC_OK",
+			fmt.Sprintf("All %d authoritative RRsets of the root zone from %s have valid signatures", total, server),
+		)
+	} else {
+		check.log(
+			LogLevelError,
+			"ROOT_ZONE_DNSSEC_FAIL",
+			fmt.Sprintf(
+				"%d of %d authoritative RRsets of the root zone from %s failed validation (%d unsigned, %d with bad signatures)",
+				failures, total, server, len(result.unsigned), len(result.invalid),
+			),
+		)
+	}
+}
+
+// group the records of a zone into RRsets keyed by name/type, and their signatures by name/type covered
+func groupRRSets(zone []dns.RR) (rrsets map[string][]dns.RR, sigs map[string][]*dns.RRSIG) {
+	rrsets = make(map[string][]dns.RR)
+	sigs = make(map[string][]*dns.RRSIG)
+	for _, rr := range zone {
+		name := dns.CanonicalName(rr.Header().Name)
+		if sig, ok := rr.(*dns.RRSIG); ok {
+			key := name + "/" + dns.TypeToString[sig.TypeCovered]
+			sigs[key] = append(sigs[key], sig)
+			continue
+		}
+		key := name + "/" + dns.TypeToString[rr.Header().Rrtype]
+		rrsets[key] = append(rrsets[key], rr)
+	}
+	return
+}
+
+// the outcome of verifying the signatures of a zone
+type zoneSignatureResult struct {
+	valid    int
+	invalid  []string // RRsets without a valid signature, as name/type
+	unsigned []string // RRsets that should be signed but aren't, as name/type
+}
+
+// verify the signatures of the RRsets of a zone (other than the apex DNSKEY set) with the zone signing keys
+// NS records at delegations and glue are not signed, everything else is, so a missing signature is a failure
+func verifyZoneSignatures(
+	apex string,
+	rrsets map[string][]dns.RR,
+	sigs map[string][]*dns.RRSIG,
+	keys []*dns.DNSKEY,
+	now time.Time,
+) (result zoneSignatureResult) {
+	cuts := make(map[string]bool)
+	for key := range rrsets {
+		if name, rrtype := splitRRSetKey(key); rrtype == "NS" && name != apex {
+			cuts[name] = true
+		}
+	}
+
+	for _, key := range slices.Sorted(maps.Keys(rrsets)) {
+		name, rrtype := splitRRSetKey(key)
+		if (name == apex && rrtype == "DNSKEY") || !isAuthoritativeRRSet(name, rrtype, cuts) {
+			continue
+		}
+		switch {
+		case len(sigs[key]) == 0:
+			result.unsigned = append(result.unsigned, key)
+		case verifyRRSetSignatures(rrsets[key], sigs[key], keys, now):
+			result.valid++
+		default:
+			result.invalid = append(result.invalid, key)
+		}
+	}
+	return
+}
+
+// split a name/type key of an RRset
+func splitRRSetKey(key string) (name string, rrtype string) {
+	i := strings.LastIndex(key, "/")
+	return key[:i], key[i+1:]
+}
+
+// tell if an RRset is authoritative data of the zone, which has to be signed (RFC 4035, section 2.2)
+// at a delegation only DS and NSEC are, and nothing below it (glue)
+func isAuthoritativeRRSet(name string, rrtype string, cuts map[string]bool) bool {
+	if cuts[name] {
+		return rrtype == "DS" || rrtype == "NSEC"
+	}
+	// the names above this one, up to the apex
+	for offset, end := dns.NextLabel(name, 0); !end; offset, end = dns.NextLabel(name, offset) {
+		if cuts[name[offset:]] {
+			return false
+		}
+	}
+	return true
+}
+
+// find the keys in the key set that match one of the trust anchors (key tag, algorithm and DS digest)
+func (check *DNSRootZoneCheck) anchoredKeys(keys []*dns.DNSKEY) (anchored []*dns.DNSKEY) {
+	for _, key := range keys {
+		for _, anchor := range check.trustAnchors {
+			if key.KeyTag() != anchor.KeyTag || key.Algorithm != anchor.Algorithm || key.Flags&dns.REVOKE != 0 {
+				continue
+			}
+			ds := key.ToDS(anchor.DigestType)
+			if ds != nil && strings.EqualFold(ds.Digest, anchor.Digest) {
+				anchored = append(anchored, key)
+				break
+			}
+		}
+	}
+	return
+}
+
+// compare the serial of the transferred zone with what the root servers say
+func (check *DNSRootZoneCheck) compareSerialWithRootServers(server string, serial uint32) {
+	for _, root := range loadRootDNSServers(&check.netiscopeCheckBase) {
+		if check.stopping {
+			return
+		}
+		address := root.IPv4
+		if util.SkipIPv4() {
+			address = root.IPv6
+		}
+
+		answers, err := DNSQuery(&check.netiscopeCheckBase, ".", "SOA", address, false, false, false, false)
+		if err != nil || len(answers["SOA"]) == 0 {
+			check.log(
+				LogLevelWarning,
+				"ROOT_ZONE_SERIAL_QUERY_ERROR",
+				fmt.Sprintf("Could not query the SOA serial from %s-root (%s): %v", root.Letter, address, err),
+			)
+			continue
+		}
+
+		rootSerial := strings.Split(answers["SOA"][0], " ")[1]
+		if rootSerial == fmt.Sprint(serial) {
+			check.log(
+				LogLevelDetail,
+				"ROOT_ZONE_SERIAL_MATCH",
+				fmt.Sprintf("%s-root serves the same serial %s as %s", root.Letter, rootSerial, server),
+			)
+		} else {
+			check.log(
+				LogLevelWarning,
+				"ROOT_ZONE_SERIAL_MISMATCH",
+				fmt.Sprintf("%s-root serves serial %s while %s serves %d", root.Letter, rootSerial, server, serial),
+			)
+		}
+	}
+}
+
+// transfer a zone via AXFR over TCP
+// @return the records of the zone (with the closing SOA removed) and the remote address used
+func transferZone(
+	zone string,
+	server string,
+	timeout time.Duration,
+) (records []dns.RR, remote string, err error) {
+	af := ""
+	switch {
+	case util.SkipIPv4() && !util.SkipIPv6():
+		af = "6"
+	case util.SkipIPv6() && !util.SkipIPv4():
+		af = "4"
+	}
+
+	conn, err := NewTransport(af, timeout).Dial("tcp", dnsServerAddress(server))
+	if err != nil {
+		return
+	}
+	remote = conn.RemoteAddr().String()
+
+	transfer := &dns.Transfer{
+		Conn:        &dns.Conn{Conn: conn},
+		ReadTimeout: timeout,
+	}
+	query := new(dns.Msg)
+	query.SetAxfr(dns.Fqdn(zone))
+
+	envelopes, err := transfer.In(query, dnsServerAddress(server))
+	if err != nil {
+		conn.Close()
+		return
+	}
+	for envelope := range envelopes {
+		if envelope.Error != nil {
+			err = envelope.Error
+			return
+		}
+		records = append(records, envelope.RR...)
+	}
+
+	// AXFR repeats the SOA at the end
+	if len(records) > 1 && records[len(records)-1].Header().Rrtype == dns.TypeSOA {
+		records = records[:len(records)-1]
+	}
+	return
+}
+
+// find the SOA record at the apex of a transferred zone
+func findApexSOA(zone []dns.RR) *dns.SOA {
+	if len(zone) == 0 {
+		return nil
+	}
+	soa, _ := zone[0].(*dns.SOA)
+	return soa
+}
+
+// verify that at least one signature over an RRset is valid with one of the keys
+func verifyRRSetSignatures(rrset []dns.RR, sigs []*dns.RRSIG, keys []*dns.DNSKEY, now time.Time) bool {
+	for _, sig := range sigs {
+		if !sig.ValidityPeriod(now) {
+			continue
+		}
+		for _, key := range keys {
+			if key.KeyTag() != sig.KeyTag || key.Algorithm != sig.Algorithm {
+				continue
+			}
+			if sig.Verify(key, rrset) == nil {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// calculate the digest of a zone as defined by RFC 8976
+// the apex ZONEMD records and their signatures are excluded
+// @return the hex encoded digest or an error for unsupported schemes or algorithms
+func zoneDigest(zone []dns.RR, scheme uint8, hashAlgorithm uint8) (string, error) {
+	if scheme != zonemdSchemeSimple {
+		return "", fmt.Errorf("unsupported ZONEMD scheme %d", scheme)
+	}
+	var h hash.Hash
+	switch hashAlgorithm {
+	case zonemdHashSHA384:
+		h = sha512.New384()
+	case zonemdHashSHA512:
+		h = sha512.New()
+	default:
+		return "", fmt.Errorf("unsupported ZONEMD hash algorithm %d", hashAlgorithm)
+	}
+
+	apex := dns.CanonicalName(zone[0].Header().Name)
+
+	type canonicalRR struct {
+		owner  string
+		rrtype uint16
+		wire   []byte
+		rdata  []byte
+	}
+	var records []canonicalRR
+	for _, rr := range zone {
+		header := rr.Header()
+		owner := dns.CanonicalName(header.Name)
+		if owner == apex && header.Rrtype == dns.TypeZONEMD {
+			continue
+		}
+		if sig, ok := rr.(*dns.RRSIG); ok && owner == apex && sig.TypeCovered == dns.TypeZONEMD {
+			continue
+		}
+
+		wire, rdata, err := canonicalWireFormat(rr)
+		if err != nil {
+			return "", err
+		}
+		records = append(records, canonicalRR{owner: owner, rrtype: header.Rrtype, wire: wire, rdata: rdata})
+	}
+
+	slices.SortFunc(records, func(a, b canonicalRR) int {
+		if c := compareCanonicalNames(a.owner, b.owner); c != 0 {
+			return c
+		}
+		if a.rrtype != b.rrtype {
+			return int(a.rrtype) - int(b.rrtype)
+		}
+		return bytes.Compare(a.rdata, b.rdata)
+	})
+
+	for i, record := range records {
+		// duplicate records are only included once
+		if i > 0 && bytes.Equal(record.wire, records[i-1].wire) {
+			continue
+		}
+		h.Write(record.wire)
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// produce the canonical wire format of a record (RFC 4034, section 6.2)
+// @return the whole record and its RDATA part
+func canonicalWireFormat(rr dns.RR) (wire []byte, rdata []byte, err error) {
+	rr = dns.Copy(rr)
+	rr.Header().Name = dns.CanonicalName(rr.Header().Name)
+	switch t := rr.(type) {
+	case *dns.NS:
+		t.Ns = dns.CanonicalName(t.Ns)
+	case *dns.CNAME:
+		t.Target = dns.CanonicalName(t.Target)
+	case *dns.SOA:
+		t.Ns = dns.CanonicalName(t.Ns)
+		t.Mbox = dns.CanonicalName(t.Mbox)
+	case *dns.PTR:
+		t.Ptr = dns.CanonicalName(t.Ptr)
+	case *dns.MX:
+		t.Mx = dns.CanonicalName(t.Mx)
+	case *dns.SRV:
+		t.Target = dns.CanonicalName(t.Target)
+	case *dns.DNAME:
+		t.Target = dns.CanonicalName(t.Target)
+	case *dns.RRSIG:
+		t.SignerName = dns.CanonicalName(t.SignerName)
+	}
+	// the next domain name of NSEC is not lowercased (RFC 6840, section 5.1)
+
+	buf := make([]byte, dns.Len(rr)+1)
+	off, err := dns.PackRR(rr, buf, 0, nil, false)
+	if err != nil {
+		return
+	}
+	wire = buf[:off]
+
+	// owner name, then type, class, TTL and RDLENGTH (10 bytes)
+	ownerLen, err := dns.PackDomainName(rr.Header().Name, make([]byte, 256), 0, nil, false)
+	if err != nil {
+		return
+	}
+	rdata = wire[ownerLen+10:]
+	return
+}
+
+// compare two domain names according to the canonical DNS name order (RFC 4034, section 6.1)
+func compareCanonicalNames(a string, b string) int {
+	labelsA := dns.SplitDomainName(strings.ToLower(a))
+	labelsB := dns.SplitDomainName(strings.ToLower(b))
+	for i, j := len(labelsA)-1, len(labelsB)-1; i >= 0 || j >= 0; i, j = i-1, j-1 {
+		switch {
+		case i < 0:
+			return -1
+		case j < 0:
+			return 1
+		}
+		if c := strings.Compare(labelsA[i], labelsB[j]); c != 0 {
+			return c
+		}
+	}
+	return 0
+}
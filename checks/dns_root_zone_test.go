@@ -0,0 +1,203 @@
+package checks
+
+import (
+	"crypto"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// the simple example zone of RFC 8976, appendix A.1
+const zonemdExampleZone = `
+example.      86400  IN  SOA     ns1 admin 2018031900 1800 900 604800 86400
+              86400  IN  NS      ns1
+              86400  IN  NS      ns2
+              86400  IN  ZONEMD  2018031900 1 1 c68090d90a7aed716bc459f9340e3d7c1370d4d24b7e2fc3a1ddc0b9a87153b9a9713b3c9ae5cc27777f98b8e730044c
+ns1           3600   IN  A       203.0.113.63
+ns2           3600   IN  AAAA    2001:db8::63
+`
+
+func parseTestZone(t *testing.T, zone string, origin string) (records []dns.RR) {
+	t.Helper()
+	parser := dns.NewZoneParser(strings.NewReader(zone), origin, "")
+	for rr, ok := parser.Next(); ok; rr, ok = parser.Next() {
+		records = append(records, rr)
+	}
+	if err := parser.Err(); err != nil {
+		t.Fatalf("invalid test zone: %v", err)
+	}
+	return
+}
+
+func TestZoneDigest(t *testing.T) {
+	zone := parseTestZone(t, zonemdExampleZone, "example.")
+	expected := zone[3].(*dns.ZONEMD).Digest
+
+	// the order of the records and the case of the names doesn't matter, duplicates are only counted once
+	shuffled := []dns.RR{zone[0], zone[5], zone[4], zone[3], zone[2], zone[1], dns.Copy(zone[4])}
+	shuffled[1].Header().Name = "NS2.Example."
+
+	// changing anything changes the digest
+	changed := slices.Clone(zone)
+	changed[4] = dns.Copy(zone[4])
+	changed[4].(*dns.A).A[3] = 64
+
+	tests := []struct {
+		name   string
+		zone   []dns.RR
+		scheme uint8
+		hash   uint8
+		match  bool
+		err    bool
+	}{
+		{"RFC 8976 example", zone, zonemdSchemeSimple, zonemdHashSHA384, true, false},
+		{"reordered, mixed case and duplicate records", shuffled, zonemdSchemeSimple, zonemdHashSHA384, true, false},
+		{"changed record", changed, zonemdSchemeSimple, zonemdHashSHA384, false, false},
+		{"SHA-512", zone, zonemdSchemeSimple, zonemdHashSHA512, false, false},
+		{"unsupported scheme", zone, 2, zonemdHashSHA384, false, true},
+		{"unsupported hash", zone, zonemdSchemeSimple, 3, false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			digest, err := zoneDigest(test.zone, test.scheme, test.hash)
+			if (err != nil) != test.err {
+				t.Fatalf("error = %v, want error: %v", err, test.err)
+			}
+			if match := strings.EqualFold(digest, expected); err == nil && match != test.match {
+				t.Errorf("digest %s, match = %v, want %v", digest, match, test.match)
+			}
+		})
+	}
+}
+
+// a small signed zone: apex records, a delegation with DS, NSEC and glue
+const dnssecTestZone = `
+.                 86400   IN  SOA   a.root-servers.test. nstld.verisign-grs.test. 2024010100 1800 900 604800 86400
+.                 518400  IN  NS    a.root-servers.test.
+.                 86400   IN  NSEC  test. NS SOA RRSIG NSEC DNSKEY
+test.             172800  IN  NS    a.root-servers.test.
+test.             86400   IN  DS    12345 13 2 0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF0123456789ABCDEF
+test.             86400   IN  NSEC  . NS DS RRSIG NSEC
+a.root-servers.test. 518400 IN A    192.0.2.53
+`
+
+// sign all RRsets of a zone that have to be signed with a new zone signing key
+func signTestZone(t *testing.T, zone []dns.RR, inception time.Time) ([]dns.RR, *dns.DNSKEY) {
+	t.Helper()
+	key := &dns.DNSKEY{
+		Hdr:       dns.RR_Header{Name: ".", Rrtype: dns.TypeDNSKEY, Class: dns.ClassINET, Ttl: 172800},
+		Flags:     dns.ZONE,
+		Protocol:  3,
+		Algorithm: dns.ECDSAP256SHA256,
+	}
+	private, err := key.Generate(256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	zone = append(slices.Clone(zone), key)
+
+	rrsets, _ := groupRRSets(zone)
+	signed := slices.Clone(zone)
+	for name, rrset := range rrsets {
+		if name == "test./NS" || name == "a.root-servers.test./A" {
+			continue
+		}
+		sig := &dns.RRSIG{
+			Hdr:        dns.RR_Header{Name: rrset[0].Header().Name, Rrtype: dns.TypeRRSIG, Class: dns.ClassINET, Ttl: rrset[0].Header().Ttl},
+			Inception:  uint32(inception.Unix()),
+			Expiration: uint32(inception.Add(14 * 24 * time.Hour).Unix()),
+			KeyTag:     key.KeyTag(),
+			SignerName: ".",
+			Algorithm:  key.Algorithm,
+		}
+		if err := sig.Sign(private.(crypto.Signer), rrset); err != nil {
+			t.Fatal(err)
+		}
+		signed = append(signed, sig)
+	}
+	return signed, key
+}
+
+func TestVerifyRRSetSignatures(t *testing.T) {
+	now := time.Now()
+	zone, key := signTestZone(t, parseTestZone(t, dnssecTestZone, "."), now.Add(-time.Hour))
+	_, otherKey := signTestZone(t, nil, now.Add(-time.Hour))
+	rrsets, sigs := groupRRSets(zone)
+
+	tampered := []dns.RR{dns.Copy(rrsets["./SOA"][0])}
+	tampered[0].(*dns.SOA).Serial++
+
+	tests := []struct {
+		name  string
+		rrset []dns.RR
+		sigs  []*dns.RRSIG
+		keys  []*dns.DNSKEY
+		now   time.Time
+		valid bool
+	}{
+		{"valid", rrsets["./SOA"], sigs["./SOA"], []*dns.DNSKEY{key}, now, true},
+		{"valid with another key too", rrsets["test./DS"], sigs["test./DS"], []*dns.DNSKEY{otherKey, key}, now, true},
+		{"signed by another key", rrsets["./SOA"], sigs["./SOA"], []*dns.DNSKEY{otherKey}, now, false},
+		{"tampered RRset", tampered, sigs["./SOA"], []*dns.DNSKEY{key}, now, false},
+		{"signature of another RRset", rrsets["./SOA"], sigs["./NS"], []*dns.DNSKEY{key}, now, false},
+		{"expired", rrsets["./SOA"], sigs["./SOA"], []*dns.DNSKEY{key}, now.Add(30 * 24 * time.Hour), false},
+		{"not valid yet", rrsets["./SOA"], sigs["./SOA"], []*dns.DNSKEY{key}, now.Add(-2 * time.Hour), false},
+		{"unsigned", rrsets["./SOA"], nil, []*dns.DNSKEY{key}, now, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if valid := verifyRRSetSignatures(test.rrset, test.sigs, test.keys, test.now); valid != test.valid {
+				t.Errorf("valid = %v, want %v", valid, test.valid)
+			}
+		})
+	}
+}
+
+func TestVerifyZoneSignatures(t *testing.T) {
+	now := time.Now()
+	zone, key := signTestZone(t, parseTestZone(t, dnssecTestZone, "."), now.Add(-time.Hour))
+
+	var stripped []dns.RR
+	for _, rr := range zone {
+		if rr.Header().Rrtype != dns.TypeRRSIG {
+			stripped = append(stripped, rr)
+		}
+	}
+
+	var forged []dns.RR
+	for _, rr := range zone {
+		if ds, ok := rr.(*dns.DS); ok {
+			ds = dns.Copy(ds).(*dns.DS)
+			ds.KeyTag++
+			rr = ds
+		}
+		forged = append(forged, rr)
+	}
+
+	tests := []struct {
+		name     string
+		zone     []dns.RR
+		valid    int
+		invalid  []string
+		unsigned []string
+	}{
+		{"signed", zone, 5, nil, nil},
+		{"signatures stripped", stripped, 0, nil, []string{"./NS", "./NSEC", "./SOA", "test./DS", "test./NSEC"}},
+		{"forged DS", forged, 4, []string{"test./DS"}, nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rrsets, sigs := groupRRSets(test.zone)
+			result := verifyZoneSignatures(".", rrsets, sigs, []*dns.DNSKEY{key}, now)
+			if result.valid != test.valid || !slices.Equal(result.invalid, test.invalid) || !slices.Equal(result.unsigned, test.unsigned) {
+				t.Errorf(
+					"valid %d, invalid %v, unsigned %v; want %d, %v, %v",
+					result.valid, result.invalid, result.unsigned, test.valid, test.invalid, test.unsigned,
+				)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package checks
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseDNSQueryType(t *testing.T) {
+	tests := []struct {
+		qType string
+		qt    uint16
+		qc    uint16
+		err   bool
+	}{
+		{"AAAA", dns.TypeAAAA, dns.ClassINET, false},
+		{"txt", dns.TypeTXT, dns.ClassINET, false},
+		{"IN TXT", dns.TypeTXT, dns.ClassINET, false},
+		{"CH TXT", dns.TypeTXT, dns.ClassCHAOS, false},
+		{"chaos txt", dns.TypeTXT, dns.ClassCHAOS, false},
+		{"HTTPS", dns.TypeHTTPS, dns.ClassINET, false},
+		{"SVCB", dns.TypeSVCB, dns.ClassINET, false},
+		{" CH  TXT ", dns.TypeTXT, dns.ClassCHAOS, false},
+		{"AXFR", 0, 0, true},
+		{"IXFR", 0, 0, true},
+		{"OPT", 0, 0, true},
+		{"ANY", 0, 0, true},
+		{"IN ANY", 0, 0, true},
+		{"NOSUCHTYPE", 0, 0, true},
+		{"XX TXT", 0, 0, true},
+		{"IN CH TXT", 0, 0, true},
+		{"", 0, 0, true},
+	}
+	for _, test := range tests {
+		t.Run(test.qType, func(t *testing.T) {
+			qt, qc, err := parseDNSQueryType(test.qType)
+			if (err != nil) != test.err {
+				t.Fatalf("error = %v, want error: %v", err, test.err)
+			}
+			if err == nil && (qt != test.qt || qc != test.qc) {
+				t.Errorf("type and class = %s %s, want %s %s", dns.ClassToString[qc], dns.TypeToString[qt], dns.ClassToString[test.qc], dns.TypeToString[test.qt])
+			}
+		})
+	}
+}
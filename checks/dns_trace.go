@@ -0,0 +1,392 @@
+package checks
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// DNSTraceCheck walks the delegation chain of names from the root down, like dig +trace
+type DNSTraceCheck struct {
+	netiscopeCheckBase
+}
+
+// maximum number of delegations to follow before giving up
+const dnsTraceMaxHops = 16
+
+// dnsTraceServer is a name server that is asked during one hop of the trace
+type dnsTraceServer struct {
+	name    string
+	address string
+}
+
+// dnsTraceOutcome describes how a server responded during a hop
+type dnsTraceOutcome int
+
+const (
+	dnsTraceAnswer dnsTraceOutcome = iota
+	dnsTraceReferral
+	dnsTraceLame
+	dnsTraceFailure
+)
+
+// dnsTraceResponse is what one server said during a hop
+type dnsTraceResponse struct {
+	server   dnsTraceServer
+	outcome  dnsTraceOutcome
+	referral string
+	nsset    []string
+	glue     []string
+	answers  []string
+	rtt      time.Duration
+	err      error
+}
+
+// Start executes the DNS trace check
+func (check *DNSTraceCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	names := util.GetDNSNamesToLookup()
+	if len(names) == 0 {
+		check.log(LogLevelFatal, "DNS_TRACE_NO_NAMES", "The list of names to look up is empty")
+		return
+	}
+
+	roots := loadRootDNSServers(&check.netiscopeCheckBase)
+	for _, name := range names {
+		for _, af := range []string{"IPv4", "IPv6"} {
+			if check.stopping {
+				break
+			}
+			if (af == "IPv4" && util.SkipIPv4()) || (af == "IPv6" && util.SkipIPv6()) {
+				continue
+			}
+
+			var servers []dnsTraceServer
+			for _, root := range roots {
+				address := root.IPv4
+				if af == "IPv6" {
+					address = root.IPv6
+				}
+				servers = append(servers, dnsTraceServer{name: root.Letter + "-root", address: address})
+			}
+			check.traceName(name, af, servers)
+		}
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// follow the delegations for a name starting from a set of (root) servers
+func (check *DNSTraceCheck) traceName(name string, af string, servers []dnsTraceServer) {
+	name = dns.Fqdn(name)
+	zone := "."
+	var parentNSSet []string
+
+	check.log(
+		LogLevelInfo,
+		"DNS_TRACE_START",
+		fmt.Sprintf("Tracing delegations for %s over %s", name, af),
+	)
+
+	for hop := 1; hop <= dnsTraceMaxHops; hop++ {
+		if check.stopping {
+			return
+		}
+
+		responses := check.queryHop(name, af, hop, zone, servers)
+
+		// complain about servers that should know about the zone but don't
+		for _, response := range responses {
+			switch response.outcome {
+			case dnsTraceLame:
+				check.log(
+					LogLevelWarning,
+					"DNS_TRACE_LAME_DELEGATION",
+					fmt.Sprintf(
+						"Server %s (%s) is listed for %s but does not serve it (%s)",
+						response.server.name, response.server.address, zone, describeTraceError(response.err),
+					),
+				)
+			case dnsTraceFailure:
+				check.log(
+					LogLevelWarning,
+					"DNS_TRACE_SERVER_FAIL",
+					fmt.Sprintf(
+						"Server %s (%s) for %s did not respond: %v",
+						response.server.name, response.server.address, zone, response.err,
+					),
+				)
+			}
+		}
+
+		// servers for a zone should agree with each other and with the parent
+		check.compareNSSets(zone, hop, responses)
+		if hop > 1 {
+			check.compareWithChildNSSet(zone, parentNSSet, responses)
+		}
+
+		// is this the end of the road?
+		if answer := firstTraceResponse(responses, dnsTraceAnswer); answer != nil {
+			check.log(
+				LogLevelInfo,
+				"DNS_TRACE_ANSWER",
+				fmt.Sprintf(
+					"Hop %d: %s (%s) answered authoritatively for %s in %v: %v",
+					hop, answer.server.name, answer.server.address, name, answer.rtt, answer.answers,
+				),
+			)
+			check.log(
+				LogLevelInfo,
+				"DNS_TRACE_OK",
+				fmt.Sprintf("Delegation chain for %s over %s is complete after %d hops", name, af, hop),
+			)
+			return
+		}
+
+		referral := firstTraceResponse(responses, dnsTraceReferral)
+		if referral == nil {
+			check.log(
+				LogLevelError,
+				"DNS_TRACE_BROKEN",
+				fmt.Sprintf("Delegation chain for %s over %s breaks at %s (hop %d): no server gave an answer or a referral", name, af, zone, hop),
+			)
+			return
+		}
+
+		check.log(
+			LogLevelInfo,
+			"DNS_TRACE_REFERRAL",
+			fmt.Sprintf(
+				"Hop %d: %s (%s) referred %s to %s in %v: %v",
+				hop, referral.server.name, referral.server.address, zone, referral.referral, referral.rtt, referral.nsset,
+			),
+		)
+
+		zone = referral.referral
+		parentNSSet = referral.nsset
+		servers = check.resolveTraceServers(af, referral)
+		if len(servers) == 0 {
+			check.log(
+				LogLevelError,
+				"DNS_TRACE_NO_ADDRESSES",
+				fmt.Sprintf("Delegation chain for %s over %s breaks at %s: none of the name servers have %s addresses", name, af, zone, af),
+			)
+			return
+		}
+	}
+
+	check.log(
+		LogLevelError,
+		"DNS_TRACE_TOO_DEEP",
+		fmt.Sprintf("Delegation chain for %s over %s is longer than %d hops", name, af, dnsTraceMaxHops),
+	)
+}
+
+// ask all servers of one hop about the name
+func (check *DNSTraceCheck) queryHop(
+	name string,
+	af string,
+	hop int,
+	zone string,
+	servers []dnsTraceServer,
+) (responses []dnsTraceResponse) {
+	qtype := "A"
+	if af == "IPv6" {
+		qtype = "AAAA"
+	}
+
+	for _, server := range servers {
+		if check.stopping {
+			return
+		}
+
+		answers, err := DNSQuery(&check.netiscopeCheckBase, name, qtype, server.address, false, false, false, false)
+		response := dnsTraceResponse{server: server, err: err}
+		if rtt, perr := time.ParseDuration(firstOrEmpty(answers["RTT"])); perr == nil {
+			response.rtt = rtt
+		}
+
+		authoritative := slices.Contains(answers["FLAGS"], "aa")
+		referral := firstOrEmpty(answers["REFERRAL"])
+		switch {
+		case answers == nil:
+			response.outcome = dnsTraceFailure
+		case authoritative && (err == nil || firstOrEmpty(answers["RCODE"]) == "NXDOMAIN"):
+			response.outcome = dnsTraceAnswer
+			response.answers = append(answers[qtype], answers["CNAME"]...)
+			if err != nil {
+				response.answers = []string{"NXDOMAIN"}
+			}
+		case err == nil && referral != "" && referral != zone && dns.IsSubDomain(zone, referral) && dns.IsSubDomain(referral, name):
+			response.outcome = dnsTraceReferral
+			response.referral = referral
+			response.nsset = answers["NS"]
+			response.glue = answers["GLUE"]
+		default:
+			response.outcome = dnsTraceLame
+		}
+
+		check.log(
+			LogLevelDetail,
+			"DNS_TRACE_HOP",
+			fmt.Sprintf(
+				"Hop %d: asked %s (%s) for %s %s: %s in %v",
+				hop, server.name, server.address, name, qtype, describeTraceOutcome(response), response.rtt,
+			),
+		)
+		responses = append(responses, response)
+	}
+	return
+}
+
+// compare the NS sets that the servers of one hop returned
+func (check *DNSTraceCheck) compareNSSets(zone string, hop int, responses []dnsTraceResponse) {
+	var first *dnsTraceResponse
+	for i := range responses {
+		if responses[i].outcome != dnsTraceReferral {
+			continue
+		}
+		if first == nil {
+			first = &responses[i]
+			continue
+		}
+		if responses[i].referral != first.referral || !sameNSSet(responses[i].nsset, first.nsset) {
+			check.log(
+				LogLevelWarning,
+				"DNS_TRACE_NS_INCONSISTENT",
+				fmt.Sprintf(
+					"Hop %d: servers for %s disagree: %s says %s %v, %s says %s %v",
+					hop, zone,
+					first.server.name, first.referral, first.nsset,
+					responses[i].server.name, responses[i].referral, responses[i].nsset,
+				),
+			)
+		}
+	}
+}
+
+// compare the NS set the parent gave with the NS set the zone itself has
+func (check *DNSTraceCheck) compareWithChildNSSet(zone string, parentNSSet []string, responses []dnsTraceResponse) {
+	for _, response := range responses {
+		if response.outcome == dnsTraceFailure || response.outcome == dnsTraceLame {
+			continue
+		}
+		answers, err := DNSQuery(&check.netiscopeCheckBase, zone, "NS", response.server.address, false, false, false, false)
+		if err != nil || !slices.Contains(answers["FLAGS"], "aa") {
+			continue
+		}
+		if !sameNSSet(answers["NS"], parentNSSet) {
+			check.log(
+				LogLevelWarning,
+				"DNS_TRACE_NS_PARENT_CHILD_MISMATCH",
+				fmt.Sprintf(
+					"NS set for %s in the parent zone %v differs from the one served by %s %v",
+					zone, parentNSSet, response.server.name, answers["NS"],
+				),
+			)
+		}
+		return
+	}
+}
+
+// find the addresses of the name servers in a referral, using glue if possible
+func (check *DNSTraceCheck) resolveTraceServers(af string, referral *dnsTraceResponse) (servers []dnsTraceServer) {
+	network := "ip4"
+	if af == "IPv6" {
+		network = "ip6"
+	}
+
+	for _, ns := range referral.nsset {
+		found := false
+		for _, glue := range referral.glue {
+			parts := strings.Fields(glue)
+			if len(parts) != 2 || !strings.EqualFold(parts[0], ns) {
+				continue
+			}
+			if util.IsAF(4, parts[1]) == (af == "IPv4") {
+				servers = append(servers, dnsTraceServer{name: ns, address: parts[1]})
+				found = true
+			}
+		}
+		if found {
+			continue
+		}
+
+		// no glue: this is an out-of-bailiwick name server, ask the system for its address
+		addrs, err := NewTransport("", 0).Resolver().LookupIP(context.Background(), network, ns)
+		if err != nil {
+			check.log(
+				LogLevelDetail,
+				"DNS_TRACE_NS_LOOKUP_ERROR",
+				fmt.Sprintf("Could not look up %s address of name server %s: %v", af, ns, err),
+			)
+			continue
+		}
+		for _, addr := range addrs {
+			servers = append(servers, dnsTraceServer{name: ns, address: addr.String()})
+		}
+	}
+	return
+}
+
+// find the first response with a particular outcome
+func firstTraceResponse(responses []dnsTraceResponse, outcome dnsTraceOutcome) *dnsTraceResponse {
+	for i := range responses {
+		if responses[i].outcome == outcome {
+			return &responses[i]
+		}
+	}
+	return nil
+}
+
+// describe the outcome of a query in a hop in human readable form
+func describeTraceOutcome(response dnsTraceResponse) string {
+	switch response.outcome {
+	case dnsTraceAnswer:
+		return fmt.Sprintf("answer %v", response.answers)
+	case dnsTraceReferral:
+		return fmt.Sprintf("referral to %s %v", response.referral, response.nsset)
+	case dnsTraceLame:
+		return "lame (" + describeTraceError(response.err) + ")"
+	default:
+		return fmt.Sprintf("failure (%v)", response.err)
+	}
+}
+
+// describe why a response was not useful
+func describeTraceError(err error) string {
+	if err != nil {
+		return err.Error()
+	}
+	return "not authoritative and no referral"
+}
+
+// decide if two NS sets contain the same names, regardless of order and case
+func sameNSSet(a []string, b []string) bool {
+	normalise := func(set []string) []string {
+		out := make([]string, 0, len(set))
+		for _, ns := range set {
+			ns = dns.CanonicalName(ns)
+			if !slices.Contains(out, ns) {
+				out = append(out, ns)
+			}
+		}
+		slices.Sort(out)
+		return out
+	}
+	return slices.Equal(normalise(a), normalise(b))
+}
+
+// return the first element of a list, or an empty string
+func firstOrEmpty(list []string) string {
+	if len(list) == 0 {
+		return ""
+	}
+	return list[0]
+}
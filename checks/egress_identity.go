@@ -0,0 +1,235 @@
+package checks
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+	"strings"
+
+	"github.com/miekg/dns"
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// EgressIdentityCheck learns the public addresses used for DNS and HTTP and compares them
+type EgressIdentityCheck struct {
+	netiscopeCheckBase
+	resolvers []string
+}
+
+func (check *EgressIdentityCheck) configure() {
+	rc, err := parseResolvConf(util.GetResolvConfPath())
+	if err != nil {
+		check.log(LogLevelError, "NO_RESOLV_CONF", "Could not load DNS resolver data from resolv.conf")
+		return
+	}
+	if !util.SkipIPv4() {
+		check.resolvers = append(check.resolvers, rc.resolversV4...)
+	}
+	if !util.SkipIPv6() {
+		check.resolvers = append(check.resolvers, rc.resolversV6...)
+	}
+}
+
+// Start executes the egress identity check
+func (check *EgressIdentityCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	dnsEgress := check.learnDNSEgress()
+	httpEgress := check.learnHTTPEgress()
+
+	for _, af := range []string{"4", "6"} {
+		if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) {
+			continue
+		}
+		check.compareEgress(af, filterAF(dnsEgress, af), filterAF(httpEgress, af))
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// ask the resolvers for "whoami" names, which return the address the query came from
+// @return the list of unique addresses seen
+func (check *EgressIdentityCheck) learnDNSEgress() (addrs []string) {
+	for _, whoami := range util.GetEgressWhoamiNames() {
+		if len(whoami) < 2 {
+			check.log(LogLevelError, "EGRESS_CONFIG_ERROR", "Wrong whoami configuration: "+strings.Join(whoami, ","))
+			continue
+		}
+		name, qtype := whoami[0], strings.ToUpper(whoami[1])
+
+		// the name is either asked via the local resolvers, or directly from a given server
+		servers := check.resolvers
+		if len(whoami) > 2 {
+			servers = whoami[2:]
+		}
+
+		for _, server := range servers {
+			if check.stopping {
+				return
+			}
+			answers, err := DNSQuery(&check.netiscopeCheckBase, name, qtype, server, false, true, false, false)
+			if err != nil {
+				check.log(
+					LogLevelWarning,
+					"EGRESS_DNS_WHOAMI_ERROR",
+					fmt.Sprintf("Looking up %s %s via %s failed: %v", name, qtype, server, err),
+				)
+				continue
+			}
+
+			// TXT answers can contain other information too, only keep the addresses
+			for _, answer := range answers[qtype] {
+				addr, err := netip.ParseAddr(strings.Trim(answer, "\""))
+				if err != nil {
+					continue
+				}
+				check.log(
+					LogLevelInfo,
+					"EGRESS_DNS_ADDRESS",
+					fmt.Sprintf("DNS egress address via %s according to %s is %s", server, name, addr),
+				)
+				if !slices.Contains(addrs, addr.String()) {
+					addrs = append(addrs, addr.String())
+				}
+			}
+		}
+	}
+	return
+}
+
+// ask HTTP echo services about the address we connect from
+// @return the list of unique addresses seen
+func (check *EgressIdentityCheck) learnHTTPEgress() (addrs []string) {
+	for _, echo := range util.GetEgressHTTPEchoURLs() {
+		if len(echo) != 2 {
+			check.log(LogLevelError, "EGRESS_CONFIG_ERROR", "Wrong HTTP echo configuration: "+strings.Join(echo, ","))
+			continue
+		}
+		af, url := echo[0], echo[1]
+		if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) {
+			continue
+		}
+		if check.stopping {
+			return
+		}
+
+		body, err := MakeHttpGetRequest(url, af, util.GetEgressTimeout())
+		if err != nil {
+			check.log(
+				LogLevelWarning,
+				"EGRESS_HTTP_ECHO_ERROR",
+				fmt.Sprintf("Fetching %s over IPv%s failed: %v", url, af, err),
+			)
+			continue
+		}
+		addr, err := netip.ParseAddr(strings.TrimSpace(body))
+		if err != nil {
+			check.log(
+				LogLevelWarning,
+				"EGRESS_HTTP_ECHO_INVALID",
+				fmt.Sprintf("Response from %s is not an address: %+q", url, body),
+			)
+			continue
+		}
+		check.log(
+			LogLevelInfo,
+			"EGRESS_HTTP_ADDRESS",
+			fmt.Sprintf("HTTP egress address over IPv%s according to %s is %s", af, url, addr),
+		)
+		if !slices.Contains(addrs, addr.String()) {
+			addrs = append(addrs, addr.String())
+		}
+	}
+	return
+}
+
+// compare DNS and HTTP egress addresses of one address family
+func (check *EgressIdentityCheck) compareEgress(af string, dnsEgress []string, httpEgress []string) {
+	for _, addr := range append(slices.Clone(dnsEgress), httpEgress...) {
+		check.reportReverseDNS(addr)
+	}
+
+	if len(dnsEgress) == 0 || len(httpEgress) == 0 {
+		check.log(
+			LogLevelWarning,
+			"EGRESS_IPV"+af+"_UNKNOWN",
+			fmt.Sprintf("Could not learn both the DNS and HTTP egress addresses over IPv%s (DNS: %v, HTTP: %v)", af, dnsEgress, httpEgress),
+		)
+		return
+	}
+
+	prefixLength := util.GetEgressPrefixLength(af)
+	for _, httpAddr := range httpEgress {
+		for _, dnsAddr := range dnsEgress {
+			switch {
+			case httpAddr == dnsAddr:
+				check.log(
+					LogLevelInfo,
+					"EGRESS_IPV"+af+"_SAME_ADDRESS",
+					fmt.Sprintf("DNS and HTTP egress over IPv%s use the same address %s", af, httpAddr),
+				)
+			case sameNetwork(httpAddr, dnsAddr, prefixLength):
+				check.log(
+					LogLevelInfo,
+					"EGRESS_IPV"+af+"_SAME_NETWORK",
+					fmt.Sprintf("DNS egress %s and HTTP egress %s over IPv%s are in the same /%d network", dnsAddr, httpAddr, af, prefixLength),
+				)
+			default:
+				check.log(
+					LogLevelWarning,
+					"EGRESS_IPV"+af+"_DIVERGENT",
+					fmt.Sprintf(
+						"DNS egress %s and HTTP egress %s over IPv%s are in different networks: split tunnelling or a DNS forwarder elsewhere?",
+						dnsAddr, httpAddr, af,
+					),
+				)
+			}
+		}
+	}
+}
+
+// look up and report the PTR record(s) of an address
+func (check *EgressIdentityCheck) reportReverseDNS(addr string) {
+	if len(check.resolvers) == 0 {
+		return
+	}
+	arpa, err := dns.ReverseAddr(addr)
+	if err != nil {
+		return
+	}
+	answers, err := DNSQuery(&check.netiscopeCheckBase, arpa, "PTR", check.resolvers[0], false, true, false, false)
+	if err != nil || len(answers["PTR"]) == 0 {
+		check.log(
+			LogLevelInfo,
+			"EGRESS_NO_PTR",
+			fmt.Sprintf("Address %s has no reverse DNS", addr),
+		)
+		return
+	}
+	check.log(
+		LogLevelInfo,
+		"EGRESS_PTR",
+		fmt.Sprintf("Reverse DNS of %s is %v", addr, answers["PTR"]),
+	)
+}
+
+// keep only the addresses of a particular address family ("4" or "6")
+func filterAF(addrs []string, af string) (out []string) {
+	for _, addr := range addrs {
+		if (af == "4") == util.IsIPv4(addr) {
+			out = append(out, addr)
+		}
+	}
+	return
+}
+
+// decide if two addresses are in the same network of a given prefix length
+func sameNetwork(a string, b string, prefixLength int) bool {
+	addrA, errA := netip.ParseAddr(a)
+	addrB, errB := netip.ParseAddr(b)
+	if errA != nil || errB != nil || addrA.BitLen() != addrB.BitLen() {
+		return false
+	}
+	prefix, err := addrA.Prefix(prefixLength)
+	return err == nil && prefix.Contains(addrB)
+}
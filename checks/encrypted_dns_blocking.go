@@ -0,0 +1,304 @@
+package checks
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net"
+	"net/netip"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// EncryptedDNSBlockingCheck finds out if and how DoH (and DoT) is blocked: by DNS, IP or SNI
+type EncryptedDNSBlockingCheck struct {
+	netiscopeCheckBase
+	localResolvers map[string][]string // per AF
+	openResolvers  map[string][]string // per AF
+}
+
+func (check *EncryptedDNSBlockingCheck) configure() {
+	check.localResolvers = make(map[string][]string)
+	check.openResolvers = make(map[string][]string)
+
+	rc, err := parseResolvConf(util.GetResolvConfPath())
+	if err != nil {
+		check.log(LogLevelError, "NO_RESOLV_CONF", "Could not load DNS resolver data from resolv.conf")
+	} else {
+		check.localResolvers["4"] = rc.resolversV4
+		check.localResolvers["6"] = rc.resolversV6
+	}
+	for _, provider := range util.GetOpenResolverList() {
+		_, v4list, v6list, err := parseOpenResolverProvider(provider)
+		if err == nil {
+			check.openResolvers["4"] = append(check.openResolvers["4"], v4list...)
+			check.openResolvers["6"] = append(check.openResolvers["6"], v6list...)
+		}
+	}
+}
+
+// Start executes the encrypted DNS blocking check
+func (check *EncryptedDNSBlockingCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	// collect the unique host names of the DoH providers per address family
+	var seen []string
+	for _, provider := range util.GetDoHProviders() {
+		if len(provider) < 3 {
+			continue
+		}
+		af := provider[0]
+		if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) {
+			continue
+		}
+		u, err := url.Parse(provider[2])
+		if err != nil || u.Hostname() == "" {
+			check.log(LogLevelError, "ENCRYPTED_DNS_CONFIG_ERROR", fmt.Sprintf("Invalid DoH provider URL %s", provider[2]))
+			continue
+		}
+		if slices.Contains(seen, af+u.Hostname()) {
+			continue
+		}
+		seen = append(seen, af+u.Hostname())
+
+		if check.stopping {
+			break
+		}
+		check.checkHost(af, u.Hostname())
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// determine if and how a DoH host is blocked on an address family
+func (check *EncryptedDNSBlockingCheck) checkHost(af string, host string) {
+	var methods []string
+
+	// 1. name resolution via the local resolvers, compared to an open resolver
+	addrs := []string{host}
+	if _, err := netip.ParseAddr(host); err != nil {
+		var blocked bool
+		addrs, blocked = check.resolveHost(af, host)
+		if blocked {
+			methods = append(methods, "DNS")
+		}
+		if len(addrs) == 0 && len(methods) == 0 {
+			check.log(
+				LogLevelWarning,
+				"ENCRYPTED_DNS_IPV"+af+"_UNRESOLVED",
+				fmt.Sprintf("%s could not be resolved over IPv%s, so blocking cannot be tested", host, af),
+			)
+			return
+		}
+		if len(addrs) == 0 {
+			check.concludeBlocking(af, host, methods)
+			return
+		}
+	}
+
+	// 2. TCP reachability of the DoH port, and the DoT port of the hosts known to offer DoT
+	offersDoT := slices.Contains(util.GetEncryptedDNSDoTHosts(), host)
+	var reachable []string
+	var dotReachable bool
+	for _, addr := range addrs {
+		if check.dial(af, host, addr, "443") {
+			reachable = append(reachable, addr)
+		}
+		if offersDoT && check.dial(af, host, addr, "853") {
+			dotReachable = true
+		}
+	}
+	if len(reachable) == 0 {
+		methods = append(methods, "IP")
+		check.concludeBlocking(af, host, methods)
+		return
+	}
+	if offersDoT && !dotReachable {
+		// DoH works on the same addresses, so it's DoT that is blocked
+		methods = append(methods, "DoT port")
+	}
+
+	// 3. TLS handshakes with the correct and a decoy SNI
+	addr := reachable[0]
+	correctErr := check.handshake(host, addr, host, false)
+	decoyErr := check.handshake(host, addr, util.GetEncryptedDNSDecoySNI(), true)
+	switch {
+	case correctErr == nil:
+	case isCertificateError(correctErr):
+		methods = append(methods, "TLS interception")
+	case decoyErr == nil:
+		methods = append(methods, "SNI")
+	default:
+		methods = append(methods, "TLS")
+	}
+
+	check.concludeBlocking(af, host, methods)
+}
+
+// resolve a DoH host via the local resolvers and look for signs of blocking or sinkholing
+// @return the addresses to use for further tests, and if DNS based blocking was found
+func (check *EncryptedDNSBlockingCheck) resolveHost(af string, host string) (addrs []string, blocked bool) {
+	qtype := "A"
+	if af == "6" {
+		qtype = "AAAA"
+	}
+
+	var local []string
+	var empty []string // the resolvers that answered, but without addresses
+	// all local resolvers are asked, whatever address family they are reached over
+	for _, resolver := range filterResolversByAF(check.localResolvers["4"], check.localResolvers["6"]) {
+		answers, err := DNSQuery(&check.netiscopeCheckBase, host, qtype, resolver, false, true, false, false)
+		switch {
+		case answers == nil:
+			// no answer at all says nothing about blocking
+			check.log(
+				LogLevelWarning,
+				"ENCRYPTED_DNS_RESOLVER_ERROR",
+				fmt.Sprintf("Resolving %s via local resolver %s failed: %v", host, resolver, err),
+			)
+		case len(answers[qtype]) == 0:
+			empty = append(empty, fmt.Sprintf("%s (%s)", resolver, firstOrEmpty(answers["RCODE"])))
+		}
+		for _, addr := range answers[qtype] {
+			if !slices.Contains(local, addr) {
+				local = append(local, addr)
+			}
+		}
+	}
+
+	// the answers of an open resolver are used as a reference, and a fallback to test IP based blocking
+	var reference []string
+	if open := filterResolversByAF(check.openResolvers["4"], check.openResolvers["6"]); len(open) > 0 {
+		answers, err := DNSQuery(&check.netiscopeCheckBase, host, qtype, open[0], false, true, false, false)
+		if err == nil {
+			reference = answers[qtype]
+		}
+	}
+
+	// it's only blocking if the name does have addresses
+	for _, resolver := range empty {
+		level := LogLevelType(LogLevelInfo)
+		if len(reference) > 0 {
+			level = LogLevelWarning
+			blocked = true
+		}
+		check.log(
+			level,
+			"ENCRYPTED_DNS_NAME_BLOCKED",
+			fmt.Sprintf("Local resolver %s gives no %s record for %s, an open resolver gives %v", resolver, qtype, host, reference),
+		)
+	}
+	for _, addr := range local {
+		if isSinkholeAddress(addr) {
+			check.log(
+				LogLevelWarning,
+				"ENCRYPTED_DNS_NAME_SINKHOLED",
+				fmt.Sprintf("Local resolvers return %s for %s, which looks like a sinkhole", addr, host),
+			)
+			blocked = blocked || len(reference) > 0
+			continue
+		}
+		addrs = append(addrs, addr)
+	}
+	if len(addrs) > 0 && len(reference) > 0 && !slices.ContainsFunc(addrs, func(addr string) bool { return slices.Contains(reference, addr) }) {
+		check.log(
+			LogLevelWarning,
+			"ENCRYPTED_DNS_NAME_MISMATCH",
+			fmt.Sprintf("Local resolvers return %v for %s, an open resolver returns %v", addrs, host, reference),
+		)
+	}
+	if len(addrs) == 0 {
+		addrs = reference
+	}
+	check.log(
+		LogLevelDetail,
+		"ENCRYPTED_DNS_ADDRESSES",
+		fmt.Sprintf("Testing %s using addresses %v", host, addrs),
+	)
+	return
+}
+
+// try to make a TCP connection
+func (check *EncryptedDNSBlockingCheck) dial(af string, host string, addr string, port string) bool {
+	timeout := time.Duration(util.GetEncryptedDNSTimeout()) * time.Millisecond
+	transport := NewTransport(af, timeout)
+	conn, err := transport.Dial("tcp", net.JoinHostPort(addr, port))
+	if err != nil {
+		check.log(
+			LogLevelInfo,
+			"ENCRYPTED_DNS_PORT_"+port+"_UNREACHABLE",
+			fmt.Sprintf("Cannot connect to %s (%s) on TCP port %s: %v", host, addr, port, err),
+		)
+		return false
+	}
+	conn.Close()
+	check.log(
+		LogLevelInfo,
+		"ENCRYPTED_DNS_PORT_"+port+"_REACHABLE",
+		fmt.Sprintf("Connected to %s (%s) on TCP port %s", host, transport.Connection(), port),
+	)
+	return true
+}
+
+// do a TLS handshake on port 443 with a particular SNI
+// decoy: is this a decoy name? then the certificate is not verified
+func (check *EncryptedDNSBlockingCheck) handshake(host string, addr string, sni string, decoy bool) error {
+	timeout := time.Duration(util.GetEncryptedDNSTimeout()) * time.Millisecond
+	conn, err := NewTransport("", timeout).DialTLS(
+		net.JoinHostPort(addr, "443"),
+		&tls.Config{ServerName: sni, InsecureSkipVerify: decoy},
+	)
+
+	kind := "correct"
+	if decoy {
+		kind = "decoy"
+	}
+	if err != nil {
+		check.log(
+			LogLevelInfo,
+			"ENCRYPTED_DNS_TLS_"+strings.ToUpper(kind)+"_SNI_FAILED",
+			fmt.Sprintf("TLS handshake with %s (%s) using %s SNI %s failed: %v", host, addr, kind, sni, err),
+		)
+		return err
+	}
+	conn.Close()
+	check.log(
+		LogLevelInfo,
+		"ENCRYPTED_DNS_TLS_"+strings.ToUpper(kind)+"_SNI_OK",
+		fmt.Sprintf("TLS handshake with %s (%s) using %s SNI %s succeeded", host, addr, kind, sni),
+	)
+	return nil
+}
+
+// report the conclusion about a host
+func (check *EncryptedDNSBlockingCheck) concludeBlocking(af string, host string, methods []string) {
+	if len(methods) == 0 {
+		check.log(
+			LogLevelInfo,
+			"ENCRYPTED_DNS_IPV"+af+"_NOT_BLOCKED",
+			fmt.Sprintf("Encrypted DNS to %s over IPv%s does not seem to be blocked", host, af),
+		)
+		return
+	}
+	check.log(
+		LogLevelError,
+		"ENCRYPTED_DNS_IPV"+af+"_BLOCKED",
+		fmt.Sprintf("Encrypted DNS to %s over IPv%s seems to be blocked by: %s", host, af, strings.Join(methods, ", ")),
+	)
+}
+
+// addresses that are typically used to sinkhole names: anything that's not reachable on the Internet
+func isSinkholeAddress(addr string) bool {
+	class := util.ClassifyIP(addr)
+	return class != util.IPClassInvalid && !class.IsGlobal()
+}
+
+// did a TLS handshake fail because of the certificate (rather than a reset or an alert)?
+func isCertificateError(err error) bool {
+	var certErr *tls.CertificateVerificationError
+	return errors.As(err, &certErr)
+}
@@ -0,0 +1,285 @@
+package checks
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/netip"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// HappyEyeballsCheck measures IPv4 and IPv6 connection setup to dual-stack targets, tells
+// which address family a Happy Eyeballs (RFC 8305) client would use, and finds broken or slow IPv6
+type HappyEyeballsCheck struct {
+	netiscopeCheckBase
+}
+
+// the RFC 8305 recommended delays
+const (
+	happyEyeballsResolutionDelay = 50 * time.Millisecond  // wait this long for AAAA if A arrived first
+	happyEyeballsAttemptDelay    = 250 * time.Millisecond // start the next connection attempt after this
+)
+
+// what we learnt about one address family of a target
+type happyEyeballsFamily struct {
+	af         string
+	resolved   time.Duration // how long the lookup took
+	addrs      []netip.Addr
+	lookupErr  error
+	connect    LatencyStats // TCP connection setup
+	connectErr error
+	handshake  LatencyStats // TLS handshake, after the TCP connection
+	tlsErr     error
+}
+
+// usable tells if this family has addresses and connections work
+func (family *happyEyeballsFamily) usable() bool {
+	return family != nil && len(family.addrs) > 0 && family.connect.Received > 0
+}
+
+// Start executes the Happy Eyeballs check
+func (check *HappyEyeballsCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	targets := util.GetHappyEyeballsTargets()
+	if len(targets) == 0 {
+		targets = util.GetDNSNamesToLookup()
+	}
+	for _, target := range targets {
+		if check.stopping {
+			break
+		}
+		check.checkTarget(target)
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// look up, connect to and evaluate one target
+func (check *HappyEyeballsCheck) checkTarget(name string) {
+	check.log(LogLevelDetail, "HAPPY_EYEBALLS_TARGET", "Checking "+name)
+
+	families := check.resolve(name)
+	for _, family := range families {
+		if family == nil || len(family.addrs) == 0 || check.stopping {
+			continue
+		}
+		check.measure(name, family)
+	}
+	check.evaluate(name, families["4"], families["6"])
+}
+
+// look up A and AAAA records at the same time, like a Happy Eyeballs client does
+func (check *HappyEyeballsCheck) resolve(name string) map[string]*happyEyeballsFamily {
+	timeout := time.Duration(util.GetHappyEyeballsTimeout()) * time.Millisecond
+	families := make(map[string]*happyEyeballsFamily)
+	var mutex sync.Mutex
+	var wg sync.WaitGroup
+	for _, af := range []string{"4", "6"} {
+		if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) {
+			continue
+		}
+		wg.Go(func() {
+			ctx, cancel := context.WithTimeout(context.Background(), timeout)
+			defer cancel()
+			started := time.Now()
+			addrs, err := NewTransport("", timeout).Resolver().LookupNetIP(ctx, "ip"+af, name)
+			family := &happyEyeballsFamily{af: af, resolved: time.Since(started), lookupErr: err}
+			for _, addr := range addrs {
+				family.addrs = append(family.addrs, addr.Unmap())
+			}
+			mutex.Lock()
+			families[af] = family
+			mutex.Unlock()
+		})
+	}
+	wg.Wait()
+
+	for _, af := range []string{"4", "6"} {
+		family := families[af]
+		switch {
+		case family == nil:
+		case len(family.addrs) == 0:
+			check.log(
+				LogLevelDetail,
+				"HAPPY_EYEBALLS_NO_ADDRESS_IPV"+af,
+				fmt.Sprintf("%s has no IPv%s address: %v", name, af, family.lookupErr),
+			)
+		default:
+			check.log(
+				LogLevelDetail,
+				"HAPPY_EYEBALLS_RESOLVED_IPV"+af,
+				fmt.Sprintf("%s resolves to %v over IPv%s in %v", name, family.addrs, af, family.resolved.Round(time.Millisecond)),
+			)
+		}
+	}
+	return families
+}
+
+// measure TCP connection setup and TLS handshake times to the first address of a family
+// this is the address a client would try first in that family
+func (check *HappyEyeballsCheck) measure(name string, family *happyEyeballsFamily) {
+	timeout := time.Duration(util.GetHappyEyeballsTimeout()) * time.Millisecond
+	address := net.JoinHostPort(family.addrs[0].String(), strconv.Itoa(util.GetHappyEyeballsPort()))
+	attempts := max(util.GetHappyEyeballsAttempts(), 1)
+
+	var connects, handshakes []time.Duration
+	transport := NewTransport(family.af, timeout)
+	for attempt := 0; attempt < attempts && !check.stopping; attempt++ {
+		started := time.Now()
+		conn, err := transport.Dial("tcp", address)
+		if err != nil {
+			family.connectErr = err
+			continue
+		}
+		connects = append(connects, time.Since(started))
+
+		started = time.Now()
+		conn.SetDeadline(started.Add(timeout))
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: name})
+		if err = tlsConn.Handshake(); err != nil {
+			family.tlsErr = err
+		} else {
+			handshakes = append(handshakes, time.Since(started))
+		}
+		conn.Close()
+	}
+	family.connect = ComputeLatencyStats(connects, attempts)
+	family.handshake = ComputeLatencyStats(handshakes, len(connects))
+
+	if family.connect.Received == 0 {
+		check.log(
+			LogLevelDetail,
+			"HAPPY_EYEBALLS_CONNECT_ERROR_IPV"+family.af,
+			fmt.Sprintf("Cannot connect to %s (%s) over IPv%s: %v", name, address, family.af, family.connectErr),
+		)
+		return
+	}
+	handshake := "failed"
+	if family.handshake.Received > 0 {
+		handshake = mtrMilliseconds(family.handshake.Received, family.handshake.Median) + " ms"
+	}
+	check.log(
+		LogLevelInfo,
+		"HAPPY_EYEBALLS_CONNECT_IPV"+family.af,
+		fmt.Sprintf(
+			"%s (%s) over IPv%s: TCP connect %s ms, TLS handshake %s (median of %d, %s)",
+			name, address, family.af,
+			mtrMilliseconds(family.connect.Received, family.connect.Median), handshake,
+			family.connect.Received, transport.Connection(),
+		),
+	)
+	if family.tlsErr != nil {
+		check.log(
+			LogLevelWarning,
+			"HAPPY_EYEBALLS_TLS_ERROR_IPV"+family.af,
+			fmt.Sprintf("TLS handshake with %s (%s) over IPv%s failed: %v", name, address, family.af, family.tlsErr),
+		)
+	}
+}
+
+// tell which family a Happy Eyeballs client would use, and flag broken or much slower IPv6
+func (check *HappyEyeballsCheck) evaluate(name string, ipv4 *happyEyeballsFamily, ipv6 *happyEyeballsFamily) {
+	has4 := ipv4 != nil && len(ipv4.addrs) > 0
+	has6 := ipv6 != nil && len(ipv6.addrs) > 0
+
+	switch {
+	case !has4 && !has6:
+		check.log(LogLevelWarning, "HAPPY_EYEBALLS_UNRESOLVED", fmt.Sprintf("%s has no usable addresses", name))
+		return
+	case !ipv4.usable() && !ipv6.usable():
+		check.log(LogLevelWarning, "HAPPY_EYEBALLS_UNREACHABLE", fmt.Sprintf("%s cannot be connected to at all", name))
+		return
+	}
+
+	if has6 && !ipv6.usable() && ipv4.usable() {
+		check.log(
+			LogLevelError,
+			"HAPPY_EYEBALLS_IPV6_BROKEN",
+			fmt.Sprintf(
+				"%s has AAAA records but IPv6 connections fail (%v): Happy Eyeballs clients fall back to IPv4 after a delay, others hang",
+				name, ipv6.connectErr,
+			),
+		)
+	}
+	if has4 && !ipv4.usable() && ipv6.usable() {
+		check.log(
+			LogLevelWarning,
+			"HAPPY_EYEBALLS_IPV4_BROKEN",
+			fmt.Sprintf("%s has A records but IPv4 connections fail (%v)", name, ipv4.connectErr),
+		)
+	}
+	if ipv4.usable() && ipv6.usable() {
+		threshold := time.Duration(util.GetHappyEyeballsSlowerThreshold()) * time.Millisecond
+		if difference := ipv6.connect.Median - ipv4.connect.Median; difference > threshold {
+			check.log(
+				LogLevelWarning,
+				"HAPPY_EYEBALLS_IPV6_SLOW",
+				fmt.Sprintf(
+					"IPv6 connections to %s are %v slower than IPv4 ones (%v vs %v)",
+					name, difference.Round(100*time.Microsecond), ipv6.connect.Median.Round(100*time.Microsecond), ipv4.connect.Median.Round(100*time.Microsecond),
+				),
+			)
+		}
+	}
+
+	af, finish4, finish6 := happyEyeballsPick(ipv4, ipv6)
+	check.log(
+		LogLevelInfo,
+		"HAPPY_EYEBALLS_PICK",
+		fmt.Sprintf(
+			"A Happy Eyeballs client would connect to %s over IPv%s (IPv4 done after %s, IPv6 after %s)",
+			name, af, happyEyeballsDuration(finish4), happyEyeballsDuration(finish6),
+		),
+	)
+}
+
+// model an RFC 8305 client: the lookups run in parallel, IPv6 is tried first if AAAA arrives
+// within the resolution delay after A (otherwise IPv4 starts when that delay is over), the other
+// family is tried after the connection attempt delay, and the first connection to complete wins
+// returns the winning family, and when each family would have connected (-1 if never)
+func happyEyeballsPick(ipv4 *happyEyeballsFamily, ipv6 *happyEyeballsFamily) (af string, finish4 time.Duration, finish6 time.Duration) {
+	has4 := ipv4 != nil && len(ipv4.addrs) > 0
+	has6 := ipv6 != nil && len(ipv6.addrs) > 0
+
+	var start4, start6 time.Duration
+	switch {
+	case has6 && (!has4 || ipv6.resolved <= ipv4.resolved+happyEyeballsResolutionDelay):
+		start6 = ipv6.resolved
+		if has4 {
+			start4 = max(ipv4.resolved, start6+happyEyeballsAttemptDelay)
+		}
+	case has4:
+		start4 = ipv4.resolved
+		if has6 {
+			// AAAA was still pending, so IPv4 only starts after the resolution delay
+			start4 += happyEyeballsResolutionDelay
+			start6 = max(ipv6.resolved, start4+happyEyeballsAttemptDelay)
+		}
+	}
+
+	finish4, finish6 = -1, -1
+	if ipv4.usable() {
+		finish4 = start4 + ipv4.connect.Median
+	}
+	if ipv6.usable() {
+		finish6 = start6 + ipv6.connect.Median
+	}
+	if finish6 < 0 || (finish4 >= 0 && finish4 < finish6) {
+		return "4", finish4, finish6
+	}
+	return "6", finish4, finish6
+}
+
+// format a modelled connection time, or tell that it never happens
+func happyEyeballsDuration(duration time.Duration) string {
+	if duration < 0 {
+		return "never"
+	}
+	return duration.Round(100 * time.Microsecond).String()
+}
@@ -0,0 +1,55 @@
+package checks
+
+import (
+	"net/netip"
+	"testing"
+	"time"
+)
+
+func TestHappyEyeballsPick(t *testing.T) {
+	ms := time.Millisecond
+	// a family resolved after some time, with a median connection time, or failing connections if negative
+	family := func(af string, resolved time.Duration, connect time.Duration) *happyEyeballsFamily {
+		addr := netip.MustParseAddr("192.0.2.1")
+		if af == "6" {
+			addr = netip.MustParseAddr("2001:db8::1")
+		}
+		family := &happyEyeballsFamily{af: af, resolved: resolved, addrs: []netip.Addr{addr}, connect: LatencyStats{Sent: 1}}
+		if connect >= 0 {
+			family.connect.Received = 1
+			family.connect.Median = connect
+		}
+		return family
+	}
+
+	tests := []struct {
+		name    string
+		ipv4    *happyEyeballsFamily
+		ipv6    *happyEyeballsFamily
+		af      string
+		finish4 time.Duration
+		finish6 time.Duration
+	}{
+		// IPv6 starts right away, IPv4 after the attempt delay
+		{"AAAA first", family("4", 20*ms, 10*ms), family("6", 10*ms, 10*ms), "6", 270 * ms, 20 * ms},
+		{"AAAA within the resolution delay", family("4", 10*ms, 10*ms), family("6", 60*ms, 10*ms), "6", 320 * ms, 70 * ms},
+		// IPv4 starts after the resolution delay, IPv6 after the attempt delay
+		{"AAAA late", family("4", 10*ms, 10*ms), family("6", 61*ms, 10*ms), "4", 70 * ms, 320 * ms},
+		{"AAAA very late", family("4", 10*ms, 10*ms), family("6", 500*ms, 10*ms), "4", 70 * ms, 510 * ms},
+		{"IPv6 broken", family("4", 10*ms, 10*ms), family("6", 10*ms, -1), "4", 270 * ms, -1},
+		{"IPv4 broken", family("4", 10*ms, -1), family("6", 10*ms, 10*ms), "6", -1, 20 * ms},
+		{"IPv6 slower by less than the attempt delay", family("4", 10*ms, 20*ms), family("6", 10*ms, 200*ms), "6", 280 * ms, 210 * ms},
+		{"IPv6 slower by more than the attempt delay", family("4", 10*ms, 20*ms), family("6", 10*ms, 300*ms), "4", 280 * ms, 310 * ms},
+		{"IPv4 only", family("4", 10*ms, 10*ms), nil, "4", 20 * ms, -1},
+		{"IPv6 only", nil, family("6", 10*ms, 10*ms), "6", -1, 20 * ms},
+		{"no AAAA records", family("4", 10*ms, 10*ms), &happyEyeballsFamily{af: "6", resolved: 5 * ms}, "4", 20 * ms, -1},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			af, finish4, finish6 := happyEyeballsPick(test.ipv4, test.ipv6)
+			if af != test.af || finish4 != test.finish4 || finish6 != test.finish6 {
+				t.Errorf("pick = IPv%s, done after %v and %v; want IPv%s, %v and %v", af, finish4, finish6, test.af, test.finish4, test.finish6)
+			}
+		})
+	}
+}
@@ -1,36 +1,93 @@
 package checks
 
 import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"os"
 	"strings"
 	"time"
 )
 
-func MakeHttpGetRequest(url string, timeout int) (string, error) {
+// MakeHttpGetRequest fetches a URL and returns the body of the response
+// af: which address family to use ("4" or "6"), or any if empty
+// timeout: in milliseconds
+func MakeHttpGetRequest(url string, af string, timeout int) (string, error) {
+	body, _, err := makeHttpGetRequestSized(NewTransport(af, time.Duration(timeout)*time.Millisecond), url)
+	return body, err
+}
+
+// fetch a URL and return the body and the size of the response (status line, headers and body)
+// the connection is closed afterwards, so each request uses a fresh one
+func makeHttpGetRequestSized(transport *Transport, url string) (string, int, error) {
 	//fmt.Println("Making a HTTP GET request to:", url)
+	defer transport.CloseIdleConnections()
 	req, err := http.NewRequest("GET", url, nil)
 	if err != nil {
-		return "", err
-	}
-	client := &http.Client{
-		Timeout: time.Duration(timeout) * time.Millisecond,
+		return "", 0, err
 	}
+	client := transport.HTTPClient()
 	resp, err := client.Do(req)
 	if err != nil {
-		return "", err
+		return "", 0, err
 	}
 	defer resp.Body.Close()
 	body, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return "", err
+		return "", 0, err
+	}
+
+	// the headers as they were (approximately) on the wire
+	var headers strings.Builder
+	fmt.Fprintf(&headers, "%s %s\r\n", resp.Proto, resp.Status)
+	resp.Header.Write(&headers)
+	return string(body), headers.Len() + len("\r\n") + len(body), nil
+}
+
+// ClassifyConnectionError tells at what stage a connection (HTTP request) failed
+// @return "DNS", "TIMEOUT", "CONNECT", "TLS" or "OTHER"
+func ClassifyConnectionError(err error) string {
+	var dnsErr *net.DNSError
+	var opErr *net.OpError
+	var recordErr tls.RecordHeaderError
+	var alertErr tls.AlertError
+	var certErr *tls.CertificateVerificationError
+	var hostnameErr x509.HostnameError
+	var authorityErr x509.UnknownAuthorityError
+	var invalidErr x509.CertificateInvalidError
+	switch {
+	case errors.As(err, &dnsErr):
+		return "DNS"
+	case errors.As(err, &recordErr), errors.As(err, &alertErr), errors.As(err, &certErr),
+		errors.As(err, &hostnameErr), errors.As(err, &authorityErr), errors.As(err, &invalidErr):
+		return "TLS"
+	case errors.As(err, &opErr) && opErr.Op == "dial":
+		if opErr.Timeout() {
+			return "TIMEOUT"
+		}
+		return "CONNECT"
+	case errors.Is(err, context.DeadlineExceeded), os.IsTimeout(err):
+		return "TIMEOUT"
+	case strings.Contains(err.Error(), "tls:"):
+		// some TLS errors (like a reset during the handshake) are not typed
+		return "TLS"
+	default:
+		return "OTHER"
 	}
-	return string(body), nil
 }
 
-func MakeAnchorHttpRequest(anchor string, padding string, payload int, timeout int) (string, error) {
+// MakeAnchorHttpRequest asks a RIPE Atlas anchor (or the netiscope server in HTTP mode) for a payload
+// transport: pins the address family, and has the timeout
+// padding: added to the request to make it larger
+// payload: the size of the payload to ask for
+// returns the response (with the payload shortened) and the size of the response
+func MakeAnchorHttpRequest(transport *Transport, anchor string, padding string, payload int) (string, int, error) {
 	urlnopad := "http://" + anchor + "/"
 	if payload > 0 {
 		urlnopad += fmt.Sprintf("%d", payload)
@@ -41,9 +98,13 @@ func MakeAnchorHttpRequest(anchor string, padding string, payload int, timeout i
 		url = urlnopad + padding
 		urlnopad += fmt.Sprintf("[%d bytes padding]", len(padding))
 	}
-	res, err := MakeHttpGetRequest(url, timeout)
+	res, size, err := makeHttpGetRequestSized(transport, url)
 	if err != nil {
-		return res, fmt.Errorf("%s", strings.Replace(err.Error(), url, urlnopad, -1))
+		if padding == "" {
+			return res, 0, err
+		}
+		// don't show the padding itself in the error message
+		return res, 0, fmt.Errorf("%s", strings.Replace(err.Error(), url, urlnopad, -1))
 	} else {
 		type anchorResponse struct {
 			Anchor  string `json:"anchor"`
@@ -53,13 +114,28 @@ func MakeAnchorHttpRequest(anchor string, padding string, payload int, timeout i
 		var rjson anchorResponse
 		err := json.Unmarshal([]byte(res), &rjson)
 		if err != nil {
-			return res, fmt.Errorf("Failed to parse response from anchor %s: %v", anchor, err)
+			return res, 0, fmt.Errorf("Failed to parse response from anchor %s: %v", anchor, err)
 		}
 		rjson.Payload = fmt.Sprintf("[%d bytes]", len(rjson.Payload))
 		modifiedResponse, err := json.Marshal(rjson)
 		if err != nil {
-			return res, fmt.Errorf("Failed to marshal modified response from anchor %s: %v", anchor, err)
+			return res, 0, fmt.Errorf("Failed to marshal modified response from anchor %s: %v", anchor, err)
 		}
-		return string(modifiedResponse), nil
+		return string(modifiedResponse), size, nil
+	}
+}
+
+// the size of an anchor request without the padding, as sent by the Go HTTP client
+func anchorRequestSize(anchor string, payload int, padded bool) int {
+	path := "/"
+	if payload > 0 {
+		path += fmt.Sprintf("%d", payload)
+	}
+	if padded {
+		path += "?padding="
 	}
+	return len(fmt.Sprintf(
+		"GET %s HTTP/1.1\r\nHost: %s\r\nUser-Agent: Go-http-client/1.1\r\nAccept-Encoding: gzip\r\n\r\n",
+		path, anchor,
+	))
 }
@@ -0,0 +1,141 @@
+package checks
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// find a free TCP port on loopback
+func freeTCPPort(t *testing.T) int {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return listener.Addr().(*net.TCPAddr).Port
+}
+
+func TestMakeAnchorHttpRequest(t *testing.T) {
+	port := freeTCPPort(t)
+	startServer(t, "-proto", "HTTP", "-port", strconv.Itoa(port), "-anchor-name", "anchor.test")
+	anchor := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
+	transport := NewTransport("4", time.Second)
+
+	// wait for the server to start
+	var err error
+	for range 25 {
+		if _, _, err = MakeAnchorHttpRequest(transport, anchor, "", 0); err == nil {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("the HTTP anchor does not answer: %v", err)
+	}
+
+	sizes := make(map[int]int)
+	tests := []struct {
+		name    string
+		padding string
+		payload int
+	}{
+		{"no payload", "", 0},
+		{"payload", "", 1000},
+		{"padding", strings.Repeat("X", 2000), 0},
+		{"padding and payload", strings.Repeat("X", 2000), 1000},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			response, size, err := MakeAnchorHttpRequest(transport, anchor, test.padding, test.payload)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var fields map[string]string
+			if err := json.Unmarshal([]byte(response), &fields); err != nil {
+				t.Fatalf("invalid response %q: %v", response, err)
+			}
+			want := map[string]string{"anchor": "anchor.test", "client": "127.0.0.1", "payload": fmt.Sprintf("[%d bytes]", test.payload)}
+			if !reflect.DeepEqual(fields, want) {
+				t.Errorf("response = %v, want %v", fields, want)
+			}
+			if test.padding == "" {
+				sizes[test.payload] = size
+			}
+		})
+	}
+
+	// the payload grows the body, and the number in the Content-Length header
+	body := len(`{"anchor":"anchor.test","client":"127.0.0.1","payload":""}`)
+	want := 1000 + len(strconv.Itoa(body+1000)) - len(strconv.Itoa(body))
+	if sizes[1000]-sizes[0] != want {
+		t.Errorf("response sizes %d and %d differ by %d, want %d", sizes[0], sizes[1000], sizes[1000]-sizes[0], want)
+	}
+
+	// the padding is not shown in errors
+	_, _, err = MakeAnchorHttpRequest(NewTransport("4", time.Second), net.JoinHostPort("127.0.0.1", strconv.Itoa(freeTCPPort(t))), strings.Repeat("X", 100), 0)
+	if err == nil || strings.Contains(err.Error(), "XXXX") || !strings.Contains(err.Error(), "[100 bytes padding]") {
+		t.Errorf("error = %v, want one with the padding left out", err)
+	}
+}
+
+func TestAnchorRequestSize(t *testing.T) {
+	// an anchor that records the size of the requests
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	requests := make(chan int, 1)
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			var request []byte
+			buffer := make([]byte, 65536)
+			for !bytes.HasSuffix(request, []byte("\r\n\r\n")) {
+				n, err := conn.Read(buffer)
+				if err != nil {
+					break
+				}
+				request = append(request, buffer[:n]...)
+			}
+			requests <- len(request)
+			body := `{"anchor":"anchor.test","client":"127.0.0.1","payload":""}`
+			fmt.Fprintf(conn, "HTTP/1.1 200 OK\r\nContent-Length: %d\r\nContent-Type: application/json\r\n\r\n%s", len(body), body)
+			conn.Close()
+		}
+	}()
+	anchor := listener.Addr().String()
+
+	tests := []struct {
+		name    string
+		padding string
+		payload int
+	}{
+		{"no payload", "", 0},
+		{"payload", "", 1400},
+		{"padding", strings.Repeat("X", 1000), 0},
+		{"padding and payload", strings.Repeat("X", 1000), 1400},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, _, err := MakeAnchorHttpRequest(NewTransport("4", time.Second), anchor, test.padding, test.payload); err != nil {
+				t.Fatal(err)
+			}
+			want := anchorRequestSize(anchor, test.payload, test.padding != "") + len(test.padding)
+			if size := <-requests; size != want {
+				t.Errorf("request size = %d, want %d", size, want)
+			}
+		})
+	}
+}
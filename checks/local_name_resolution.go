@@ -0,0 +1,383 @@
+package checks
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// LocalNameResolutionCheck looks at what the system resolver does besides DNS: hosts file, nsswitch.conf, mDNS and LLMNR
+type LocalNameResolutionCheck struct {
+	netiscopeCheckBase
+	resolvers []string
+}
+
+// multicast name resolution groups: mDNS (RFC 6762) and LLMNR (RFC 4795)
+var multicastNameResolvers = []struct {
+	protocol string
+	af       string
+	address  string
+}{
+	{"mDNS", "4", "224.0.0.251:5353"},
+	{"mDNS", "6", "[ff02::fb]:5353"},
+	{"LLMNR", "4", "224.0.0.252:5355"},
+	{"LLMNR", "6", "[ff02::1:3]:5355"},
+}
+
+func (check *LocalNameResolutionCheck) configure() {
+	rc, err := parseResolvConf(util.GetResolvConfPath())
+	if err != nil {
+		check.log(LogLevelError, "NO_RESOLV_CONF", "Could not load DNS resolver data from resolv.conf")
+		return
+	}
+	check.resolvers = filterResolversByAF(rc.resolversV4, rc.resolversV6)
+}
+
+// Start executes the local name resolution check
+func (check *LocalNameResolutionCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	names := util.GetDNSNamesToLookup()
+	sources := check.checkNSSwitch()
+	check.checkHostsFile(names, hostsFileBeforeDNS(sources))
+	for _, name := range names {
+		if check.stopping {
+			break
+		}
+		check.compareSystemResolver(name)
+	}
+	if util.GetLocalNameResolutionMulticast() {
+		check.probeMulticast()
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// parse a hosts file
+// @return the addresses of each (lower case) name
+func parseHostsFile(path string) (map[string][]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	hosts := make(map[string][]string)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		fields := strings.Fields(line)
+		if len(fields) < 2 || net.ParseIP(fields[0]) == nil {
+			continue
+		}
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			hosts[name] = append(hosts[name], fields[0])
+		}
+	}
+	return hosts, scanner.Err()
+}
+
+// parse the "hosts" line of an nsswitch.conf file
+// @return the sources (and actions like [NOTFOUND=return]) in order
+func parseNSSwitchHosts(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line, _, _ := strings.Cut(scanner.Text(), "#")
+		database, sources, found := strings.Cut(line, ":")
+		if found && strings.TrimSpace(database) == "hosts" {
+			return strings.Fields(sources), nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return nil, fmt.Errorf("no hosts entry in %s", path)
+}
+
+// tell if the hosts file is consulted before DNS with these nsswitch.conf sources
+// systemd-resolved reads the hosts file itself, so "resolve" counts as consulting it
+func hostsFileBeforeDNS(sources []string) bool {
+	for _, source := range sources {
+		switch source {
+		case "files", "resolve":
+			return true
+		case "dns":
+			return false
+		}
+	}
+	return false
+}
+
+// look for entries in the hosts file that override the names we test
+// they only take effect if the hosts file is consulted before DNS
+func (check *LocalNameResolutionCheck) checkHostsFile(names []string, beforeDNS bool) {
+	path := util.GetHostsFilePath()
+	hosts, err := parseHostsFile(path)
+	if err != nil {
+		check.log(LogLevelWarning, "LOCAL_HOSTS_FILE_ERROR", fmt.Sprintf("Could not read %s: %v", path, err))
+		return
+	}
+	check.log(LogLevelInfo, "LOCAL_HOSTS_FILE", fmt.Sprintf("%s has entries for %d names", path, len(hosts)))
+
+	for _, name := range names {
+		addrs, ok := hosts[strings.ToLower(strings.TrimSuffix(name, "."))]
+		switch {
+		case !ok:
+		case beforeDNS:
+			check.log(
+				LogLevelWarning,
+				"LOCAL_HOSTS_OVERRIDE",
+				fmt.Sprintf("%s overrides %s with %v", path, name, addrs),
+			)
+		default:
+			check.log(
+				LogLevelInfo,
+				"LOCAL_HOSTS_ENTRY",
+				fmt.Sprintf("%s has %v for %s, but DNS is consulted first", path, addrs, name),
+			)
+		}
+	}
+}
+
+// report the order of the name resolution sources
+// @return the sources in effect
+func (check *LocalNameResolutionCheck) checkNSSwitch() []string {
+	path := util.GetNSSwitchPath()
+	sources, err := parseNSSwitchHosts(path)
+	if err != nil {
+		// glibc uses "files dns" if there's no configuration
+		check.log(LogLevelInfo, "LOCAL_NSSWITCH_DEFAULT", fmt.Sprintf("Could not read hosts sources from %s (%v), the default is \"files dns\"", path, err))
+		return []string{"files", "dns"}
+	}
+	check.log(LogLevelInfo, "LOCAL_NSSWITCH_HOSTS", fmt.Sprintf("Name resolution order in %s: %s", path, strings.Join(sources, " ")))
+
+	dnsIndex := slices.Index(sources, "dns")
+	if dnsIndex < 0 && !slices.Contains(sources, "resolve") {
+		check.log(LogLevelWarning, "LOCAL_NSSWITCH_NO_DNS", "DNS is not among the name resolution sources")
+		return sources
+	}
+	for i, source := range sources {
+		if dnsIndex >= 0 && i >= dnsIndex {
+			break
+		}
+		switch {
+		case source == "files":
+			check.log(LogLevelInfo, "LOCAL_NSSWITCH_FILES_FIRST", "The hosts file is consulted before DNS")
+		case strings.HasPrefix(source, "mdns"):
+			check.log(LogLevelInfo, "LOCAL_NSSWITCH_MDNS_FIRST", fmt.Sprintf("mDNS (%s) is consulted before DNS", source))
+		case source == "resolve":
+			check.log(LogLevelInfo, "LOCAL_NSSWITCH_RESOLVED", "Name resolution is done by systemd-resolved, which may use different resolvers than resolv.conf")
+		case strings.HasPrefix(source, "[") && strings.Contains(strings.ToUpper(source), "NOTFOUND=RETURN"):
+			check.log(LogLevelWarning, "LOCAL_NSSWITCH_NOTFOUND_RETURN", fmt.Sprintf("%s before DNS stops resolution for names not found earlier", source))
+		}
+	}
+	return sources
+}
+
+// compare what the system resolver returns with what the resolvers say directly
+func (check *LocalNameResolutionCheck) compareSystemResolver(name string) {
+	ctx, cancel := context.WithTimeout(context.Background(), time.Duration(util.GetLocalNameResolutionTimeout())*time.Millisecond)
+	defer cancel()
+	system, err := NewTransport("", 0).Resolver().LookupHost(ctx, name)
+	if err != nil {
+		check.log(LogLevelWarning, "LOCAL_SYSTEM_LOOKUP_ERROR", fmt.Sprintf("The system resolver could not resolve %s: %v", name, err))
+		return
+	}
+	system = slices.DeleteFunc(system, func(addr string) bool {
+		return (util.IsIPv4(addr) && util.SkipIPv4()) || (util.IsIPv6(addr) && util.SkipIPv6())
+	})
+
+	var direct []string
+	for _, resolver := range check.resolvers {
+		for _, qtype := range []string{"A", "AAAA"} {
+			answers, err := DNSQuery(&check.netiscopeCheckBase, name, qtype, resolver, false, true, false, false)
+			if err == nil {
+				direct = append(direct, answers[qtype]...)
+			}
+		}
+	}
+
+	var missing []string
+	for _, addr := range system {
+		if !slices.Contains(direct, addr) {
+			missing = append(missing, addr)
+		}
+	}
+	switch {
+	case len(missing) == 0:
+		check.log(
+			LogLevelInfo,
+			"LOCAL_RESOLUTION_CONSISTENT",
+			fmt.Sprintf("The system resolver returns %v for %s, consistent with the resolvers", system, name),
+		)
+	case len(missing) < len(system):
+		// CDNs and round robin records can make answers differ a bit
+		check.log(
+			LogLevelInfo,
+			"LOCAL_RESOLUTION_PARTIAL_MATCH",
+			fmt.Sprintf("The system resolver returns %v for %s, of which %v was not returned by the resolvers", system, name, missing),
+		)
+	default:
+		check.log(
+			LogLevelWarning,
+			"LOCAL_RESOLUTION_MISMATCH",
+			fmt.Sprintf("The system resolver returns %v for %s, but the resolvers return %v", system, name, direct),
+		)
+	}
+}
+
+// ask for names using mDNS and LLMNR on the local link, and report who answers
+// IPv6 link-local groups need a zone, so those queries are sent on each interface
+func (check *LocalNameResolutionCheck) probeMulticast() {
+	for _, group := range multicastNameResolvers {
+		if check.stopping {
+			return
+		}
+		if (group.af == "4" && util.SkipIPv4()) || (group.af == "6" && util.SkipIPv6()) {
+			continue
+		}
+		name := util.GetLocalNameResolutionLLMNRName()
+		if group.protocol == "mDNS" {
+			name = util.GetLocalNameResolutionMDNSName()
+		}
+		if name == "" {
+			continue
+		}
+
+		if group.af == "4" {
+			check.queryMulticast(group.protocol, group.af, group.address, "", name)
+			continue
+		}
+		zones := multicastInterfaces()
+		if len(zones) == 0 {
+			check.log(
+				LogLevelInfo,
+				"LOCAL_"+strings.ToUpper(group.protocol)+"_NO_INTERFACE",
+				fmt.Sprintf("There is no interface to send %s queries on over IPv6", group.protocol),
+			)
+		}
+		host, port, _ := net.SplitHostPort(group.address)
+		for _, zone := range zones {
+			if check.stopping {
+				return
+			}
+			check.queryMulticast(group.protocol, group.af, net.JoinHostPort(host+"%"+zone, port), zone, name)
+		}
+	}
+}
+
+// the interfaces to send link-local multicast queries on: the one selected, or all that are up and can multicast
+func multicastInterfaces() (names []string) {
+	if ifname := util.GetInterface(); ifname != "" {
+		return []string{ifname}
+	}
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagMulticast != 0 && iface.Flags&net.FlagLoopback == 0 {
+			names = append(names, iface.Name)
+		}
+	}
+	return
+}
+
+// send one mDNS or LLMNR query to a group, on an interface if given, and report the answers
+func (check *LocalNameResolutionCheck) queryMulticast(protocol string, af string, address string, ifname string, name string) {
+	where := "over IPv" + af
+	if ifname != "" {
+		where += " on " + ifname
+	}
+	responses, err := multicastNameQuery(af, address, name, util.GetLocalNameResolutionTimeout())
+	switch {
+	case err != nil:
+		check.log(
+			LogLevelInfo,
+			"LOCAL_"+strings.ToUpper(protocol)+"_ERROR",
+			fmt.Sprintf("Could not send %s query for %s %s: %v", protocol, name, where, err),
+		)
+	case len(responses) == 0:
+		check.log(
+			LogLevelInfo,
+			"LOCAL_"+strings.ToUpper(protocol)+"_NO_ANSWER",
+			fmt.Sprintf("Nobody answered the %s query for %s %s", protocol, name, where),
+		)
+	default:
+		for _, response := range responses {
+			check.log(
+				LogLevelInfo,
+				"LOCAL_"+strings.ToUpper(protocol)+"_ANSWER",
+				fmt.Sprintf("%s query for %s %s: %s", protocol, name, where, response),
+			)
+		}
+	}
+}
+
+// send a one-shot multicast query and collect the unicast responses until the timeout
+// @return a list of "responder: addresses" descriptions
+func multicastNameQuery(af string, group string, name string, timeout int) (responses []string, err error) {
+	query := new(dns.Msg)
+	qtype := dns.TypeA
+	if af == "6" {
+		qtype = dns.TypeAAAA
+	}
+	query.SetQuestion(dns.Fqdn(name), qtype)
+	query.RecursionDesired = false
+	wire, err := query.Pack()
+	if err != nil {
+		return
+	}
+
+	conn, err := NewTransport(af, 0).ListenPacket("udp", nil)
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+	target, err := net.ResolveUDPAddr("udp"+af, group)
+	if err != nil {
+		return
+	}
+	if _, err = conn.WriteTo(wire, target); err != nil {
+		return
+	}
+
+	conn.SetReadDeadline(time.Now().Add(time.Duration(timeout) * time.Millisecond))
+	buffer := make([]byte, 9000)
+	for {
+		n, from, rerr := conn.ReadFrom(buffer)
+		if rerr != nil {
+			// the deadline ends the collection
+			return
+		}
+		var response dns.Msg
+		if response.Unpack(buffer[:n]) != nil || response.Id != query.Id {
+			continue
+		}
+		var addrs []string
+		for _, answer := range response.Answer {
+			switch t := answer.(type) {
+			case *dns.A:
+				addrs = append(addrs, t.A.String())
+			case *dns.AAAA:
+				addrs = append(addrs, t.AAAA.String())
+			}
+		}
+		responses = append(responses, fmt.Sprintf("%s answered %v", from, addrs))
+	}
+}
@@ -0,0 +1,122 @@
+package checks
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"testing"
+)
+
+// write a fixture file into a temporary directory
+func writeFixture(t *testing.T, name string, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestParseHostsFile(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    map[string][]string
+	}{
+		{
+			"defaults",
+			"127.0.0.1\tlocalhost\n::1\tlocalhost ip6-localhost ip6-loopback\n",
+			map[string][]string{"localhost": {"127.0.0.1", "::1"}, "ip6-localhost": {"::1"}, "ip6-loopback": {"::1"}},
+		},
+		{
+			"comments",
+			"# 192.0.2.1 commented.example\n192.0.2.2 www.example.com # the rest is a comment\n#\n",
+			map[string][]string{"www.example.com": {"192.0.2.2"}},
+		},
+		{
+			"trailing dots and case",
+			"192.0.2.3 WWW.Example.ORG.\n",
+			map[string][]string{"www.example.org": {"192.0.2.3"}},
+		},
+		{
+			"invalid lines",
+			"not-an-address www.example.net\n192.0.2.4\n\n   \n",
+			map[string][]string{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hosts, err := parseHostsFile(writeFixture(t, "hosts", test.content))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(hosts, test.want) {
+				t.Errorf("hosts = %v, want %v", hosts, test.want)
+			}
+		})
+	}
+
+	if _, err := parseHostsFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("no error for a missing hosts file")
+	}
+}
+
+func TestParseNSSwitchHosts(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		want    []string
+		err     bool
+	}{
+		{"files and dns", "passwd: files\nhosts:  files dns\n", []string{"files", "dns"}, false},
+		{
+			"NOTFOUND=return",
+			"hosts: files mdns4_minimal [NOTFOUND=return] dns myhostname\n",
+			[]string{"files", "mdns4_minimal", "[NOTFOUND=return]", "dns", "myhostname"},
+			false,
+		},
+		{
+			"resolve",
+			"hosts: mymachines resolve [!UNAVAIL=return] files myhostname dns\n",
+			[]string{"mymachines", "resolve", "[!UNAVAIL=return]", "files", "myhostname", "dns"},
+			false,
+		},
+		{"comments", "# hosts: dns\nhosts: files dns # mdns\n", []string{"files", "dns"}, false},
+		{"no hosts line", "passwd: files\n#hosts: dns\n", nil, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			sources, err := parseNSSwitchHosts(writeFixture(t, "nsswitch.conf", test.content))
+			if (err != nil) != test.err {
+				t.Fatalf("error = %v, want error: %v", err, test.err)
+			}
+			if !slices.Equal(sources, test.want) {
+				t.Errorf("sources = %v, want %v", sources, test.want)
+			}
+		})
+	}
+}
+
+func TestHostsFileBeforeDNS(t *testing.T) {
+	tests := []struct {
+		name    string
+		sources []string
+		want    bool
+	}{
+		{"files dns", []string{"files", "dns"}, true},
+		{"dns files", []string{"dns", "files"}, false},
+		{"mDNS with NOTFOUND=return first", []string{"mdns4_minimal", "[NOTFOUND=return]", "files", "dns"}, true},
+		{"resolve before files", []string{"mymachines", "resolve", "[!UNAVAIL=return]", "files", "myhostname", "dns"}, true},
+		{"no files", []string{"dns", "myhostname"}, false},
+		{"files only", []string{"files"}, true},
+		{"neither", []string{"myhostname"}, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if before := hostsFileBeforeDNS(test.sources); before != test.want {
+				t.Errorf("before DNS = %v, want %v", before, test.want)
+			}
+		})
+	}
+}
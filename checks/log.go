@@ -3,7 +3,10 @@ package checks
 import (
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
+
+	"github.com/robert-kisteleki/netiscope/util"
 )
 
 // parse log level as a string and set log level accordingly
@@ -58,6 +61,7 @@ type ResultItem struct {
 	Mnemonic  string       `json:"mnemonic"`
 	Details   string       `json:"details"`
 	Timestamp string       `json:"timestamp"`
+	Interface string       `json:"interface,omitempty"`
 }
 
 var AllResults chan ResultItem
@@ -71,6 +75,7 @@ func NewFinding(check string, level LogLevelType, mnemonic string, details strin
 		Level:     level,
 		Mnemonic:  mnemonic,
 		Details:   details,
+		Interface: util.GetInterface(),
 	}
 }
 
@@ -91,7 +96,11 @@ func PrintResultItem(finding ResultItem, jsonFormat bool) {
 			fmt.Printf("%s\n", b)
 		} else {
 			fmt.Print(finding.Timestamp)
-			fmt.Printf("\t%s", finding.Check)
+			if finding.Interface != "" {
+				fmt.Printf("\t%s@%s", finding.Check, finding.Interface)
+			} else {
+				fmt.Printf("\t%s", finding.Check)
+			}
 			fmt.Printf("\t%s", level.String())
 			fmt.Printf("\t%s", finding.Mnemonic)
 			if finding.Details != "" {
@@ -102,6 +111,25 @@ func PrintResultItem(finding ResultItem, jsonFormat bool) {
 	}
 }
 
+// formatTable aligns the columns of a table, the header being the first line
+func formatTable(header []string, rows [][]string) (lines []string) {
+	widths := make([]int, len(header))
+	for _, row := range append([][]string{header}, rows...) {
+		for i, cell := range row {
+			widths[i] = max(widths[i], len(cell))
+		}
+	}
+
+	for _, row := range append([][]string{header}, rows...) {
+		cells := make([]string, len(row))
+		for i, cell := range row {
+			cells[i] = fmt.Sprintf("%-*s", widths[i], cell)
+		}
+		lines = append(lines, strings.TrimRight(strings.Join(cells, " | "), " "))
+	}
+	return
+}
+
 // DurationToHuman produces a humanised string version of a Duration
 func DurationToHuman(duration time.Duration) string {
 	duration = duration.Round(time.Second)
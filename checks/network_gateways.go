@@ -0,0 +1,112 @@
+package checks
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// defaultRoute is a default route from the kernel's routing table
+type defaultRoute struct {
+	iface   string
+	gateway string // empty if the route has no gateway (like on point-to-point links)
+	metric  int
+}
+
+// discover the default routes on both address families and check if the gateways are reachable
+func (check *NetworkInterfacesCheck) checkGateways() {
+	for _, af := range []string{"4", "6"} {
+		if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) {
+			continue
+		}
+
+		routes, err := readDefaultRoutes(af)
+		if errors.Is(err, errUnsupportedPlatform) {
+			check.log(LogLevelDetail, "ROUTING_TABLE_NOT_SUPPORTED", "Reading the routing tables is not supported on this platform")
+			return
+		}
+		if err != nil {
+			check.log(LogLevelError, "ROUTING_TABLE_ERROR", fmt.Sprintf("Could not read the IPv%s routing table: %v", af, err))
+			continue
+		}
+		if name := util.GetInterface(); name != "" {
+			// only the routes of the interface in use matter
+			routes = slices.DeleteFunc(routes, func(route defaultRoute) bool { return route.iface != name })
+		}
+		check.evaluateDefaultRoutes(af, routes)
+	}
+}
+
+// report the default routes of an address family, and ping the gateways
+func (check *NetworkInterfacesCheck) evaluateDefaultRoutes(af string, routes []defaultRoute) {
+	if len(routes) == 0 {
+		check.log(
+			LogLevelWarning,
+			"NO_DEFAULT_ROUTE_IPV"+af,
+			fmt.Sprintf("There is no IPv%s default route", af),
+		)
+		return
+	}
+
+	for _, route := range routes {
+		if route.gateway == "" {
+			check.log(
+				LogLevelInfo,
+				"DEFAULT_ROUTE_IPV"+af,
+				fmt.Sprintf("IPv%s default route via interface %s (no gateway), metric %d", af, route.iface, route.metric),
+			)
+			continue
+		}
+		check.log(
+			LogLevelInfo,
+			"DEFAULT_ROUTE_IPV"+af,
+			fmt.Sprintf("IPv%s default route via gateway %s on interface %s, metric %d", af, route.gateway, route.iface, route.metric),
+		)
+	}
+
+	if len(routes) > 1 {
+		// multiple default routes with the same metric compete, otherwise they're (probably) a failover setup
+		conflicting := false
+		for i := range routes {
+			for j := i + 1; j < len(routes); j++ {
+				if routes[i].metric == routes[j].metric && routes[i] != routes[j] {
+					conflicting = true
+				}
+			}
+		}
+		if conflicting {
+			check.log(
+				LogLevelWarning,
+				"MULTIPLE_DEFAULT_ROUTES_IPV"+af,
+				fmt.Sprintf("There are %d IPv%s default routes, some with the same metric", len(routes), af),
+			)
+		} else {
+			check.log(
+				LogLevelInfo,
+				"MULTIPLE_DEFAULT_ROUTES_IPV"+af,
+				fmt.Sprintf("There are %d IPv%s default routes with different metrics", len(routes), af),
+			)
+		}
+	}
+
+	if !util.GetPingGateways() {
+		return
+	}
+	for _, route := range routes {
+		if check.stopping {
+			return
+		}
+		if route.gateway == "" {
+			continue
+		}
+		target := route.gateway
+		if af == "6" && strings.HasPrefix(strings.ToLower(target), "fe80:") {
+			// link local gateways need the interface too
+			target += "%" + route.iface
+		}
+		Ping(&check.netiscopeCheckBase, target, "GATEWAY_IPV"+af)
+	}
+}
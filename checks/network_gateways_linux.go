@@ -0,0 +1,102 @@
+package checks
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/hex"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// routing flags as defined in linux/route.h
+const (
+	routeFlagUp      = 0x0001
+	routeFlagGateway = 0x0002
+	routeFlagReject  = 0x0200
+)
+
+// read the default routes of an address family from the routing tables in proc
+func readDefaultRoutes(af string) ([]defaultRoute, error) {
+	if af == "4" {
+		return parseIPv4DefaultRoutes(filepath.Join(util.GetProcRoot(), "net", "route"))
+	}
+	return parseIPv6DefaultRoutes(filepath.Join(util.GetProcRoot(), "net", "ipv6_route"))
+}
+
+// parse the default routes from /proc/net/route
+// fields: Iface Destination Gateway Flags RefCnt Use Metric Mask MTU Window IRTT
+// addresses are hex numbers in host byte order
+func parseIPv4DefaultRoutes(path string) (routes []defaultRoute, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 8 || fields[1] != "00000000" || fields[7] != "00000000" {
+			continue
+		}
+		flags, _ := strconv.ParseUint(fields[3], 16, 32)
+		if flags&routeFlagUp == 0 || flags&routeFlagReject != 0 {
+			continue
+		}
+		metric, _ := strconv.Atoi(fields[6])
+		route := defaultRoute{iface: fields[0], metric: metric}
+		if flags&routeFlagGateway != 0 {
+			// the kernel prints the address, which is in network byte order, as a host byte order number
+			value, err := strconv.ParseUint(fields[2], 16, 32)
+			if err == nil {
+				gw := make(net.IP, net.IPv4len)
+				binary.NativeEndian.PutUint32(gw, uint32(value))
+				route.gateway = gw.String()
+			}
+		}
+		routes = append(routes, route)
+	}
+	err = scanner.Err()
+	return
+}
+
+// parse the default routes from /proc/net/ipv6_route
+// fields: destination prefixlen source prefixlen nexthop metric refcnt use flags iface
+// addresses are 32 hex digits in network byte order, numbers are hex
+func parseIPv6DefaultRoutes(path string) (routes []defaultRoute, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return
+	}
+	defer file.Close()
+
+	zero := strings.Repeat("0", 32)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || fields[0] != zero || fields[1] != "00" {
+			continue
+		}
+		flags, _ := strconv.ParseUint(fields[8], 16, 32)
+		if flags&routeFlagUp == 0 || flags&routeFlagReject != 0 || fields[9] == "lo" {
+			continue
+		}
+		metric, _ := strconv.ParseInt(fields[5], 16, 64)
+		route := defaultRoute{iface: fields[9], metric: int(metric)}
+		if fields[4] != zero {
+			gw, err := hex.DecodeString(fields[4])
+			if err == nil && len(gw) == 16 {
+				route.gateway = net.IP(gw).String()
+			}
+		}
+		routes = append(routes, route)
+	}
+	err = scanner.Err()
+	return
+}
@@ -0,0 +1,59 @@
+package checks
+
+import (
+	"encoding/binary"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestParseIPv4DefaultRoutes(t *testing.T) {
+	// the fixture was taken on a little endian machine, where the kernel prints 10.9.1.2 as 0201090A
+	if binary.NativeEndian.Uint16([]byte{1, 0}) != 1 {
+		t.Skip("the fixture is in little endian byte order")
+	}
+
+	routes, err := parseIPv4DefaultRoutes(filepath.Join("testdata", "proc", "net", "route"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// not up, rejecting and non-default routes are left out
+	want := []defaultRoute{
+		{iface: "eth0", gateway: "192.0.2.1", metric: 100},
+		{iface: "wlan0", gateway: "10.9.1.2", metric: 600},
+		{iface: "ppp0", gateway: "", metric: 0},
+	}
+	if !slices.Equal(routes, want) {
+		t.Errorf("routes = %+v, want %+v", routes, want)
+	}
+
+	if _, err := parseIPv4DefaultRoutes(filepath.Join("testdata", "proc", "net", "missing")); err == nil {
+		t.Error("no error for a missing routing table")
+	}
+}
+
+func TestParseIPv6DefaultRoutes(t *testing.T) {
+	routes, err := parseIPv6DefaultRoutes(filepath.Join("testdata", "proc", "net", "ipv6_route"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the metric is hex, the loopback reject route and non-default routes are left out
+	want := []defaultRoute{
+		{iface: "eth0", gateway: "fd09:2::1", metric: 1024},
+		{iface: "wlan0", gateway: "fe80::1", metric: 600},
+		{iface: "tun0", gateway: "", metric: 1024},
+	}
+	if !slices.Equal(routes, want) {
+		t.Errorf("routes = %+v, want %+v", routes, want)
+	}
+}
+
+func TestListInterfaces(t *testing.T) {
+	names, err := listInterfaces(filepath.Join("testdata", "sys", "class", "net"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"br0", "eth0"}; !slices.Equal(names, want) {
+		t.Errorf("interfaces = %v, want %v", names, want)
+	}
+}
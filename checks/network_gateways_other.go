@@ -0,0 +1,8 @@
+//go:build !linux
+
+package checks
+
+// reading the routing tables is only implemented on Linux
+func readDefaultRoutes(af string) ([]defaultRoute, error) {
+	return nil, errUnsupportedPlatform
+}
@@ -0,0 +1,73 @@
+package checks
+
+import (
+	"encoding/binary"
+	"net"
+	"os"
+	"syscall"
+)
+
+// list the interfaces in sysfs
+func listInterfaces(netDir string) (names []string, err error) {
+	entries, err := os.ReadDir(netDir)
+	for _, entry := range entries {
+		names = append(names, entry.Name())
+	}
+	return
+}
+
+// read the addresses of all interfaces with their lifetimes from the kernel via netlink
+func readInterfaceAddresses() ([]kernelAddress, error) {
+	rib, err := syscall.NetlinkRIB(syscall.RTM_GETADDR, syscall.AF_UNSPEC)
+	if err != nil {
+		return nil, err
+	}
+	messages, err := syscall.ParseNetlinkMessage(rib)
+	if err != nil {
+		return nil, err
+	}
+
+	var addresses []kernelAddress
+	for _, message := range messages {
+		if message.Header.Type == syscall.NLMSG_DONE {
+			break
+		}
+		// struct ifaddrmsg: family, prefixlen, flags, scope, index
+		if message.Header.Type != syscall.RTM_NEWADDR || len(message.Data) < syscall.SizeofIfAddrmsg {
+			continue
+		}
+		attributes, err := syscall.ParseNetlinkRouteAttr(&message)
+		if err != nil {
+			continue
+		}
+		address := kernelAddress{
+			prefixLength: int(message.Data[1]),
+			preferred:    infiniteLifetime,
+			valid:        infiniteLifetime,
+		}
+		if ifi, err := net.InterfaceByIndex(int(binary.NativeEndian.Uint32(message.Data[4:8]))); err == nil {
+			address.ifname = ifi.Name
+		}
+		for _, attribute := range attributes {
+			switch attribute.Attr.Type {
+			case syscall.IFA_LOCAL:
+				// the local end of point-to-point links, the same as IFA_ADDRESS otherwise
+				address.addr = net.IP(attribute.Value)
+			case syscall.IFA_ADDRESS:
+				if address.addr == nil {
+					address.addr = net.IP(attribute.Value)
+				}
+			case syscall.IFA_CACHEINFO:
+				// struct ifa_cacheinfo: preferred, valid, cstamp, tstamp
+				if len(attribute.Value) >= 8 {
+					address.preferred = binary.NativeEndian.Uint32(attribute.Value[0:4])
+					address.valid = binary.NativeEndian.Uint32(attribute.Value[4:8])
+				}
+			}
+		}
+		if address.addr != nil {
+			addresses = append(addresses, address)
+		}
+	}
+	return addresses, nil
+}
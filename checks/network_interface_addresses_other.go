@@ -0,0 +1,13 @@
+//go:build !linux
+
+package checks
+
+// interface details are only read from sysfs, on Linux
+func listInterfaces(netDir string) ([]string, error) {
+	return nil, errUnsupportedPlatform
+}
+
+// reading address lifetimes is only implemented on Linux
+func readInterfaceAddresses() ([]kernelAddress, error) {
+	return nil, errUnsupportedPlatform
+}
@@ -0,0 +1,328 @@
+package checks
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// IPv6 address flags as defined in linux/if_addr.h
+var ipv6AddressFlags = []struct {
+	flag uint64
+	name string
+}{
+	{0x01, "temporary"},
+	{0x02, "nodad"},
+	{0x04, "optimistic"},
+	{0x08, "dadfailed"},
+	{0x10, "homeaddress"},
+	{0x20, "deprecated"},
+	{0x40, "tentative"},
+	{0x80, "permanent"},
+}
+
+// the error and drop counters that are watched
+var interfaceCounters = []string{"rx_errors", "tx_errors", "rx_dropped", "tx_dropped"}
+
+// the lifetime of addresses that don't expire
+const infiniteLifetime = 0xffffffff
+
+// addresses that expire sooner than this are flagged
+const addressExpiryWarning = 60 * time.Second
+
+// interfaceDetails is what sysfs tells about an interface
+type interfaceDetails struct {
+	name      string
+	operstate string
+	carrier   string
+	mtu       string
+	speed     string
+	duplex    string
+	virtual   bool // no underlying device: bridges, veth, tun and such
+	counters  map[string]uint64
+}
+
+// ifInet6Entry is what if_inet6 tells about an IPv6 address
+type ifInet6Entry struct {
+	ifname       string
+	addr         net.IP
+	prefixLength int
+	flags        uint64
+}
+
+// kernelAddress is an address of an interface as the kernel tells it, with its lifetimes in seconds
+type kernelAddress struct {
+	ifname       string
+	addr         net.IP
+	prefixLength int
+	preferred    uint32
+	valid        uint32
+}
+
+// report the link state, MTU, speed and counters of each interface, and the IPv6 address flags
+func (check *NetworkInterfacesCheck) inspectInterfaces() {
+	netDir := filepath.Join(util.GetSysRoot(), "class", "net")
+	names, err := listInterfaces(netDir)
+	if errors.Is(err, errUnsupportedPlatform) {
+		check.log(LogLevelDetail, "INTERFACE_DETAILS_NOT_SUPPORTED", "Reading interface details is not supported on this platform")
+		return
+	}
+	if err != nil {
+		check.log(LogLevelWarning, "INTERFACE_SYSFS_ERROR", fmt.Sprintf("Could not read interface details from %s: %v", netDir, err))
+		return
+	}
+
+	// the addresses come from the same proc and sys roots as the rest, so that fixture trees can be checked
+	// the kernel is only asked (for IPv4 addresses and lifetimes) if the roots are the ones of this system
+	ipv6Addresses, err := readIfInet6(filepath.Join(util.GetProcRoot(), "net", "if_inet6"))
+	if err != nil {
+		check.log(LogLevelInfo, "INTERFACE_IF_INET6_ERROR", fmt.Sprintf("Could not read IPv6 address details: %v", err))
+	}
+	var kernelAddresses []kernelAddress
+	if util.GetProcRoot() == "/proc" && util.GetSysRoot() == "/sys" {
+		kernelAddresses, err = readInterfaceAddresses()
+		if err != nil && !errors.Is(err, errUnsupportedPlatform) {
+			check.log(LogLevelInfo, "INTERFACE_NETLINK_ERROR", fmt.Sprintf("Could not read address lifetimes: %v", err))
+		}
+	}
+	if name := util.GetInterface(); name != "" {
+		// only the interface in use matters
+		names = slices.DeleteFunc(names, func(ifname string) bool { return ifname != name })
+		ipv6Addresses = slices.DeleteFunc(ipv6Addresses, func(entry ifInet6Entry) bool { return entry.ifname != name })
+		kernelAddresses = slices.DeleteFunc(kernelAddresses, func(address kernelAddress) bool { return address.ifname != name })
+	}
+
+	addresses := make(map[string][]string)
+	for _, entry := range ipv6Addresses {
+		if !entry.addr.IsLinkLocalUnicast() {
+			addresses[entry.ifname] = append(addresses[entry.ifname], fmt.Sprintf("%s/%d", entry.addr, entry.prefixLength))
+		}
+	}
+	for _, address := range kernelAddresses {
+		if address.addr.To4() != nil {
+			addresses[address.ifname] = append(addresses[address.ifname], fmt.Sprintf("%s/%d", address.addr, address.prefixLength))
+		}
+	}
+
+	var details []interfaceDetails
+	for _, name := range names {
+		if name == "lo" {
+			continue
+		}
+		iface := readInterfaceDetails(netDir, name)
+		details = append(details, iface)
+		check.evaluateInterfaceState(iface, addresses[iface.name])
+	}
+
+	// sample the counters again to see if they're rising
+	time.Sleep(time.Duration(util.GetInterfaceCounterInterval()) * time.Millisecond)
+	for _, iface := range details {
+		if check.stopping {
+			return
+		}
+		check.evaluateInterfaceCounters(iface, readInterfaceDetails(netDir, iface.name))
+	}
+
+	if !util.SkipIPv6() {
+		check.inspectIPv6Addresses(ipv6Addresses)
+	}
+	check.inspectAddressLifetimes(kernelAddresses)
+}
+
+// read the interesting attributes of an interface from sysfs
+// attributes that cannot be read (like the speed of virtual interfaces) are left empty
+func readInterfaceDetails(netDir string, name string) interfaceDetails {
+	read := func(attribute string) string {
+		value, err := os.ReadFile(filepath.Join(netDir, name, attribute))
+		if err != nil {
+			return ""
+		}
+		return strings.TrimSpace(string(value))
+	}
+
+	iface := interfaceDetails{
+		name:      name,
+		operstate: read("operstate"),
+		carrier:   read("carrier"),
+		mtu:       read("mtu"),
+		speed:     read("speed"),
+		duplex:    read("duplex"),
+		counters:  make(map[string]uint64),
+	}
+	// physical interfaces have a device behind them
+	if _, err := os.Stat(filepath.Join(netDir, name, "device")); err != nil {
+		iface.virtual = true
+	}
+	for _, counter := range interfaceCounters {
+		value, err := strconv.ParseUint(read(filepath.Join("statistics", counter)), 10, 64)
+		if err == nil {
+			iface.counters[counter] = value
+		}
+	}
+	return iface
+}
+
+// report the state of an interface and flag suspicious ones
+// addresses are the ones the interface has, other than link local ones
+func (check *NetworkInterfacesCheck) evaluateInterfaceState(iface interfaceDetails, addresses []string) {
+	description := fmt.Sprintf("operstate %s, carrier %s, MTU %s", orUnknown(iface.operstate), orUnknown(iface.carrier), orUnknown(iface.mtu))
+	if iface.speed != "" && iface.speed != "-1" {
+		description += fmt.Sprintf(", speed %s Mb/s", iface.speed)
+	}
+	if iface.duplex != "" && iface.duplex != "unknown" {
+		description += ", duplex " + iface.duplex
+	}
+	check.log(LogLevelInfo, "INTERFACE_STATE", fmt.Sprintf("Interface %s: %s", iface.name, description))
+
+	var counters []string
+	for _, counter := range interfaceCounters {
+		if iface.counters[counter] > 0 {
+			counters = append(counters, fmt.Sprintf("%s %d", counter, iface.counters[counter]))
+		}
+	}
+	if len(counters) > 0 {
+		check.log(LogLevelInfo, "INTERFACE_COUNTERS", fmt.Sprintf("Interface %s: %s", iface.name, strings.Join(counters, ", ")))
+	}
+
+	// a link that is down but has addresses is probably not what the user expects, except for
+	// bridges and other virtual devices, which have no carrier until something is attached to them
+	if (iface.operstate == "down" || iface.carrier == "0") && len(addresses) > 0 {
+		var level LogLevelType = LogLevelWarning
+		if iface.virtual {
+			level = LogLevelInfo
+		}
+		check.log(
+			level,
+			"INTERFACE_DOWN_WITH_ADDRESSES",
+			fmt.Sprintf("Interface %s is down but has addresses %v", iface.name, addresses),
+		)
+	}
+
+	if mtu, err := strconv.Atoi(iface.mtu); err == nil && mtu < 1280 {
+		check.log(
+			LogLevelWarning,
+			"INTERFACE_MTU_LOW",
+			fmt.Sprintf("Interface %s has an MTU of %d, which is below the IPv6 minimum of 1280", iface.name, mtu),
+		)
+	}
+}
+
+// compare two samples of the error and drop counters
+func (check *NetworkInterfacesCheck) evaluateInterfaceCounters(before interfaceDetails, after interfaceDetails) {
+	var rising []string
+	for _, counter := range interfaceCounters {
+		if after.counters[counter] > before.counters[counter] {
+			rising = append(rising, fmt.Sprintf("%s +%d", counter, after.counters[counter]-before.counters[counter]))
+		}
+	}
+	if len(rising) > 0 {
+		check.log(
+			LogLevelWarning,
+			"INTERFACE_ERRORS_RISING",
+			fmt.Sprintf("Interface %s has rising error counters: %s", before.name, strings.Join(rising, ", ")),
+		)
+	}
+}
+
+// read the IPv6 addresses with their flags from if_inet6
+// fields: address ifindex prefixlen scope flags ifname
+func readIfInet6(path string) ([]ifInet6Entry, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var entries []ifInet6Entry
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 6 || fields[5] == "lo" || len(fields[0]) != 32 {
+			continue
+		}
+		addr := make(net.IP, 16)
+		for i := 0; i < 16; i++ {
+			b, _ := strconv.ParseUint(fields[0][2*i:2*i+2], 16, 8)
+			addr[i] = byte(b)
+		}
+		prefixLength, _ := strconv.ParseUint(fields[2], 16, 8)
+		flags, _ := strconv.ParseUint(fields[4], 16, 32)
+		entries = append(entries, ifInet6Entry{ifname: fields[5], addr: addr, prefixLength: int(prefixLength), flags: flags})
+	}
+	return entries, scanner.Err()
+}
+
+// report the IPv6 addresses with their flags
+func (check *NetworkInterfacesCheck) inspectIPv6Addresses(entries []ifInet6Entry) {
+	for _, entry := range entries {
+		var flags []string
+		for _, f := range ipv6AddressFlags {
+			if entry.flags&f.flag != 0 {
+				flags = append(flags, f.name)
+			}
+		}
+		description := fmt.Sprintf("%s/%d on %s", entry.addr, entry.prefixLength, entry.ifname)
+		check.log(LogLevelDetail, "INTERFACE_IPV6_ADDRESS", fmt.Sprintf("IPv6 address %s, flags: %v", description, flags))
+
+		switch {
+		case entry.flags&0x08 != 0:
+			check.log(LogLevelError, "INTERFACE_IPV6_DAD_FAILED", fmt.Sprintf("Duplicate address detection failed for %s", description))
+		case entry.flags&0x40 != 0:
+			check.log(LogLevelWarning, "INTERFACE_IPV6_TENTATIVE", fmt.Sprintf("IPv6 address %s is still tentative (not usable yet)", description))
+		case entry.flags&0x20 != 0:
+			check.log(LogLevelInfo, "INTERFACE_IPV6_DEPRECATED", fmt.Sprintf("IPv6 address %s is deprecated (not used for new connections)", description))
+		}
+	}
+}
+
+// report the valid and preferred lifetimes of the addresses that expire (the ones from DHCP or SLAAC),
+// and flag the ones about to expire: their lease or the router advertisements are probably not renewed
+func (check *NetworkInterfacesCheck) inspectAddressLifetimes(addresses []kernelAddress) {
+	for _, address := range addresses {
+		if address.ifname == "lo" || address.valid == infiniteLifetime {
+			continue
+		}
+		if (address.addr.To4() != nil && util.SkipIPv4()) || (address.addr.To4() == nil && util.SkipIPv6()) {
+			continue
+		}
+		description := fmt.Sprintf("%s/%d on %s", address.addr, address.prefixLength, address.ifname)
+		valid := time.Duration(address.valid) * time.Second
+		check.log(
+			LogLevelDetail,
+			"INTERFACE_ADDRESS_LIFETIME",
+			fmt.Sprintf("Address %s is valid for %v, preferred for %s", description, valid, formatLifetime(address.preferred)),
+		)
+		if valid < addressExpiryWarning {
+			check.log(
+				LogLevelWarning,
+				"INTERFACE_ADDRESS_EXPIRING",
+				fmt.Sprintf("Address %s expires in %v", description, valid),
+			)
+		}
+	}
+}
+
+// helper to show a lifetime in seconds
+func formatLifetime(lifetime uint32) string {
+	if lifetime == infiniteLifetime {
+		return "forever"
+	}
+	return (time.Duration(lifetime) * time.Second).String()
+}
+
+// helper to show missing values
+func orUnknown(value string) string {
+	if value == "" {
+		return "unknown"
+	}
+	return value
+}
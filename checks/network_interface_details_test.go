@@ -0,0 +1,71 @@
+package checks
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadIfInet6(t *testing.T) {
+	entries, err := readIfInet6(filepath.Join("testdata", "proc", "net", "if_inet6"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// loopback and incomplete lines are left out, the prefix length and flags are hex
+	want := []struct {
+		ifname       string
+		addr         string
+		prefixLength int
+		flags        uint64
+	}{
+		{"eth0", "fd09:2::2", 64, 0x80},
+		{"eth0", "fe80::e086:daff:fe99:8387", 64, 0x80},
+		{"eth0", "2001:db8::1c2b:3a4d:5e6f:7081", 64, 0x01},
+		{"eth0", "2001:db8::bad", 64, 0xc8},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("%d entries, want %d: %+v", len(entries), len(want), entries)
+	}
+	for i, entry := range entries {
+		if entry.ifname != want[i].ifname || entry.addr.String() != want[i].addr ||
+			entry.prefixLength != want[i].prefixLength || entry.flags != want[i].flags {
+			t.Errorf("entry %d = %s %s/%d %#x, want %+v", i, entry.ifname, entry.addr, entry.prefixLength, entry.flags, want[i])
+		}
+	}
+}
+
+func TestReadInterfaceDetails(t *testing.T) {
+	netDir := filepath.Join("testdata", "sys", "class", "net")
+	tests := []struct {
+		name string
+		want interfaceDetails
+	}{
+		{
+			"eth0",
+			interfaceDetails{
+				name: "eth0", operstate: "up", carrier: "1", mtu: "1500", speed: "1000", duplex: "full",
+				counters: map[string]uint64{"rx_errors": 0, "tx_errors": 0, "rx_dropped": 12, "tx_dropped": 0},
+			},
+		},
+		{
+			// no device behind it, no speed and a missing counter
+			"br0",
+			interfaceDetails{
+				name: "br0", operstate: "up", carrier: "1", mtu: "1500", duplex: "unknown", virtual: true,
+				counters: map[string]uint64{"rx_errors": 0, "tx_errors": 0, "tx_dropped": 0},
+			},
+		},
+		{
+			"missing",
+			interfaceDetails{name: "missing", virtual: true, counters: map[string]uint64{}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if details := readInterfaceDetails(netDir, test.name); !reflect.DeepEqual(details, test.want) {
+				t.Errorf("details = %+v, want %+v", details, test.want)
+			}
+		})
+	}
+}
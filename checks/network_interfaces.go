@@ -3,6 +3,7 @@ package checks
 import (
 	"fmt"
 	"net"
+	"net/netip"
 
 	"github.com/robert-kisteleki/netiscope/util"
 )
@@ -26,8 +27,11 @@ func (check *NetworkInterfacesCheck) start() {
 	// were there any useful v4/v6 addresses?
 	var IPv4Unicast, IPv6Unicast bool
 
-	// now check all network interfaces and look for useful IP addresses
+	// now check all network interfaces (or the one in use) and look for useful IP addresses
 	for _, iface := range ifaces {
+		if name := util.GetInterface(); name != "" && iface.Name != name {
+			continue
+		}
 		addrs, _ := iface.Addrs()
 		for _, addr := range addrs {
 			ip, _, _ := net.ParseCIDR(addr.String())
@@ -70,7 +74,11 @@ func (check *NetworkInterfacesCheck) start() {
 		}
 	}
 
-	// TODO: check if the gateway(s) is/are reachable?
+	// link state, MTU, counters and IPv6 address flags of each interface
+	check.inspectInterfaces()
+
+	// are there default routes, and are the gateways reachable?
+	check.checkGateways()
 
 	check.netiscopeCheckBase.finish()
 }
@@ -82,41 +90,50 @@ func (check *NetworkInterfacesCheck) evaluateIPv4NetworkAddress(
 ) bool {
 	ip, _, _ := net.ParseCIDR(addr.String())
 	ipstring := ip.String()
+	class := util.ClassifyIP(ipstring)
 
 	// IPv4 routable?
 	if !util.SkipIPv4() && ip.IsGlobalUnicast() {
-		if util.IsIPv4NAT(ipstring) {
+		switch class {
+		case util.IPClassGlobal:
 			check.log(
 				LogLevelInfo,
 				"IPV4",
-				fmt.Sprintf("Local address %s (NAT, RFC1918)", ipstring),
+				fmt.Sprintf("Local address %s on %s", ipstring, ifname),
 			)
-		} else if util.IsIPv4Suspicious(ipstring) {
+		case util.IPClassPrivate:
 			check.log(
-				LogLevelWarning,
+				LogLevelInfo,
 				"IPV4",
-				fmt.Sprintf("Local address %s (suspicious)", ipstring),
+				fmt.Sprintf("Local address %s on %s (%s)", ipstring, ifname, class),
 			)
-		} else {
+		case util.IPClassCGNAT:
 			check.log(
-				LogLevelInfo,
+				LogLevelWarning,
+				"IPV4_CGNAT",
+				fmt.Sprintf(
+					"Local address %s on %s (%s): the ISP uses carrier-grade NAT, so incoming connections and port forwarding won't work",
+					ipstring, ifname, class,
+				),
+			)
+		default:
+			// suspicious, documentation, benchmarking and such
+			check.log(
+				LogLevelWarning,
 				"IPV4",
-				fmt.Sprintf("Local address %s", ipstring),
+				fmt.Sprintf("Local address %s on %s (%s)", ipstring, ifname, class),
 			)
 		}
 		return true
 	}
 
 	// IPv4 non-routable?
-	if !util.SkipIPv4() && ip.IsLinkLocalUnicast() {
-		if util.IsIPv4DCHP(ipstring) {
-			check.log(
-				LogLevelWarning,
-				"IPV4",
-				fmt.Sprintf("Local address %s (no address obtained via DHCP?)", ipstring),
-			)
-			return false
-		}
+	if !util.SkipIPv4() && class == util.IPClassLinkLocal {
+		check.log(
+			LogLevelWarning,
+			"IPV4",
+			fmt.Sprintf("Local address %s on %s (no address obtained via DHCP?)", ipstring, ifname),
+		)
 	}
 
 	return false
@@ -128,16 +145,68 @@ func (check *NetworkInterfacesCheck) evaluateIPv6NetworkAddress(
 ) bool {
 	ip, _, _ := net.ParseCIDR(addr.String())
 	ipstring := ip.String()
+	class := util.ClassifyIP(ipstring)
 
-	// IPv6 routable?
-	if !util.SkipIPv6() && ip.IsGlobalUnicast() && !util.IsIPv6ULA(ipstring) {
+	if util.SkipIPv6() {
+		return false
+	}
+
+	switch {
+	case class == util.IPClassGlobal:
 		check.log(
 			LogLevelInfo,
 			"IPV6",
-			fmt.Sprintf("Local address %s", ipstring),
+			fmt.Sprintf("Local address %s on %s", ipstring, ifname),
+		)
+		return true
+	case class == util.IPClass6to4 || class == util.IPClassTeredo:
+		// transition mechanisms work, but are usually worse than native IPv6
+		check.log(
+			LogLevelWarning,
+			"IPV6",
+			fmt.Sprintf("Local address %s on %s (%s)", ipstring, ifname, class),
+		)
+		return true
+	case class == util.IPClassULA:
+		check.log(
+			LogLevelInfo,
+			"IPV6",
+			fmt.Sprintf("Local address %s on %s (%s)", ipstring, ifname, class),
+		)
+	case ip.IsGlobalUnicast():
+		// documentation, benchmarking and such
+		check.log(
+			LogLevelWarning,
+			"IPV6",
+			fmt.Sprintf("Local address %s on %s (%s)", ipstring, ifname, class),
 		)
 		return true
 	}
 
 	return false
 }
+
+// UsableInterfaces lists the interfaces that are up and have an address that is not loopback or link local
+func UsableInterfaces() (names []string) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 || iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			prefix, err := netip.ParsePrefix(addr.String())
+			if err != nil {
+				continue
+			}
+			if class := util.ClassifyAddr(prefix.Addr()); class != util.IPClassLinkLocal && class != util.IPClassLoopback {
+				names = append(names, iface.Name)
+				break
+			}
+		}
+	}
+	return
+}
@@ -1,86 +1,208 @@
 package checks
 
 import (
+	"errors"
 	"fmt"
 	"github.com/robert-kisteleki/netiscope/util"
+	"net"
 	"strings"
+	"sync"
+	"time"
 )
 
 type PathMTUHTTPCheck struct {
 	netiscopeCheckBase
 }
 
-// PathMTUHTTPCheck checks if there is a likelyhood of IPv6 PMTUD problems
-// by making HTTP reques	ts with different payload sizes and checking the responses
+// pathMTUSearch is the outcome of the size search in one direction
+type pathMTUSearch struct {
+	largestWorking  int // the largest size (request padding or response payload) that worked
+	smallestFailing int // the smallest size that failed, 0 if all of them worked
+	wireSize        int // the size of the largest working request or response, with headers
+}
+
+// the IP and TCP header overhead of a full segment: IP header, TCP header with timestamps
+var pathMTUOverhead = map[string]int{"4": 20 + 32, "6": 40 + 32}
+
+// PathMTUHTTPCheck checks if there is a likelyhood of PMTUD problems on IPv4 and IPv6
+// by making HTTP requests with different request and response sizes and checking the responses
 func (check *PathMTUHTTPCheck) start() {
 	check.netiscopeCheckBase.start()
 
-	if util.SkipIPv6() {
-		check.log(LogLevelAdmin, "SKIP_IPV6", "IPv6 checks are disabled by configuration")
-		check.netiscopeCheckBase.finish()
+	timeout := util.GetPathMTUHTTPCheckTimeout()
+	targets := util.GetTargetsToPathMTUHTTPCheck()
+
+	// targets are checked in parallel, a limited number at a time
+	var wg sync.WaitGroup
+	parallel := make(chan struct{}, max(util.GetPathMTUHTTPParallel(), 1))
+	for _, target := range targets {
+		for _, af := range []string{"4", "6"} {
+			if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) {
+				continue
+			}
+			wg.Go(func() {
+				parallel <- struct{}{}
+				defer func() { <-parallel }()
+				if !check.stopping {
+					check.checkTarget(target, af, timeout)
+				}
+			})
+		}
+	}
+	wg.Wait()
+
+	check.netiscopeCheckBase.finish()
+}
+
+// check the path MTU to one target over one address family, in both directions
+func (check *PathMTUHTTPCheck) checkTarget(target string, af string, timeout int) {
+	name := fmt.Sprintf("%s over IPv%s", target, af)
+	check.log(LogLevelDetail, "PATH_MTU_TARGET", "Checking path MTU for target "+name)
+
+	transport := NewTransport(af, time.Duration(timeout)*time.Millisecond)
+
+	// if a small request doesn't work then nothing else will
+	if _, _, err := MakeAnchorHttpRequest(transport, target, "", 0); err != nil {
+		var addrErr *net.AddrError
+		if ClassifyConnectionError(err) == "DNS" || errors.As(err, &addrErr) {
+			// no address in this address family
+			check.log(LogLevelDetail, "PATH_MTU_NO_ADDRESS", fmt.Sprintf("Path MTU check for %s skipped: %v", name, err))
+			return
+		}
+		check.log(LogLevelWarning, "PATH_MTU_UNREACHABLE", fmt.Sprintf("Path MTU check for %s failed: target is down? %v", name, err))
 		return
 	}
+	connection := transport.Connection()
+	check.log(LogLevelDetail, "PATH_MTU_CONNECTION", fmt.Sprintf("Path MTU check for %s uses %s", name, connection))
 
-	// TODO: do these in parallel
+	// forward direction: larger and larger requests with small responses
+	forward := check.searchSize(name, util.GetPathMTUHTTPSizes("request_sizes"), func(size int) (int, error) {
+		response, _, err := MakeAnchorHttpRequest(transport, target, strings.Repeat("X", size), 0)
+		check.logAttempt(name, size, 0, response, err)
+		return anchorRequestSize(target, 0, true) + size, err
+	})
+	if check.stopping {
+		return
+	}
 
-	increases := []int{0, 100, 2000}
-	var successes [][]bool
-	successes = make([][]bool, len(increases))
-	for i := range successes {
-		successes[i] = make([]bool, len(increases))
+	// return direction: larger and larger responses to small requests
+	ret := check.searchSize(name, util.GetPathMTUHTTPSizes("response_sizes"), func(size int) (int, error) {
+		response, wireSize, err := MakeAnchorHttpRequest(transport, target, "", size)
+		check.logAttempt(name, 0, size, response, err)
+		return wireSize, err
+	})
+	if check.stopping {
+		return
 	}
 
-	timeout := util.GetPathMTUHTTPCheckTimeout()
-	targets := util.GetTargetsToPathMTUHTTPCheck()
-	for _, target := range targets {
-		check.log(LogLevelDetail, "PATH_MTU_TARGET", "Checking path MTU for target "+target)
-		for ipl, payload := range increases {
-			for ipd, padding := range increases {
-				if check.stopping {
-					return
-				}
-				httpResponse, err := MakeAnchorHttpRequest(target, strings.Repeat("X", padding), payload, timeout)
-				if err != nil {
-					check.log(
-						LogLevelDetail,
-						"PATH_MTU_REQUEST_ERROR",
-						fmt.Sprintf(
-							"Error making HTTP request to %s with payload size %d and padding size %d: %v",
-							target, payload, padding, err,
-						),
-					)
-					successes[ipl][ipd] = false
-					continue
+	// the first full size segment is the one that gets lost, so the largest working size tells the MTU
+	forwardMTU := forward.wireSize + pathMTUOverhead[af]
+	returnMTU := ret.wireSize + pathMTUOverhead[af]
+	switch {
+	case forward.smallestFailing == 0 && ret.smallestFailing == 0:
+		check.log(
+			LogLevelInfo,
+			"PATH_MTU_SUCCESS",
+			fmt.Sprintf(
+				"Path MTU check for %s (%s) successful: requests up to %d bytes and responses up to %d bytes work",
+				name, connection, forward.wireSize, ret.wireSize,
+			),
+		)
+	case ret.smallestFailing == 0:
+		check.log(
+			LogLevelError,
+			"PATH_MTU_ERROR_FORWARD",
+			fmt.Sprintf(
+				"Path MTU check for %s (%s) fails with requests over %d bytes, the estimated forward path MTU is %d. This may indicate PMTUD problems on the forward path.",
+				name, connection, forward.wireSize, forwardMTU,
+			),
+		)
+	case forward.smallestFailing == 0:
+		check.log(
+			LogLevelError,
+			"PATH_MTU_ERROR_RETURN",
+			fmt.Sprintf(
+				"Path MTU check for %s (%s) fails with responses over %d bytes, the estimated return path MTU is %d. This may indicate PMTUD problems on the return path.",
+				name, connection, ret.wireSize, returnMTU,
+			),
+		)
+	default:
+		check.log(
+			LogLevelError,
+			"PATH_MTU_ERROR_BOTH",
+			fmt.Sprintf(
+				"Path MTU check for %s (%s) fails with requests over %d bytes and responses over %d bytes, the estimated forward path MTU is %d and the return path MTU is %d. There may be PMTUD problems in both directions.",
+				name, connection, forward.wireSize, ret.wireSize, forwardMTU, returnMTU,
+			),
+		)
+	}
+}
+
+// find the smallest failing size: try the configured sizes in increasing order,
+// then narrow down between the largest working and the smallest failing one
+// try: makes a request of a given size, returns its size on the wire
+func (check *PathMTUHTTPCheck) searchSize(name string, sizes []int, try func(int) (int, error)) (result pathMTUSearch) {
+	// a size is only considered failing if it fails twice, to avoid being fooled by packet loss
+	works := func(size int) bool {
+		for attempt := 0; attempt < 2; attempt++ {
+			if wireSize, err := try(size); err == nil {
+				if size >= result.largestWorking {
+					result.largestWorking = size
+					result.wireSize = wireSize
 				}
-				check.log(
-					LogLevelDetail,
-					"PATH_MTU_RESPONSE",
-					fmt.Sprintf(
-						"Received response from %s with payload size %d and padding size %d: %s",
-						target, payload, padding, httpResponse,
-					),
-				)
-				successes[ipl][ipd] = true
+				return true
 			}
 		}
+		return false
+	}
 
-		n := len(increases) - 1
-		// heuristic: use the corners of the test matrix
-		// TODO: this can be improved
-		successes[0][n] = false
-		switch {
-		case successes[n][n]:
-			check.log(LogLevelInfo, "PATH_MTU_SUCCESS", fmt.Sprintf("Path MTU check for %s successful", target))
-		case !successes[0][0]:
-			check.log(LogLevelWarning, "PATH_MTU_UNREACHABLE", fmt.Sprintf("Path MTU check for %s failed: target is down?", target))
-		case !successes[0][n]:
-			check.log(LogLevelError, "PATH_MTU_ERROR_FORWARD", fmt.Sprintf("Path MTU check for %s fails with large return packets. This may indicate PMTUD problems on the return path.", target))
-		case !successes[n][0]:
-			check.log(LogLevelError, "PATH_MTU_ERROR_RETURN", fmt.Sprintf("Path MTU check for %s fails with large outgoing packets. This may indicate PMTUD problems on the forward path.", target))
-		default:
-			check.log(LogLevelError, "PATH_MTU_ERROR_BOTH", fmt.Sprintf("Path MTU check for %s is mixed, there may be PMTUD problems.", target))
+	for _, size := range sizes {
+		if check.stopping {
+			return
+		}
+		if !works(size) {
+			result.smallestFailing = size
+			break
 		}
 	}
+	if result.smallestFailing == 0 {
+		return
+	}
 
-	check.netiscopeCheckBase.finish()
+	precision := max(util.GetPathMTUHTTPPrecision(), 1)
+	for result.smallestFailing-result.largestWorking > precision && !check.stopping {
+		size := (result.largestWorking + result.smallestFailing) / 2
+		if !works(size) {
+			result.smallestFailing = size
+		}
+	}
+	check.log(
+		LogLevelDetail,
+		"PATH_MTU_SEARCH",
+		fmt.Sprintf("Path MTU check for %s: size %d works, %d fails", name, result.largestWorking, result.smallestFailing),
+	)
+	return
+}
+
+// log the outcome of a request
+func (check *PathMTUHTTPCheck) logAttempt(name string, padding int, payload int, response string, err error) {
+	if err != nil {
+		check.log(
+			LogLevelDetail,
+			"PATH_MTU_REQUEST_ERROR",
+			fmt.Sprintf(
+				"Error making HTTP request to %s with payload size %d and padding size %d: %v",
+				name, payload, padding, err,
+			),
+		)
+		return
+	}
+	check.log(
+		LogLevelDetail,
+		"PATH_MTU_RESPONSE",
+		fmt.Sprintf(
+			"Received response from %s with payload size %d and padding size %d: %s",
+			name, payload, padding, response,
+		),
+	)
 }
@@ -0,0 +1,348 @@
+package checks
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"os"
+	"syscall"
+	"time"
+
+	"github.com/robert-kisteleki/netiscope/util"
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// PathMTUICMPCheck finds the path MTU to targets with DF ICMP echo requests and a binary search
+type PathMTUICMPCheck struct {
+	netiscopeCheckBase
+}
+
+// the outcome of a probe of a given size
+type pmtuProbeResult int
+
+const (
+	pmtuFits   pmtuProbeResult = iota // an echo reply arrived
+	pmtuTooBig                        // a PTB message arrived, or the kernel refused to send it
+	pmtuLost                          // no answer at all
+)
+
+// well known reasons for a reduced MTU
+var knownMTUs = map[int]string{
+	1492: "typical of PPPoE",
+	1480: "typical of 6in4 or IPIP tunnels",
+	1476: "typical of GRE tunnels",
+	1420: "typical of WireGuard",
+	1280: "the IPv6 minimum, typical of tunnels",
+}
+
+// pmtuProber sends echo requests of different sizes with the don't fragment bit set
+type pmtuProber struct {
+	af      string
+	target  netip.Addr
+	conn    net.PacketConn
+	id      uint16
+	seq     uint16
+	timeout time.Duration
+
+	// what we learnt about PTB messages
+	ptbSeen   bool // at least one PTB message arrived
+	ptbMTU    int  // the smallest MTU reported in them
+	ptbFrom   netip.Addr
+	kernelPTB bool // the kernel refused to send a probe: it already knows a smaller path MTU
+}
+
+// Start executes the ICMP path MTU check
+func (check *PathMTUICMPCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	unavailable := make(map[string]bool)
+	for _, host := range util.GetPathMTUICMPTargets() {
+		addrs := lookupTargetAddresses(host)
+		if len(addrs) == 0 {
+			check.log(LogLevelWarning, "PATH_MTU_ICMP_RESOLVE_ERROR", fmt.Sprintf("Could not resolve target %s", host))
+			continue
+		}
+		for _, addr := range addrs {
+			if check.stopping {
+				break
+			}
+			af := "4"
+			if addr.Is6() {
+				af = "6"
+			}
+			if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) || unavailable[af] {
+				continue
+			}
+			err := check.discoverPathMTU(af, host, addr)
+			switch {
+			case err == nil:
+			case errors.Is(err, os.ErrPermission):
+				check.log(
+					LogLevelWarning,
+					"PATH_MTU_ICMP_NO_RAW_SOCKET",
+					fmt.Sprintf("Path MTU discovery over IPv%s needs raw sockets (run as root or with CAP_NET_RAW): %v", af, err),
+				)
+				unavailable[af] = true
+			case errors.Is(err, errUnsupportedPlatform):
+				check.log(
+					LogLevelInfo,
+					"PATH_MTU_ICMP_NOT_SUPPORTED",
+					fmt.Sprintf("Path MTU discovery over IPv%s is not supported on this platform", af),
+				)
+				unavailable[af] = true
+			default:
+				check.log(
+					LogLevelWarning,
+					"PATH_MTU_ICMP_ERROR",
+					fmt.Sprintf("Path MTU discovery to %s (%s) failed: %v", host, addr, err),
+				)
+			}
+		}
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// find the path MTU to one target address with a binary search
+func (check *PathMTUICMPCheck) discoverPathMTU(af string, host string, target netip.Addr) error {
+	source, ifname, ifmtu, err := outgoingInterface(af, target)
+	if err != nil {
+		return err
+	}
+
+	prober, err := newPMTUProber(af, target, time.Duration(util.GetPathMTUICMPTimeout())*time.Millisecond)
+	if err != nil {
+		return err
+	}
+	defer prober.conn.Close()
+
+	name := fmt.Sprintf("%s (%s)", host, target)
+	check.log(
+		LogLevelDetail,
+		"PATH_MTU_ICMP_TARGET",
+		fmt.Sprintf("Path MTU discovery to %s from %s via %s (MTU %d)", name, source, ifname, ifmtu),
+	)
+
+	// the lower end is a small packet, if that doesn't work there's no point in going on
+	low := 128
+	if result := prober.probe(low); result != pmtuFits {
+		check.log(LogLevelWarning, "PATH_MTU_ICMP_UNREACHABLE", fmt.Sprintf("%s does not answer ICMP echo requests", name))
+		return nil
+	}
+
+	// IPv6 links must support 1280
+	if af == "6" {
+		if result := prober.probe(1280); result != pmtuFits {
+			check.log(
+				LogLevelError,
+				"PATH_MTU_ICMP_IPV6_BELOW_MINIMUM",
+				fmt.Sprintf("%s does not answer 1280 byte packets, the IPv6 minimum MTU", name),
+			)
+			return nil
+		}
+		low = 1280
+	}
+
+	// the upper end is what the local interface allows
+	high := min(ifmtu, util.GetPathMTUICMPMaxMTU())
+	pmtu := high
+	if prober.probe(high) != pmtuFits {
+		pmtu = prober.search(low, high, &check.stopping)
+	}
+	if check.stopping {
+		return nil
+	}
+
+	hint := ""
+	if known, ok := knownMTUs[pmtu]; ok {
+		hint = " (" + known + ")"
+	}
+	switch {
+	case pmtu == high:
+		check.log(
+			LogLevelInfo,
+			"PATH_MTU_ICMP_FULL",
+			fmt.Sprintf("Path MTU to %s is %d, the full size allowed by %s", name, pmtu, ifname),
+		)
+	case prober.ptbSeen:
+		check.log(
+			LogLevelInfo,
+			"PATH_MTU_ICMP_REDUCED",
+			fmt.Sprintf(
+				"Path MTU to %s is %d%s, PTB messages arrive (from %s, reporting %d)",
+				name, pmtu, hint, prober.ptbFrom, prober.ptbMTU,
+			),
+		)
+	case prober.kernelPTB:
+		check.log(
+			LogLevelInfo,
+			"PATH_MTU_ICMP_REDUCED",
+			fmt.Sprintf("Path MTU to %s is %d%s, the kernel already knew about this from earlier PTB messages", name, pmtu, hint),
+		)
+	default:
+		check.log(
+			LogLevelError,
+			"PATH_MTU_ICMP_BLACK_HOLE",
+			fmt.Sprintf(
+				"Path MTU to %s is %d%s, but larger packets are silently dropped: no PTB (fragmentation needed / packet too big) messages arrive, this is a PMTUD black hole",
+				name, pmtu, hint,
+			),
+		)
+	}
+	return nil
+}
+
+// binary search for the largest size that fits, between low (fits) and high (does not fit)
+func (prober *pmtuProber) search(low int, high int, stopping *bool) int {
+	for high-low > 1 && !*stopping {
+		size := (low + high) / 2
+		// trust the MTU reported in a PTB message, it's verified by the next probe anyway
+		if prober.ptbMTU > low && prober.ptbMTU < high {
+			size = prober.ptbMTU
+		}
+		if prober.probe(size) == pmtuFits {
+			low = size
+		} else {
+			high = size
+		}
+	}
+	return low
+}
+
+// open a raw ICMP socket with the don't fragment bit set
+func newPMTUProber(af string, target netip.Addr, timeout time.Duration) (*pmtuProber, error) {
+	network := "ip4:icmp"
+	if af == "6" {
+		network = "ip6:ipv6-icmp"
+	}
+	conn, err := NewTransport(af, 0).ListenPacket(network, dontFragmentControl)
+	if err != nil {
+		return nil, err
+	}
+	return &pmtuProber{
+		af:      af,
+		target:  target,
+		conn:    conn,
+		id:      uint16(rand.Intn(0xffff)),
+		timeout: timeout,
+	}, nil
+}
+
+// send an echo request of a given (IP packet) size, with retries
+func (prober *pmtuProber) probe(size int) pmtuProbeResult {
+	header := 20
+	echoRequest := icmp.Type(ipv4.ICMPTypeEcho)
+	if prober.af == "6" {
+		header = 40
+		echoRequest = ipv6.ICMPTypeEchoRequest
+	}
+
+	for attempt := 0; attempt < util.GetPathMTUICMPAttempts(); attempt++ {
+		prober.seq++
+		msg := icmp.Message{
+			Type: echoRequest,
+			Body: &icmp.Echo{ID: int(prober.id), Seq: int(prober.seq), Data: make([]byte, max(size-header-8, 0))},
+		}
+		wire, err := msg.Marshal(nil)
+		if err != nil {
+			return pmtuLost
+		}
+		if _, err := prober.conn.WriteTo(wire, &net.IPAddr{IP: prober.target.AsSlice()}); err != nil {
+			if errors.Is(err, syscall.EMSGSIZE) {
+				prober.kernelPTB = true
+				return pmtuTooBig
+			}
+			return pmtuLost
+		}
+
+		prober.conn.SetReadDeadline(time.Now().Add(prober.timeout))
+		buffer := make([]byte, 65536)
+		for {
+			n, from, err := prober.conn.ReadFrom(buffer)
+			if err != nil {
+				break
+			}
+			result, mtu, ok := parsePMTUResponse(prober.af, prober.id, prober.seq, prober.target, buffer[:n])
+			if !ok {
+				continue
+			}
+			if result == pmtuTooBig {
+				fromAddr, _ := netip.AddrFromSlice(from.(*net.IPAddr).IP)
+				prober.ptbSeen = true
+				prober.ptbFrom = fromAddr.Unmap()
+				if prober.ptbMTU == 0 || mtu < prober.ptbMTU {
+					prober.ptbMTU = mtu
+				}
+			}
+			return result
+		}
+	}
+	return pmtuLost
+}
+
+// parse an ICMP message and tell if it is a response to our probe
+// returns the result, and the MTU reported by a PTB message
+func parsePMTUResponse(af string, id uint16, seq uint16, target netip.Addr, msg []byte) (result pmtuProbeResult, mtu int, ok bool) {
+	if len(msg) < 8 {
+		return
+	}
+	echoReply, echoRequest := byte(0), byte(8)
+	if af == "6" {
+		echoReply, echoRequest = 129, 128
+	}
+
+	switch {
+	case msg[0] == echoReply:
+		if binary.BigEndian.Uint16(msg[4:6]) != id || binary.BigEndian.Uint16(msg[6:8]) != seq {
+			return
+		}
+		return pmtuFits, 0, true
+	case af == "4" && msg[0] == 3 && msg[1] == 4:
+		// fragmentation needed, the next hop MTU is in the second half of the header
+		mtu = int(binary.BigEndian.Uint16(msg[6:8]))
+	case af == "6" && msg[0] == 2:
+		// packet too big
+		mtu = int(binary.BigEndian.Uint32(msg[4:8]))
+	default:
+		return
+	}
+
+	// the PTB message should be about our probe
+	_, dst, transport, valid := parseEmbeddedPacket(af, msg[8:])
+	if !valid || dst != target || len(transport) < 8 || transport[0] != echoRequest {
+		return
+	}
+	if binary.BigEndian.Uint16(transport[4:6]) != id || binary.BigEndian.Uint16(transport[6:8]) != seq {
+		return
+	}
+	return pmtuTooBig, mtu, true
+}
+
+// find the source address, and the name and MTU of the interface used to reach a target
+func outgoingInterface(af string, target netip.Addr) (source netip.Addr, ifname string, mtu int, err error) {
+	conn, err := NewTransport(af, 0).Dial("udp", net.JoinHostPort(target.String(), "33434"))
+	if err != nil {
+		return
+	}
+	source = conn.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap()
+	conn.Close()
+
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return
+	}
+	for _, iface := range ifaces {
+		addrs, _ := iface.Addrs()
+		for _, addr := range addrs {
+			if prefix, err := netip.ParsePrefix(addr.String()); err == nil && prefix.Addr().Unmap() == source {
+				return source, iface.Name, iface.MTU, nil
+			}
+		}
+	}
+	return source, "", 0, fmt.Errorf("cannot find the interface with address %s", source)
+}
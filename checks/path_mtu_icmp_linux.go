@@ -0,0 +1,23 @@
+package checks
+
+import (
+	"strings"
+	"syscall"
+)
+
+// set the don't fragment bit (IPv4) and prevent local fragmentation (both address families)
+// packets larger than the known path MTU are then refused with EMSGSIZE
+func dontFragmentControl(network string, address string, c syscall.RawConn) error {
+	var sockErr error
+	err := c.Control(func(fd uintptr) {
+		if strings.HasPrefix(network, "ip6") {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IPV6, syscall.IPV6_MTU_DISCOVER, syscall.IPV6_PMTUDISC_DO)
+		} else {
+			sockErr = syscall.SetsockoptInt(int(fd), syscall.IPPROTO_IP, syscall.IP_MTU_DISCOVER, syscall.IP_PMTUDISC_DO)
+		}
+	})
+	if err != nil {
+		return err
+	}
+	return sockErr
+}
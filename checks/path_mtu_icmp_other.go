@@ -0,0 +1,12 @@
+//go:build !linux
+
+package checks
+
+import (
+	"syscall"
+)
+
+// setting the don't fragment bit is only implemented on Linux
+func dontFragmentControl(network string, address string, c syscall.RawConn) error {
+	return errUnsupportedPlatform
+}
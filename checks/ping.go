@@ -57,6 +57,12 @@ func Ping(
 		)
 	}
 
+	// ping from the configured interface and source address, if any
+	pinger.InterfaceName = util.GetInterface()
+	if source := util.GetSourceAddress(); source.IsValid() {
+		pinger.Source = source.String()
+	}
+
 	pinger.Count = util.GetPingCount()
 	pinger.Timeout = time.Duration(util.GetPingCount()) * time.Second
 	pinger.Run()
@@ -1,7 +1,272 @@
 package checks
 
-// CheckPopularServices ...
-func CheckPopularServices() {
+import (
+	"crypto/tls"
+	"fmt"
+	"net/http"
+	"net/netip"
+	"slices"
+	"strings"
+	"time"
 
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// PopularServicesCheck checks if popular services (Google, Facebook, ...) are reachable over HTTPS,
+// and compares their DNS based and IP based reachability to find DNS censorship
+type PopularServicesCheck struct {
+	netiscopeCheckBase
+	localResolvers []string
+	openResolver   string // the reference, if any
+}
+
+// how a service was reached over one address
+type popularServiceResult struct {
+	stage string // where it failed: "DNS", "TIMEOUT", "CONNECT", "TLS", "CERTIFICATE", "HTTP" or "OTHER"; empty if it worked
+	err   error
+}
+
+func (check *PopularServicesCheck) configure() {
+	rc, err := parseResolvConf(util.GetResolvConfPath())
+	if err != nil {
+		check.log(LogLevelError, "NO_RESOLV_CONF", "Could not load DNS resolver data from resolv.conf")
+	} else {
+		check.localResolvers = filterResolversByAF(rc.resolversV4, rc.resolversV6)
+	}
+	for _, provider := range util.GetOpenResolverList() {
+		_, v4list, v6list, err := parseOpenResolverProvider(provider)
+		if open := filterResolversByAF(v4list, v6list); err == nil && len(open) > 0 {
+			check.openResolver = open[0]
+			break
+		}
+	}
+}
+
+// Start executes the popular services check
+func (check *PopularServicesCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	for _, service := range util.GetPopularServices() {
+		for _, af := range []string{"4", "6"} {
+			if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) || check.stopping {
+				continue
+			}
+			check.checkService(af, service)
+		}
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// check a service over an address family: first using the addresses from the local resolvers,
+// then, if that fails, using the ones from an open resolver
+func (check *PopularServicesCheck) checkService(af string, service string) {
+	local, reference := check.resolveService(af, service)
+
+	var localResult popularServiceResult
+	if len(local) > 0 {
+		localResult = check.testAddress(af, service, local[0])
+		if localResult.stage == "" {
+			check.log(
+				LogLevelInfo,
+				"POPULAR_SERVICE_IPV"+af+"_OK",
+				fmt.Sprintf("%s (%s) is reachable over IPv%s", service, local[0], af),
+			)
+			return
+		}
+	} else {
+		localResult.stage = "DNS"
+	}
+
+	// is it reachable via an address the local resolvers didn't give?
+	var other []string
+	for _, addr := range reference {
+		if !slices.Contains(local, addr) {
+			other = append(other, addr)
+		}
+	}
+	if len(other) == 0 {
+		check.log(
+			LogLevelError,
+			"POPULAR_SERVICE_IPV"+af+"_UNREACHABLE",
+			fmt.Sprintf("%s is not reachable over IPv%s (failed at stage: %s)", service, af, localResult.stage),
+		)
+		return
+	}
+	referenceResult := check.testAddress(af, service, other[0])
+	if referenceResult.stage == "" {
+		check.log(
+			LogLevelError,
+			"POPULAR_SERVICE_IPV"+af+"_DNS_CENSORED",
+			fmt.Sprintf(
+				"%s is reachable over IPv%s via %s from an open resolver, but not via the answers of the local resolvers (failed at stage: %s): this looks like DNS based blocking",
+				service, af, other[0], localResult.stage,
+			),
+		)
+		return
+	}
+	check.log(
+		LogLevelError,
+		"POPULAR_SERVICE_IPV"+af+"_UNREACHABLE",
+		fmt.Sprintf(
+			"%s is not reachable over IPv%s, not even via the answers of an open resolver (failed at stage: %s, and %s with %s)",
+			service, af, localResult.stage, referenceResult.stage, other[0],
+		),
+	)
+}
+
+// resolve a service via the local resolvers, and via an open resolver for reference
+// @return the usable local addresses (without sinkholes) and the reference ones
+func (check *PopularServicesCheck) resolveService(af string, service string) (local []string, reference []string) {
+	qtype := "A"
+	if af == "6" {
+		qtype = "AAAA"
+	}
+
+	for _, resolver := range check.localResolvers {
+		answers, err := DNSQuery(&check.netiscopeCheckBase, service, qtype, resolver, false, true, false, false)
+		if err != nil {
+			check.log(
+				LogLevelWarning,
+				"POPULAR_SERVICE_DNS_ERROR",
+				fmt.Sprintf("Resolving %s %s via local resolver %s failed: %v", service, qtype, resolver, err),
+			)
+			continue
+		}
+		for _, addr := range answers[qtype] {
+			if isSinkholeAddress(addr) {
+				check.log(
+					LogLevelWarning,
+					"POPULAR_SERVICE_DNS_SINKHOLED",
+					fmt.Sprintf("Local resolver %s returns %s for %s, which looks like a sinkhole", resolver, addr, service),
+				)
+				continue
+			}
+			if !slices.Contains(local, addr) {
+				local = append(local, addr)
+			}
+		}
+	}
+
+	if check.openResolver != "" {
+		answers, err := DNSQuery(&check.netiscopeCheckBase, service, qtype, check.openResolver, false, true, false, false)
+		if err == nil {
+			reference = answers[qtype]
+		}
+	}
+
+	// different answers are common with CDNs, so this is only a detail unless the service is unreachable
+	if len(local) > 0 && len(reference) > 0 && !slices.ContainsFunc(local, func(addr string) bool { return slices.Contains(reference, addr) }) {
+		check.log(
+			LogLevelDetail,
+			"POPULAR_SERVICE_DNS_MISMATCH",
+			fmt.Sprintf("Local resolvers return %v for %s %s, open resolver %s returns %v", local, service, qtype, check.openResolver, reference),
+		)
+	}
 	return
 }
+
+// connect to a service at an address with TLS, verifying the certificate chain and the host name,
+// then make a HEAD (or GET if that's not supported) request and check that the response makes sense
+func (check *PopularServicesCheck) testAddress(af string, service string, addr string) (result popularServiceResult) {
+	timeout := time.Duration(util.GetPopularServicesTimeout()) * time.Millisecond
+	// always connect to this address, whatever the name resolves to
+	transport := NewTransport(af, timeout).WithDestination(netip.MustParseAddr(addr))
+	defer transport.CloseIdleConnections()
+	client := transport.HTTPClient()
+	client.CheckRedirect = func(*http.Request, []*http.Request) error {
+		return http.ErrUseLastResponse
+	}
+
+	var resp *http.Response
+	for _, method := range []string{"HEAD", "GET"} {
+		req, err := http.NewRequest(method, "https://"+service+"/", nil)
+		if err != nil {
+			result.stage, result.err = "OTHER", err
+			break
+		}
+		resp, result.err = client.Do(req)
+		if result.err != nil || (resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented) {
+			break
+		}
+		resp.Body.Close()
+	}
+	if result.err != nil {
+		result.stage = ClassifyConnectionError(result.err)
+		if isCertificateError(result.err) {
+			result.stage = "CERTIFICATE"
+		}
+		check.log(
+			LogLevelWarning,
+			"POPULAR_SERVICE_IPV"+af+"_"+result.stage+"_ERROR",
+			fmt.Sprintf("Connecting to %s (%s) over IPv%s failed: %v", service, addr, af, result.err),
+		)
+		return
+	}
+	defer resp.Body.Close()
+
+	if state := resp.TLS; state != nil && len(state.PeerCertificates) > 0 {
+		cert := state.PeerCertificates[0]
+		check.log(
+			LogLevelDetail,
+			"POPULAR_SERVICE_IPV"+af+"_CERTIFICATE",
+			fmt.Sprintf(
+				"%s (%s) presented a valid certificate for %s issued by %s, valid until %s (%s)",
+				service, transport.Connection(), cert.Subject.CommonName, cert.Issuer.CommonName,
+				cert.NotAfter.Format(time.DateOnly), tls.VersionName(state.Version),
+			),
+		)
+	}
+
+	if problem := popularServiceResponseProblem(resp); problem != "" {
+		result.stage = "HTTP"
+		result.err = fmt.Errorf("%s", problem)
+		check.log(
+			LogLevelWarning,
+			"POPULAR_SERVICE_IPV"+af+"_HTTP_ERROR",
+			fmt.Sprintf("%s (%s) over IPv%s: %s", service, transport.Connection(), af, problem),
+		)
+		return
+	}
+	check.log(
+		LogLevelDetail,
+		"POPULAR_SERVICE_IPV"+af+"_HTTP_OK",
+		fmt.Sprintf("%s (%s) over IPv%s answered %s %s", service, transport.Connection(), af, resp.Request.Method, resp.Status),
+	)
+
+	// a wrong local clock breaks certificate validation sooner or later
+	if date, err := http.ParseTime(resp.Header.Get("Date")); err == nil {
+		skew := time.Since(date).Round(time.Second)
+		if skew.Abs() > time.Duration(util.GetPopularServicesMaxClockSkew())*time.Second {
+			check.log(
+				LogLevelWarning,
+				"POPULAR_SERVICE_CLOCK_SKEW",
+				fmt.Sprintf("The local clock is %v off compared to the Date of %s", skew, service),
+			)
+		}
+	}
+	return
+}
+
+// sanity check of a response: the status code and the headers that should be there
+// @return what's wrong with it, or an empty string if nothing
+func popularServiceResponseProblem(resp *http.Response) string {
+	switch {
+	case resp.StatusCode == http.StatusUnavailableForLegalReasons:
+		return "the service is blocked (" + resp.Status + ")"
+	case resp.StatusCode >= 400:
+		return "unexpected status " + resp.Status
+	case resp.StatusCode >= 300 && resp.Header.Get("Location") == "":
+		return "redirect (" + resp.Status + ") without a location"
+	case resp.StatusCode < 200:
+		return "unexpected status " + resp.Status
+	case resp.Header.Get("Date") == "":
+		return "no Date header in the response"
+	case resp.Request.Method == "GET" && resp.StatusCode == http.StatusOK && resp.Header.Get("Content-Type") == "":
+		return "no Content-Type header in the response"
+	}
+	if location := resp.Header.Get("Location"); location != "" && strings.HasPrefix(location, "http://") {
+		return "redirect to plain HTTP (" + location + ")"
+	}
+	return ""
+}
@@ -31,10 +31,10 @@ func (check *PortFilteringCheck) start() {
 				)
 
 				// try to connect
-				conn, err := net.DialTimeout(
-					strings.ToLower(target[2])+af, // {ud,tcp}{4,6}
-					target[0]+":"+target[1],       // host:port
-					time.Duration(util.GetPortFilteringTimeout())*time.Millisecond,
+				transport := NewTransport(af, time.Duration(util.GetPortFilteringTimeout())*time.Millisecond)
+				conn, err := transport.Dial(
+					strings.ToLower(target[2]),             // udp or tcp
+					net.JoinHostPort(target[0], target[1]), // host:port
 				)
 				if err != nil {
 					check.log(
@@ -49,10 +49,10 @@ func (check *PortFilteringCheck) start() {
 				check.log(
 					LogLevelInfo,
 					"PORT_FILTER_IPV"+af+"_CONN_OK",
-					fmt.Sprintf("Connection to %s:%s (%v) was successful on IPv"+af+" %s",
+					fmt.Sprintf("Connection to %s:%s (%s) was successful on IPv"+af+" %s",
 						target[0],
 						target[1],
-						conn.RemoteAddr().String(),
+						transport.Connection(),
 						target[2],
 					),
 				)
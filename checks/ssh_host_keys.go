@@ -2,15 +2,20 @@ package checks
 
 import (
 	"encoding/base64"
+	"errors"
 	"fmt"
 	"net"
 	"github.com/robert-kisteleki/netiscope/util"
 	"slices"
 	"strings"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
+// how long to wait for the connection and the key exchange
+const sshTimeout = 10 * time.Second
+
 type SSHHostKeysCheck struct {
 	netiscopeCheckBase
 	targets        []string
@@ -48,41 +53,74 @@ func (check *SSHHostKeysCheck) start() {
 	check.netiscopeCheckBase.start()
 
 	for _, target := range check.targets {
-		if check.stopping {
-			return
+		host := strings.Split(target, ",")[0]
+		for _, af := range []string{"4", "6"} {
+			if check.stopping {
+				return
+			}
+			if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) {
+				continue
+			}
+			check.checkHost(host, af)
 		}
+	}
 
-		host := strings.Split(target, ",")[0]
-		check.currentTarget = host
-		check.matckedKey = ""
+	check.netiscopeCheckBase.finish()
+}
 
-		check.log(LogLevelDetail, "SSH_KEY_HOST_TO_CHECK", "SSH host key check for host "+host)
+// check the host key of one host over one address family
+func (check *SSHHostKeysCheck) checkHost(host string, af string) {
+	check.currentTarget = host
+	check.matckedKey = ""
 
-		sshConfig := &ssh.ClientConfig{
-			HostKeyCallback: check.hostKeyCheckCallback,
-			User:            "netiscope",
-		}
-		_, err := ssh.Dial("tcp", host, sshConfig)
-		switch {
-		case check.matckedKey == "":
-			check.log(LogLevelError, "SSH_KEY_CHECK_FAIL",
-				fmt.Sprintf("SSH host key mismatch for %s: got %s (%s). Error is %v.",
-					host,
-					check.offeredKey,
-					check.OfferedKeyHash,
-					err,
-				),
-			)
-		case check.matckedKey != "":
-			check.log(
-				LogLevelInfo,
-				"SSH_KEY_CHECK_SUCCESS",
-				fmt.Sprintf("SSH host key match for %s: %s (%s)", host, check.matckedKey, check.OfferedKeyHash),
-			)
-		}
+	check.log(LogLevelDetail, "SSH_KEY_HOST_TO_CHECK", fmt.Sprintf("SSH host key check for host %s over IPv%s", host, af))
+
+	transport := NewTransport(af, sshTimeout)
+	conn, err := transport.Dial("tcp", host)
+	var addrErr *net.AddrError
+	if errors.As(err, &addrErr) {
+		// the host has no address in this address family
+		check.log(LogLevelDetail, "SSH_KEY_NO_ADDRESS", fmt.Sprintf("SSH host key check for %s over IPv%s skipped: %v", host, af, err))
+		return
 	}
+	if err != nil {
+		check.log(
+			LogLevelError,
+			"SSH_KEY_CONNECT_ERROR",
+			fmt.Sprintf("Cannot connect to %s over IPv%s: %v", host, af, err),
+		)
+		return
+	}
+	defer conn.Close()
 
-	check.netiscopeCheckBase.finish()
+	sshConfig := &ssh.ClientConfig{
+		HostKeyCallback: check.hostKeyCheckCallback,
+		User:            "netiscope",
+		Timeout:         sshTimeout,
+	}
+	conn.SetDeadline(time.Now().Add(sshTimeout))
+	sshConn, _, _, err := ssh.NewClientConn(conn, host, sshConfig)
+	if err == nil {
+		sshConn.Close()
+	}
+	switch {
+	case check.matckedKey == "":
+		check.log(LogLevelError, "SSH_KEY_CHECK_FAIL",
+			fmt.Sprintf("SSH host key mismatch for %s (%s): got %s (%s). Error is %v.",
+				host,
+				transport.Connection(),
+				check.offeredKey,
+				check.OfferedKeyHash,
+				err,
+			),
+		)
+	case check.matckedKey != "":
+		check.log(
+			LogLevelInfo,
+			"SSH_KEY_CHECK_SUCCESS",
+			fmt.Sprintf("SSH host key match for %s (%s): %s (%s)", host, transport.Connection(), check.matckedKey, check.OfferedKeyHash),
+		)
+	}
 }
 
 func (check *SSHHostKeysCheck) hostKeyCheckCallback(hostname string, remote net.Addr, key ssh.PublicKey) error {
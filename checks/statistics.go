@@ -0,0 +1,73 @@
+package checks
+
+import (
+	"fmt"
+	"math"
+	"slices"
+	"time"
+)
+
+// LatencyStats summarises a series of RTT measurements
+type LatencyStats struct {
+	Sent     int
+	Received int
+	Min      time.Duration
+	Median   time.Duration
+	P95      time.Duration
+	Max      time.Duration
+	Jitter   time.Duration // mean difference between consecutive samples
+}
+
+// ComputeLatencyStats calculates the statistics of a set of RTT samples
+// samples: the RTTs of the answered probes
+// sent: how many probes were sent in total (unanswered ones count as lost)
+func ComputeLatencyStats(samples []time.Duration, sent int) (stats LatencyStats) {
+	stats.Sent = sent
+	stats.Received = len(samples)
+	if len(samples) == 0 {
+		return
+	}
+
+	// jitter is calculated in the order the samples were taken
+	if len(samples) > 1 {
+		var total time.Duration
+		for i := 1; i < len(samples); i++ {
+			total += (samples[i] - samples[i-1]).Abs()
+		}
+		stats.Jitter = total / time.Duration(len(samples)-1)
+	}
+
+	sorted := slices.Clone(samples)
+	slices.Sort(sorted)
+	stats.Min = sorted[0]
+	stats.Max = sorted[len(sorted)-1]
+	stats.Median = percentile(sorted, 50)
+	stats.P95 = percentile(sorted, 95)
+	return
+}
+
+// LossRate returns the percentage of probes that were not answered
+func (stats LatencyStats) LossRate() float64 {
+	if stats.Sent == 0 {
+		return 0
+	}
+	return 100.0 * float64(stats.Sent-stats.Received) / float64(stats.Sent)
+}
+
+// String returns the statistics in a ping-like human readable form
+func (stats LatencyStats) String() string {
+	return fmt.Sprintf(
+		"%d/%d answered (%.0f%% lost), min/median/p95/max = %v/%v/%v/%v",
+		stats.Received, stats.Sent, stats.LossRate(),
+		stats.Min, stats.Median, stats.P95, stats.Max,
+	)
+}
+
+// return the p-th percentile of sorted samples using the nearest-rank method
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	rank := int(math.Ceil(p / 100.0 * float64(len(sorted))))
+	if rank < 1 {
+		rank = 1
+	}
+	return sorted[rank-1]
+}
@@ -0,0 +1,310 @@
+package checks
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"slices"
+	"time"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// STUNCheck learns the public addresses and the NAT behaviour using STUN (RFC 5389, RFC 5780)
+type STUNCheck struct {
+	netiscopeCheckBase
+}
+
+// Start executes the STUN check
+func (check *STUNCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	servers := util.GetSTUNServers()
+	if len(servers) == 0 {
+		check.log(LogLevelFatal, "STUN_NO_SERVERS", "There are no STUN servers defined")
+		return
+	}
+
+	for _, af := range []string{"4", "6"} {
+		if (af == "4" && util.SkipIPv4()) || (af == "6" && util.SkipIPv6()) {
+			continue
+		}
+		if check.stopping {
+			break
+		}
+		check.checkAddressFamily(af, servers)
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// run the STUN tests on one address family
+func (check *STUNCheck) checkAddressFamily(af string, servers []string) {
+	// the same local socket is used for all tests, this is what tells the mapping behaviour
+	socket, err := NewTransport(af, 0).ListenPacket("udp", nil)
+	if err != nil {
+		check.log(LogLevelError, "STUN_IPV"+af+"_SOCKET_ERROR", fmt.Sprintf("Cannot open a UDP socket: %v", err))
+		return
+	}
+	conn := socket.(*net.UDPConn)
+	defer conn.Close()
+
+	var mapped []netip.AddrPort
+	resolved := false
+	for _, server := range servers {
+		if check.stopping {
+			return
+		}
+		serverAddr, err := net.ResolveUDPAddr("udp"+af, server)
+		if err != nil {
+			check.log(
+				LogLevelWarning,
+				"STUN_IPV"+af+"_RESOLVE_ERROR",
+				fmt.Sprintf("Cannot resolve STUN server %s over IPv%s: %v", server, af, err),
+			)
+			continue
+		}
+		resolved = true
+
+		response, err := check.binding(conn, serverAddr.AddrPort(), false, false)
+		if err != nil || !response.MappedAddress.IsValid() {
+			check.log(
+				LogLevelWarning,
+				"STUN_IPV"+af+"_NO_RESPONSE",
+				fmt.Sprintf("No usable response from STUN server %s (%s): %v", server, serverAddr, err),
+			)
+			continue
+		}
+		check.log(
+			LogLevelInfo,
+			"STUN_IPV"+af+"_MAPPED_ADDRESS",
+			fmt.Sprintf("STUN server %s (%s) sees us as %s", server, serverAddr, response.MappedAddress),
+		)
+		mapped = append(mapped, response.MappedAddress)
+
+		// the NAT behaviour tests need a server that supports RFC 5780
+		if response.OtherAddress.IsValid() {
+			check.discoverNATBehaviour(af, conn, server, serverAddr.AddrPort(), response)
+		} else {
+			check.log(
+				LogLevelDetail,
+				"STUN_IPV"+af+"_NO_OTHER_ADDRESS",
+				fmt.Sprintf("STUN server %s does not support NAT behaviour discovery (RFC 5780)", server),
+			)
+		}
+	}
+
+	if !resolved {
+		// already reported: no STUN server for this address family
+		return
+	}
+	if len(mapped) == 0 {
+		check.log(LogLevelError, "STUN_IPV"+af+"_NO_MAPPED_ADDRESS", fmt.Sprintf("Could not learn the public IPv%s address", af))
+		return
+	}
+	check.compareWithLocalAddresses(af, mapped)
+}
+
+// stunBinder sends a binding request to a server and returns the matching response
+type stunBinder func(server netip.AddrPort, changeIP bool, changePort bool) (*util.STUNMessage, error)
+
+// send a binding request and wait for the matching response, with the configured timeout and retries
+func (check *STUNCheck) binding(
+	conn *net.UDPConn,
+	server netip.AddrPort,
+	changeIP bool,
+	changePort bool,
+) (*util.STUNMessage, error) {
+	timeout := time.Duration(util.GetSTUNTimeout()) * time.Millisecond
+	return stunBinding(conn, server, changeIP, changePort, timeout, util.GetSTUNRetries())
+}
+
+// send a binding request and wait for the matching response, with retries
+func stunBinding(
+	conn *net.UDPConn,
+	server netip.AddrPort,
+	changeIP bool,
+	changePort bool,
+	timeout time.Duration,
+	retries int,
+) (*util.STUNMessage, error) {
+	request := util.NewSTUNBindingRequest(changeIP, changePort)
+	wire := request.Encode()
+	buffer := make([]byte, 1500)
+
+	for attempt := 0; attempt <= retries; attempt++ {
+		if _, err := conn.WriteToUDPAddrPort(wire, server); err != nil {
+			return nil, err
+		}
+		deadline := time.Now().Add(timeout)
+		conn.SetReadDeadline(deadline)
+		for {
+			n, _, err := conn.ReadFromUDPAddrPort(buffer)
+			if err != nil {
+				break
+			}
+			response, err := util.ParseSTUNMessage(buffer[:n])
+			if err != nil || response.TransactionID != request.TransactionID {
+				// something else, or a late answer to an earlier request
+				continue
+			}
+			if response.Type != util.STUNBindingResponse {
+				return nil, fmt.Errorf("STUN error response (code %d)", response.ErrorCode)
+			}
+			return response, nil
+		}
+	}
+	return nil, fmt.Errorf("timeout")
+}
+
+// determine the NAT mapping and filtering behaviour
+func (check *STUNCheck) discoverNATBehaviour(
+	af string,
+	conn *net.UDPConn,
+	server string,
+	primary netip.AddrPort,
+	first *util.STUNMessage,
+) {
+	binding := func(server netip.AddrPort, changeIP bool, changePort bool) (*util.STUNMessage, error) {
+		return check.binding(conn, server, changeIP, changePort)
+	}
+
+	mapping := stunMappingBehaviour(binding, primary, first)
+	level := LogLevelType(LogLevelInfo)
+	if mapping != "endpoint independent" && mapping != "unknown" {
+		// also known as symmetric NAT: peer-to-peer connections are hard
+		level = LogLevelWarning
+	}
+	check.log(
+		level,
+		"STUN_IPV"+af+"_NAT_MAPPING",
+		fmt.Sprintf("NAT mapping behaviour according to %s: %s", server, mapping),
+	)
+
+	check.log(
+		LogLevelInfo,
+		"STUN_IPV"+af+"_NAT_FILTERING",
+		fmt.Sprintf("NAT filtering behaviour according to %s: %s", server, stunFilteringBehaviour(binding, primary)),
+	)
+}
+
+// tell if the mapped address changes with the destination address or port (RFC 5780 section 4.3)
+// first is the response of the primary address to a plain binding request
+func stunMappingBehaviour(binding stunBinder, primary netip.AddrPort, first *util.STUNMessage) string {
+	other := first.OtherAddress
+	r2, err := binding(netip.AddrPortFrom(other.Addr(), primary.Port()), false, false)
+	if err != nil {
+		return "unknown"
+	}
+	if r2.MappedAddress == first.MappedAddress {
+		return "endpoint independent"
+	}
+	r3, err := binding(other, false, false)
+	switch {
+	case err != nil:
+		return "unknown"
+	case r3.MappedAddress == r2.MappedAddress:
+		return "address dependent"
+	default:
+		return "address and port dependent"
+	}
+}
+
+// tell if responses from a different address or port are let in (RFC 5780 section 4.4)
+func stunFilteringBehaviour(binding stunBinder, primary netip.AddrPort) string {
+	if _, err := binding(primary, true, true); err == nil {
+		return "endpoint independent"
+	}
+	if _, err := binding(primary, false, true); err == nil {
+		return "address dependent"
+	}
+	return "address and port dependent"
+}
+
+// compare the public address(es) with the local ones to tell if there's NAT, and what kind
+func (check *STUNCheck) compareWithLocalAddresses(af string, mapped []netip.AddrPort) {
+	var public []netip.Addr
+	for _, addrPort := range mapped {
+		if !slices.Contains(public, addrPort.Addr().Unmap()) {
+			public = append(public, addrPort.Addr().Unmap())
+		}
+	}
+	if len(public) > 1 {
+		check.log(
+			LogLevelWarning,
+			"STUN_IPV"+af+"_MULTIPLE_PUBLIC_ADDRESSES",
+			fmt.Sprintf("Different STUN servers see different public addresses %v: a NAT pool (like CGNAT) is in use", public),
+		)
+	}
+
+	// different servers seeing different ports for the same socket means endpoint dependent mapping
+	var ports []uint16
+	for _, addrPort := range mapped {
+		if !slices.Contains(ports, addrPort.Port()) {
+			ports = append(ports, addrPort.Port())
+		}
+	}
+	if len(ports) > 1 {
+		check.log(
+			LogLevelWarning,
+			"STUN_IPV"+af+"_PORT_CHANGES",
+			fmt.Sprintf("Different STUN servers see different ports %v for the same socket: the NAT mapping is endpoint dependent", ports),
+		)
+	}
+
+	// the addresses on the local interfaces
+	var local []netip.Addr
+	if addrs, err := net.InterfaceAddrs(); err == nil {
+		for _, addr := range addrs {
+			if prefix, err := netip.ParsePrefix(addr.String()); err == nil {
+				local = append(local, prefix.Addr().Unmap())
+			}
+		}
+	}
+
+	for _, addr := range public {
+		if slices.Contains(local, addr) {
+			check.log(
+				LogLevelInfo,
+				"STUN_IPV"+af+"_NO_NAT",
+				fmt.Sprintf("The public address %s is on a local interface: there is no NAT", addr),
+			)
+			continue
+		}
+
+		class := util.ClassifyAddr(addr)
+		switch {
+		case !class.IsGlobal():
+			check.log(
+				LogLevelWarning,
+				"STUN_IPV"+af+"_NON_GLOBAL_PUBLIC_ADDRESS",
+				fmt.Sprintf("The STUN server sees us as %s (%s): it's probably inside the same NAT", addr, class),
+			)
+		case af == "6":
+			check.log(
+				LogLevelWarning,
+				"STUN_IPV6_NAT",
+				fmt.Sprintf("The public IPv6 address %s is not on a local interface: IPv6 NAT or NPTv6 is in use", addr),
+			)
+		default:
+			check.log(
+				LogLevelInfo,
+				"STUN_IPV4_NAT",
+				fmt.Sprintf("The public IPv4 address %s is not on a local interface: NAT is in use", addr),
+			)
+		}
+	}
+
+	// the class of the local addresses tells more about the NAT setup
+	if af == "4" {
+		for _, addr := range local {
+			if util.ClassifyAddr(addr) == util.IPClassCGNAT {
+				check.log(
+					LogLevelWarning,
+					"STUN_IPV4_CGNAT",
+					fmt.Sprintf("Local address %s is in the carrier-grade NAT range: the ISP does NAT", addr),
+				)
+			}
+		}
+	}
+}
@@ -0,0 +1,179 @@
+package checks
+
+import (
+	"errors"
+	"net"
+	"net/netip"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// build the responders in the server directory
+func buildServer(t *testing.T) string {
+	t.Helper()
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("the go tool is needed to build the server")
+	}
+	binary := filepath.Join(t.TempDir(), "server")
+	if output, err := exec.Command("go", "build", "-o", binary, "../server").CombinedOutput(); err != nil {
+		t.Fatalf("cannot build the server: %v\n%s", err, output)
+	}
+	return binary
+}
+
+// start a server process, and stop it at the end of the test
+func startServer(t *testing.T, args ...string) {
+	t.Helper()
+	args = append(args, "-log", filepath.Join(t.TempDir(), "server.log"))
+	cmd := exec.Command(buildServer(t), args...)
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		cmd.Process.Kill()
+		cmd.Wait()
+	})
+}
+
+// find a UDP port that is free together with the next one on all the addresses
+func freeUDPPortPair(t *testing.T, addrs ...netip.Addr) int {
+	t.Helper()
+	for range 20 {
+		conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(addrs[0], 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		port := conn.LocalAddr().(*net.UDPAddr).Port
+		conn.Close()
+
+		var conns []*net.UDPConn
+		for _, addr := range addrs {
+			for _, p := range []int{port, port + 1} {
+				if conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p)))); err == nil {
+					conns = append(conns, conn)
+				}
+			}
+		}
+		for _, conn := range conns {
+			conn.Close()
+		}
+		if len(conns) == 2*len(addrs) && port < 65535 {
+			return port
+		}
+	}
+	t.Fatal("cannot find free ports")
+	return 0
+}
+
+func TestSTUNNATBehaviour(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("the responder needs two loopback addresses, 127.0.0.2 only exists on Linux")
+	}
+	primaryAddr := netip.MustParseAddr("127.0.0.1")
+	alternateAddr := netip.MustParseAddr("127.0.0.2")
+	port := freeUDPPortPair(t, primaryAddr, alternateAddr)
+	startServer(t, "-proto", "STUN", "-port", strconv.Itoa(port), "-stun-addr", primaryAddr.String(), "-stun-alt-addr", alternateAddr.String())
+	primary := netip.AddrPortFrom(primaryAddr, uint16(port))
+
+	listen := func() *net.UDPConn {
+		conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { conn.Close() })
+		return conn
+	}
+	timeout := 200 * time.Millisecond
+
+	// wait for the responder to start
+	conn := listen()
+	var first *util.STUNMessage
+	for range 25 {
+		var err error
+		if first, err = stunBinding(conn, primary, false, false, timeout, 0); err == nil {
+			break
+		}
+	}
+	if first == nil {
+		t.Fatal("the STUN responder does not answer")
+	}
+	if want := netip.AddrPortFrom(alternateAddr, uint16(port+1)); first.OtherAddress != want {
+		t.Fatalf("other address = %s, want %s", first.OtherAddress, want)
+	}
+	if first.MappedAddress != conn.LocalAddr().(*net.UDPAddr).AddrPort() {
+		t.Fatalf("mapped address = %s, want %s", first.MappedAddress, conn.LocalAddr())
+	}
+
+	// there is no NAT on loopback, NATs are simulated by using new sockets for new destinations
+	// (mapping), or by dropping the responses from unexpected addresses and ports (filtering)
+	direct := func(conn *net.UDPConn) stunBinder {
+		return func(server netip.AddrPort, changeIP bool, changePort bool) (*util.STUNMessage, error) {
+			return stunBinding(conn, server, changeIP, changePort, timeout, 0)
+		}
+	}
+	socketPer := func(key func(netip.AddrPort) netip.AddrPort) stunBinder {
+		sockets := map[netip.AddrPort]*net.UDPConn{key(primary): conn}
+		return func(server netip.AddrPort, changeIP bool, changePort bool) (*util.STUNMessage, error) {
+			socket, ok := sockets[key(server)]
+			if !ok {
+				socket = listen()
+				sockets[key(server)] = socket
+			}
+			return stunBinding(socket, server, changeIP, changePort, timeout, 0)
+		}
+	}
+	filter := func(allowed func(origin netip.AddrPort, server netip.AddrPort) bool) stunBinder {
+		return func(server netip.AddrPort, changeIP bool, changePort bool) (*util.STUNMessage, error) {
+			response, err := stunBinding(conn, server, changeIP, changePort, timeout, 0)
+			if err == nil && !allowed(response.ResponseOrigin, server) {
+				return nil, errors.New("filtered")
+			}
+			return response, err
+		}
+	}
+
+	tests := []struct {
+		name      string
+		binding   stunBinder
+		mapping   string
+		filtering string
+	}{
+		{"no NAT", direct(conn), "endpoint independent", "endpoint independent"},
+		{
+			"address dependent mapping",
+			socketPer(func(server netip.AddrPort) netip.AddrPort { return netip.AddrPortFrom(server.Addr(), 0) }),
+			"address dependent", "endpoint independent",
+		},
+		{
+			"address and port dependent mapping",
+			socketPer(func(server netip.AddrPort) netip.AddrPort { return server }),
+			"address and port dependent", "endpoint independent",
+		},
+		{
+			"address dependent filtering",
+			filter(func(origin netip.AddrPort, server netip.AddrPort) bool { return origin.Addr() == server.Addr() }),
+			"endpoint independent", "address dependent",
+		},
+		{
+			"address and port dependent filtering",
+			filter(func(origin netip.AddrPort, server netip.AddrPort) bool { return origin == server }),
+			"endpoint independent", "address and port dependent",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if mapping := stunMappingBehaviour(test.binding, primary, first); mapping != test.mapping {
+				t.Errorf("mapping = %s, want %s", mapping, test.mapping)
+			}
+			if filtering := stunFilteringBehaviour(test.binding, primary); filtering != test.filtering {
+				t.Errorf("filtering = %s, want %s", filtering, test.filtering)
+			}
+		})
+	}
+}
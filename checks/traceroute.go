@@ -0,0 +1,353 @@
+package checks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/netip"
+	"os"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// TracerouteCheck traces the path to resolvers, root servers and configured targets
+type TracerouteCheck struct {
+	netiscopeCheckBase
+}
+
+// tracerouteTarget is a destination to trace, with a description of what it is
+type tracerouteTarget struct {
+	description string
+	addr        netip.Addr
+}
+
+// tracerouteHop collects the responses for one TTL over all rounds
+type tracerouteHop struct {
+	ttl        int
+	addrs      []netip.Addr // more than one in case of load balancing
+	rtts       []time.Duration
+	sent       int
+	final      bool   // the destination responded
+	annotation string // unreachable code, if any
+}
+
+// tracerouteAnalysis is the summary of a traceroute
+type tracerouteAnalysis struct {
+	reached    bool
+	hops       int        // to the destination, or to the last responding hop
+	lastAddr   netip.Addr // the last responding hop
+	silentHops []int      // hops that did not respond at all
+	loop       []netip.Addr
+	annotation string // unreachable code from the last hop, if any
+}
+
+// Start executes the traceroute check
+func (check *TracerouteCheck) start() {
+	check.netiscopeCheckBase.start()
+
+	targets := check.collectTargets()
+	if len(targets) == 0 {
+		check.log(LogLevelWarning, "TRACEROUTE_NO_TARGETS", "There are no targets to trace")
+		check.netiscopeCheckBase.finish()
+		return
+	}
+
+	// methods (per address family) that cannot work here, e.g. because of the lack of raw sockets
+	unavailable := make(map[string]bool)
+
+	for _, method := range util.GetTracerouteMethods() {
+		for _, target := range targets {
+			if check.stopping {
+				break
+			}
+			af := "4"
+			if target.addr.Is6() {
+				af = "6"
+			}
+			if unavailable[method+af] {
+				continue
+			}
+
+			if err := check.trace(af, method, target); err != nil {
+				if errors.Is(err, os.ErrPermission) {
+					check.log(
+						LogLevelWarning,
+						"TRACEROUTE_NO_RAW_SOCKET",
+						fmt.Sprintf("Traceroute (%s) over IPv%s needs raw sockets (run as root or with CAP_NET_RAW): %v", method, af, err),
+					)
+					unavailable[method+af] = true
+				} else {
+					check.log(
+						LogLevelWarning,
+						"TRACEROUTE_ERROR",
+						fmt.Sprintf("Traceroute (%s) to %s (%s) failed: %v", method, target.description, target.addr, err),
+					)
+				}
+			}
+		}
+	}
+
+	check.netiscopeCheckBase.finish()
+}
+
+// collect the addresses to trace, leaving out duplicates and the disabled address families
+func (check *TracerouteCheck) collectTargets() (targets []tracerouteTarget) {
+	add := func(description string, address string) {
+		addr, err := netip.ParseAddr(address)
+		if err != nil {
+			return
+		}
+		addr = addr.Unmap()
+		if (addr.Is4() && util.SkipIPv4()) || (addr.Is6() && util.SkipIPv6()) {
+			return
+		}
+		if slices.ContainsFunc(targets, func(t tracerouteTarget) bool { return t.addr == addr }) {
+			return
+		}
+		targets = append(targets, tracerouteTarget{description, addr})
+	}
+
+	if util.GetTracerouteLocalResolvers() {
+		if rc, err := parseResolvConf(util.GetResolvConfPath()); err == nil {
+			for _, resolver := range append(rc.resolversV4, rc.resolversV6...) {
+				add("local resolver", resolver)
+			}
+		}
+	}
+
+	if util.GetTracerouteOpenResolvers() {
+		for _, provider := range util.GetOpenResolverList() {
+			name, v4list, v6list, err := parseOpenResolverProvider(provider)
+			if err != nil {
+				continue
+			}
+			for _, resolver := range append(v4list, v6list...) {
+				add("open resolver "+name, resolver)
+			}
+		}
+	}
+
+	if letters := util.GetTracerouteRootServers(); len(letters) > 0 {
+		for _, root := range loadRootDNSServers(&check.netiscopeCheckBase) {
+			if slices.Contains(letters, strings.ToUpper(root.Letter)) {
+				add("root server "+root.Letter, root.IPv4)
+				add("root server "+root.Letter, root.IPv6)
+			}
+		}
+	}
+
+	for _, host := range util.GetTracerouteTargets() {
+		for _, addr := range lookupTargetAddresses(host) {
+			add(host, addr.String())
+		}
+	}
+	return
+}
+
+// return the address of a target given by name or address, one per address family
+func lookupTargetAddresses(host string) (addrs []netip.Addr) {
+	if addr, err := netip.ParseAddr(host); err == nil {
+		return []netip.Addr{addr.Unmap()}
+	}
+	for _, network := range []string{"ip4", "ip6"} {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		found, err := NewTransport("", 0).Resolver().LookupNetIP(ctx, network, host)
+		cancel()
+		if err != nil || len(found) == 0 {
+			continue
+		}
+		addrs = append(addrs, found[0].Unmap())
+	}
+	return
+}
+
+// trace the path to a target with a given method, and continue with MTR rounds if configured
+func (check *TracerouteCheck) trace(af string, method string, target tracerouteTarget) error {
+	prober, err := newTracerouteProber(
+		af,
+		method,
+		target.addr,
+		util.GetTraceroutePort(method),
+		util.GetTracerouteMaxHops(),
+		time.Duration(util.GetTracerouteTimeout())*time.Millisecond,
+	)
+	if err != nil {
+		return err
+	}
+	defer prober.close()
+
+	check.log(
+		LogLevelInfo,
+		"TRACEROUTE_TARGET",
+		fmt.Sprintf("Traceroute (%s) to %s (%s) from %s", method, target.description, target.addr, prober.source),
+	)
+
+	hops := newTracerouteHops(prober.maxHops)
+	rounds := util.GetTracerouteQueries()
+	for round := 0; round < rounds; round++ {
+		if check.stopping {
+			return nil
+		}
+		replies, err := prober.probeRound(round)
+		if err != nil {
+			return err
+		}
+		addTracerouteRound(hops, replies)
+	}
+
+	name := fmt.Sprintf("Traceroute (%s) to %s (%s)", method, target.description, target.addr)
+	analysis := check.report(name, hops)
+	if util.GetTracerouteMTRRounds() > 0 && analysis.hops > 0 {
+		return check.mtr(prober, name, analysis.hops, rounds)
+	}
+	return nil
+}
+
+// report the hops and the analysis of a traceroute
+func (check *TracerouteCheck) report(name string, hops []tracerouteHop) tracerouteAnalysis {
+	hops, analysis := analyseTraceroute(hops)
+	for _, hop := range hops {
+		check.log(LogLevelInfo, "TRACEROUTE_HOP", formatTracerouteHop(hop))
+	}
+
+	switch {
+	case analysis.reached:
+		check.log(LogLevelInfo, "TRACEROUTE_REACHED", fmt.Sprintf("%s reached the destination in %d hops", name, analysis.hops))
+	case analysis.hops == 0:
+		check.log(LogLevelWarning, "TRACEROUTE_INCOMPLETE", fmt.Sprintf("%s got no responses at all", name))
+	case analysis.annotation != "":
+		check.log(
+			LogLevelWarning,
+			"TRACEROUTE_UNREACHABLE",
+			fmt.Sprintf("%s stops at hop %d (%s) which reports the destination unreachable (%s)", name, analysis.hops, analysis.lastAddr, analysis.annotation),
+		)
+	default:
+		check.log(
+			LogLevelWarning,
+			"TRACEROUTE_INCOMPLETE",
+			fmt.Sprintf("%s did not reach the destination, the path stops after hop %d (%s)", name, analysis.hops, analysis.lastAddr),
+		)
+	}
+
+	if len(analysis.loop) > 0 {
+		check.log(LogLevelWarning, "TRACEROUTE_LOOP", fmt.Sprintf("%s shows a routing loop involving %v", name, analysis.loop))
+	}
+	if len(analysis.silentHops) > 0 {
+		check.log(
+			LogLevelDetail,
+			"TRACEROUTE_SILENT_HOPS",
+			fmt.Sprintf("%s: hops %v did not respond (they probably don't send ICMP errors)", name, analysis.silentHops),
+		)
+	}
+	return analysis
+}
+
+// prepare the hop list for a traceroute
+func newTracerouteHops(maxHops int) []tracerouteHop {
+	hops := make([]tracerouteHop, maxHops)
+	for i := range hops {
+		hops[i].ttl = i + 1
+	}
+	return hops
+}
+
+// add the responses of one round (by TTL) to the hops
+func addTracerouteRound(hops []tracerouteHop, replies map[int]tracerouteReply) {
+	for i := range hops {
+		hop := &hops[i]
+		hop.sent++
+		reply, ok := replies[hop.ttl]
+		if !ok {
+			continue
+		}
+		hop.rtts = append(hop.rtts, reply.rtt)
+		if !slices.Contains(hop.addrs, reply.from) {
+			hop.addrs = append(hop.addrs, reply.from)
+		}
+		hop.final = hop.final || reply.final
+		if reply.annotation != "" {
+			hop.annotation = reply.annotation
+		}
+	}
+}
+
+// analyse the hops of a traceroute
+// returns the hops up to the destination or the last responding hop, and the summary
+func analyseTraceroute(hops []tracerouteHop) ([]tracerouteHop, tracerouteAnalysis) {
+	var analysis tracerouteAnalysis
+
+	// the path ends at the first hop where the destination responded,
+	// or at the last hop that responded at all
+	end := 0
+	for i, hop := range hops {
+		if len(hop.addrs) > 0 {
+			end = i + 1
+		}
+		if hop.final {
+			analysis.reached = true
+			break
+		}
+	}
+	hops = hops[:end]
+	analysis.hops = end
+	if end == 0 {
+		return hops, analysis
+	}
+
+	last := hops[end-1]
+	analysis.lastAddr = last.addrs[len(last.addrs)-1]
+	if !last.final {
+		analysis.annotation = last.annotation
+	}
+
+	// a routing loop shows up as the same address at two hops with a different responding address in between
+	// silent hops don't count, a router that doesn't always answer is not a loop
+	seen := make(map[netip.Addr]int)
+	for i, hop := range hops {
+		if len(hop.addrs) == 0 {
+			analysis.silentHops = append(analysis.silentHops, hop.ttl)
+			continue
+		}
+		for _, addr := range hop.addrs {
+			if previous, ok := seen[addr]; ok && !slices.Contains(analysis.loop, addr) && otherHopAddress(hops[previous+1:i], addr) {
+				analysis.loop = append(analysis.loop, addr)
+			}
+			seen[addr] = i
+		}
+	}
+	return hops, analysis
+}
+
+// tell if any of the hops responded from an address other than addr
+func otherHopAddress(hops []tracerouteHop, addr netip.Addr) bool {
+	for _, hop := range hops {
+		for _, other := range hop.addrs {
+			if other != addr {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// format a hop like traceroute does: TTL, addresses, RTTs, * for missing responses
+func formatTracerouteHop(hop tracerouteHop) string {
+	var parts []string
+	parts = append(parts, fmt.Sprintf("%2d", hop.ttl))
+	for _, addr := range hop.addrs {
+		parts = append(parts, addr.String())
+	}
+	for _, rtt := range hop.rtts {
+		parts = append(parts, fmt.Sprintf("%.3f ms", float64(rtt.Microseconds())/1000))
+	}
+	for range hop.sent - len(hop.rtts) {
+		parts = append(parts, "*")
+	}
+	if hop.annotation != "" {
+		parts = append(parts, hop.annotation)
+	}
+	return strings.Join(parts, "  ")
+}
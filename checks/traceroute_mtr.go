@@ -0,0 +1,174 @@
+package checks
+
+import (
+	"fmt"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// mtrHop is the statistics of one hop over all MTR rounds
+type mtrHop struct {
+	ttl         int
+	addrs       []netip.Addr
+	stats       LatencyStats
+	rateLimited bool // loss that does not persist to later hops
+}
+
+// probe the hops of a path for a number of rounds, like MTR does
+// hopCount: how many hops to probe (up to the destination or the last responding hop)
+// firstRound: the first round number not used by the traceroute before
+func (check *TracerouteCheck) mtr(prober *tracerouteProber, name string, hopCount int, firstRound int) error {
+	// rounds are numbered on from the traceroute, so that late responses to it can be told apart
+	used := firstRound * prober.maxHops
+	prober.maxHops = hopCount
+	start := (used + hopCount - 1) / hopCount
+
+	hops := newTracerouteHops(hopCount)
+	for round := start; round < start+util.GetTracerouteMTRRounds(); round++ {
+		if check.stopping {
+			return nil
+		}
+		replies, err := prober.probeRound(round)
+		if err != nil {
+			return err
+		}
+		addTracerouteRound(hops, replies)
+	}
+
+	rows, lossFrom := analyseMTR(hops)
+	check.reportMTR(name, rows, lossFrom)
+	return nil
+}
+
+// report the MTR statistics as a table and as findings
+func (check *TracerouteCheck) reportMTR(name string, rows []mtrHop, lossFrom int) {
+	header := []string{"HOP", "ADDRESS", "LOSS", "SENT", "MIN", "MEDIAN", "P95", "MAX", "JITTER", "NOTE"}
+	var table [][]string
+	for _, row := range rows {
+		addrs := row.address()
+		if len(row.addrs) > 1 {
+			addrs += fmt.Sprintf(" (+%d)", len(row.addrs)-1)
+		}
+		note := ""
+		switch {
+		case row.stats.Received == 0:
+			note = "no response"
+		case row.rateLimited:
+			note = "rate limited"
+		}
+		table = append(table, []string{
+			fmt.Sprint(row.ttl),
+			addrs,
+			fmt.Sprintf("%.0f%%", row.stats.LossRate()),
+			fmt.Sprint(row.stats.Sent),
+			mtrMilliseconds(row.stats.Received, row.stats.Min),
+			mtrMilliseconds(row.stats.Received, row.stats.Median),
+			mtrMilliseconds(row.stats.Received, row.stats.P95),
+			mtrMilliseconds(row.stats.Received, row.stats.Max),
+			mtrMilliseconds(row.stats.Received, row.stats.Jitter),
+			note,
+		})
+	}
+	for _, line := range formatTable(header, table) {
+		check.log(LogLevelInfo, "MTR_TABLE", line)
+	}
+
+	var rateLimited []string
+	for _, row := range rows {
+		if row.rateLimited {
+			rateLimited = append(rateLimited, fmt.Sprintf("%d (%s, %.0f%%)", row.ttl, row.address(), row.stats.LossRate()))
+		}
+	}
+	if len(rateLimited) > 0 {
+		check.log(
+			LogLevelInfo,
+			"MTR_RATE_LIMITED",
+			fmt.Sprintf("%s: loss at hops %s does not persist to later hops, it's ICMP rate limiting rather than real loss", name, strings.Join(rateLimited, ", ")),
+		)
+	}
+
+	last := rows[len(rows)-1]
+	if lossFrom == 0 {
+		check.log(
+			LogLevelInfo,
+			"MTR_NO_LOSS",
+			fmt.Sprintf("%s: no loss up to hop %d over %d rounds", name, last.ttl, last.stats.Sent),
+		)
+		return
+	}
+	from := rows[lossFrom-1]
+	check.log(
+		LogLevelWarning,
+		"MTR_PATH_LOSS",
+		fmt.Sprintf(
+			"%s: %.0f%% loss up to hop %d, starting at hop %d (%s)",
+			name, last.stats.LossRate(), last.ttl, from.ttl, from.address(),
+		),
+	)
+}
+
+// the (first) address of a hop, or * if it did not respond
+func (row mtrHop) address() string {
+	if len(row.addrs) == 0 {
+		return "*"
+	}
+	return row.addrs[0].String()
+}
+
+// calculate the statistics of each hop and tell where the loss really starts
+// returns the statistics per hop, and the TTL from which the loss persists to the last hop (0 if there's no loss)
+func analyseMTR(hops []tracerouteHop) (rows []mtrHop, lossFrom int) {
+	for _, hop := range hops {
+		rows = append(rows, mtrHop{
+			ttl:   hop.ttl,
+			addrs: hop.addrs,
+			stats: ComputeLatencyStats(hop.rtts, hop.sent),
+		})
+	}
+	if len(rows) == 0 {
+		return
+	}
+
+	// loss at a hop that is not seen at a later hop is not loss of forwarded packets,
+	// just the router not answering every probe (ICMP rate limiting)
+	for i := range rows {
+		loss := rows[i].stats.LossRate()
+		if loss == 0 || rows[i].stats.Received == 0 {
+			continue
+		}
+		for _, later := range rows[i+1:] {
+			if later.stats.Received > 0 && later.stats.LossRate() < loss {
+				rows[i].rateLimited = true
+				break
+			}
+		}
+	}
+
+	// real loss starts where all responding hops from there on show loss
+	if rows[len(rows)-1].stats.LossRate() == 0 {
+		return
+	}
+	lossFrom = rows[len(rows)-1].ttl
+	for i := len(rows) - 2; i >= 0; i-- {
+		if rows[i].stats.Received == 0 {
+			// silent hops don't tell anything
+			continue
+		}
+		if rows[i].stats.LossRate() == 0 {
+			break
+		}
+		lossFrom = rows[i].ttl
+	}
+	return
+}
+
+// format a duration in milliseconds for the MTR table, if there were samples at all
+func mtrMilliseconds(received int, duration time.Duration) string {
+	if received == 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%.1f", float64(duration.Microseconds())/1000)
+}
@@ -0,0 +1,464 @@
+package checks
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/netip"
+	"sync"
+	"time"
+
+	"golang.org/x/net/icmp"
+	"golang.org/x/net/ipv4"
+	"golang.org/x/net/ipv6"
+)
+
+// the time between two probes of a round
+const tracerouteProbeGap = 5 * time.Millisecond
+
+// tracerouteReply is what a response tells about a probe
+type tracerouteReply struct {
+	from       netip.Addr    // the responding hop
+	probe      int           // the index of the probe this is a response to
+	final      bool          // the response came from (or on behalf of) the destination
+	annotation string        // unreachable code, like !H, !N, !X
+	received   time.Time     // when the response arrived
+	rtt        time.Duration // filled in by the prober
+}
+
+// tracerouteProber sends probes with increasing TTLs to a target and collects the responses
+// ICMP responses are read from a raw ICMP socket, this needs root or CAP_NET_RAW
+type tracerouteProber struct {
+	af      string // "4" or "6"
+	method  string // "icmp", "udp" or "tcp"
+	target  netip.Addr
+	source  netip.Addr
+	port    int // destination port (UDP: the base port)
+	maxHops int
+	timeout time.Duration
+
+	id       uint16 // ICMP ID, UDP or TCP source port
+	tcpSeq   uint32 // TCP: the sequence number of probe 0
+	icmpConn net.PacketConn
+	udpConn  *net.UDPConn
+	tcpConn  *net.IPConn
+}
+
+// open the sockets needed for probing
+func newTracerouteProber(af string, method string, target netip.Addr, port int, maxHops int, timeout time.Duration) (*tracerouteProber, error) {
+	prober := &tracerouteProber{
+		af:      af,
+		method:  method,
+		target:  target,
+		port:    port,
+		maxHops: maxHops,
+		timeout: timeout,
+		tcpSeq:  rand.Uint32(),
+	}
+
+	// the source address is needed for the TCP checksum, and is nice to know anyway
+	transport := NewTransport(af, 0)
+	probe, err := transport.Dial("udp", net.JoinHostPort(target.String(), "33434"))
+	if err != nil {
+		return nil, err
+	}
+	prober.source = probe.LocalAddr().(*net.UDPAddr).AddrPort().Addr().Unmap()
+	probe.Close()
+
+	if af == "4" {
+		prober.icmpConn, err = transport.ListenPacket("ip4:icmp", nil)
+	} else {
+		prober.icmpConn, err = transport.ListenPacket("ip6:ipv6-icmp", nil)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	switch method {
+	case "icmp":
+		prober.id = uint16(rand.Intn(0xffff))
+	case "udp":
+		var conn net.PacketConn
+		conn, err = transport.WithSource(prober.source).ListenPacket("udp", nil)
+		if err == nil {
+			prober.udpConn = conn.(*net.UDPConn)
+			prober.id = prober.udpConn.LocalAddr().(*net.UDPAddr).AddrPort().Port()
+		}
+	case "tcp":
+		var conn net.PacketConn
+		conn, err = transport.WithSource(prober.source).ListenPacket("ip"+af+":tcp", nil)
+		if err == nil {
+			prober.tcpConn = conn.(*net.IPConn)
+		}
+		prober.id = uint16(32768 + rand.Intn(28000))
+	default:
+		err = fmt.Errorf("unknown traceroute method %s", method)
+	}
+	if err != nil {
+		prober.close()
+		return nil, err
+	}
+	return prober, nil
+}
+
+// release the sockets
+func (prober *tracerouteProber) close() {
+	if prober.icmpConn != nil {
+		prober.icmpConn.Close()
+	}
+	if prober.udpConn != nil {
+		prober.udpConn.Close()
+	}
+	if prober.tcpConn != nil {
+		prober.tcpConn.Close()
+	}
+}
+
+// send one probe for each TTL and collect the responses
+// round: which round this is, so that late responses from earlier rounds can be told apart
+// returns the responses by TTL
+func (prober *tracerouteProber) probeRound(round int) (map[int]tracerouteReply, error) {
+	replies := make(chan tracerouteReply, 2*prober.maxHops)
+	deadline := time.Now().Add(time.Duration(prober.maxHops)*tracerouteProbeGap + prober.timeout)
+
+	// the responses can come from the ICMP socket and from the TCP socket
+	var readers sync.WaitGroup
+	prober.icmpConn.SetReadDeadline(deadline)
+	readers.Go(func() { prober.readICMP(replies) })
+	if prober.method == "tcp" {
+		prober.tcpConn.SetReadDeadline(deadline)
+		readers.Go(func() { prober.readTCP(replies) })
+	}
+	go func() {
+		readers.Wait()
+		close(replies)
+	}()
+
+	sent := make(map[int]time.Time)
+	var sendErr error
+	for ttl := 1; ttl <= prober.maxHops; ttl++ {
+		probe := round*prober.maxHops + ttl - 1
+		sent[probe] = time.Now()
+		if sendErr = prober.send(ttl, probe); sendErr != nil {
+			// no point in waiting for the rest
+			prober.stopReading()
+			break
+		}
+		time.Sleep(tracerouteProbeGap)
+	}
+
+	byTTL := make(map[int]tracerouteReply)
+	for reply := range replies {
+		sentAt, ok := sent[reply.probe]
+		if !ok {
+			// a late response from an earlier round
+			continue
+		}
+		ttl := reply.probe - round*prober.maxHops + 1
+		if _, seen := byTTL[ttl]; seen {
+			continue
+		}
+		reply.rtt = reply.received.Sub(sentAt)
+		byTTL[ttl] = reply
+
+		// no need to wait if all probes are answered
+		if len(byTTL) == prober.maxHops {
+			prober.stopReading()
+		}
+	}
+	return byTTL, sendErr
+}
+
+// make the readers return now
+func (prober *tracerouteProber) stopReading() {
+	prober.icmpConn.SetReadDeadline(time.Now())
+	if prober.tcpConn != nil {
+		prober.tcpConn.SetReadDeadline(time.Now())
+	}
+}
+
+// send a probe with a given TTL
+func (prober *tracerouteProber) send(ttl int, probe int) error {
+	dst := prober.target.AsSlice()
+	switch prober.method {
+	case "icmp":
+		msg := icmp.Message{Body: &icmp.Echo{ID: int(prober.id), Seq: probe & 0xffff, Data: []byte("netiscope")}}
+		if prober.af == "4" {
+			msg.Type = ipv4.ICMPTypeEcho
+			ipv4.NewPacketConn(prober.icmpConn).SetTTL(ttl)
+		} else {
+			msg.Type = ipv6.ICMPTypeEchoRequest
+			ipv6.NewPacketConn(prober.icmpConn).SetHopLimit(ttl)
+		}
+		wire, err := msg.Marshal(nil)
+		if err != nil {
+			return err
+		}
+		_, err = prober.icmpConn.WriteTo(wire, &net.IPAddr{IP: dst})
+		return err
+
+	case "udp":
+		if prober.af == "4" {
+			ipv4.NewPacketConn(prober.udpConn).SetTTL(ttl)
+		} else {
+			ipv6.NewPacketConn(prober.udpConn).SetHopLimit(ttl)
+		}
+		_, err := prober.udpConn.WriteToUDP([]byte("netiscope"), &net.UDPAddr{IP: dst, Port: prober.port + probe})
+		return err
+
+	case "tcp":
+		if prober.af == "4" {
+			ipv4.NewPacketConn(prober.tcpConn).SetTTL(ttl)
+		} else {
+			ipv6.NewPacketConn(prober.tcpConn).SetHopLimit(ttl)
+		}
+		segment := buildTCPSyn(prober.source, prober.target, prober.id, uint16(prober.port), prober.tcpSeq+uint32(probe))
+		_, err := prober.tcpConn.WriteTo(segment, &net.IPAddr{IP: dst})
+		return err
+	}
+	return fmt.Errorf("unknown traceroute method %s", prober.method)
+}
+
+// read ICMP messages until the read deadline, pass on the ones that are responses to our probes
+func (prober *tracerouteProber) readICMP(replies chan<- tracerouteReply) {
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := prober.icmpConn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		fromAddr, ok := netip.AddrFromSlice(from.(*net.IPAddr).IP)
+		if !ok {
+			continue
+		}
+		reply, ok := parseTracerouteICMP(prober.af, prober.method, prober.id, prober.port, prober.tcpSeq, prober.target, fromAddr.Unmap(), buffer[:n])
+		if ok {
+			reply.received = time.Now()
+			replies <- reply
+		}
+	}
+}
+
+// read TCP segments until the read deadline, pass on the ones that are answers to our SYNs
+func (prober *tracerouteProber) readTCP(replies chan<- tracerouteReply) {
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := prober.tcpConn.ReadFrom(buffer)
+		if err != nil {
+			return
+		}
+		fromAddr, ok := netip.AddrFromSlice(from.(*net.IPAddr).IP)
+		if !ok || fromAddr.Unmap() != prober.target {
+			continue
+		}
+		reply, ok := parseTracerouteTCP(prober.id, uint16(prober.port), prober.tcpSeq, buffer[:n])
+		if ok {
+			reply.from = prober.target
+			reply.received = time.Now()
+			replies <- reply
+		}
+	}
+}
+
+// parse an ICMP message and tell if it is a response to one of our probes
+// af, method, id, port, tcpSeq: the parameters of the probes
+// target: the destination of the probes
+// from: the sender of the ICMP message
+// msg: the ICMP message (without the IP header)
+func parseTracerouteICMP(
+	af string,
+	method string,
+	id uint16,
+	port int,
+	tcpSeq uint32,
+	target netip.Addr,
+	from netip.Addr,
+	msg []byte,
+) (reply tracerouteReply, ok bool) {
+	proto := 1
+	if af == "6" {
+		proto = 58
+	}
+	parsed, err := icmp.ParseMessage(proto, msg)
+	if err != nil {
+		return
+	}
+	reply.from = from
+
+	var embedded []byte
+	switch parsed.Type {
+	case ipv4.ICMPTypeEchoReply, ipv6.ICMPTypeEchoReply:
+		echo, isEcho := parsed.Body.(*icmp.Echo)
+		if method != "icmp" || !isEcho || uint16(echo.ID) != id {
+			return
+		}
+		reply.probe = echo.Seq
+		reply.final = true
+		return reply, true
+	case ipv4.ICMPTypeTimeExceeded, ipv6.ICMPTypeTimeExceeded:
+		if body, isBody := parsed.Body.(*icmp.TimeExceeded); isBody {
+			embedded = body.Data
+		}
+	case ipv4.ICMPTypeDestinationUnreachable, ipv6.ICMPTypeDestinationUnreachable:
+		if body, isBody := parsed.Body.(*icmp.DstUnreach); isBody {
+			embedded = body.Data
+		}
+		reply.annotation = unreachableAnnotation(af, parsed.Code)
+	default:
+		return
+	}
+
+	// the embedded packet is the beginning of our probe
+	embeddedProto, dst, transport, valid := parseEmbeddedPacket(af, embedded)
+	if !valid || dst != target || len(transport) < 8 {
+		return
+	}
+	switch method {
+	case "icmp":
+		echoRequest := 8
+		if af == "6" {
+			echoRequest = 128
+		}
+		if embeddedProto != proto || int(transport[0]) != echoRequest || binary.BigEndian.Uint16(transport[4:6]) != id {
+			return
+		}
+		reply.probe = int(binary.BigEndian.Uint16(transport[6:8]))
+	case "udp":
+		if embeddedProto != 17 || binary.BigEndian.Uint16(transport[0:2]) != id {
+			return
+		}
+		reply.probe = int(binary.BigEndian.Uint16(transport[2:4])) - port
+	case "tcp":
+		if embeddedProto != 6 || binary.BigEndian.Uint16(transport[0:2]) != id {
+			return
+		}
+		reply.probe = int(int32(binary.BigEndian.Uint32(transport[4:8]) - tcpSeq))
+	default:
+		return
+	}
+	if reply.probe < 0 {
+		return
+	}
+
+	// port unreachable from the target means the UDP probe arrived
+	if from == target && reply.annotation != "" {
+		reply.final = true
+		if reply.annotation == "!P" {
+			reply.annotation = ""
+		}
+	}
+	return reply, true
+}
+
+// parse a TCP segment and tell if it answers one of our SYNs
+// SYN-ACK and RST both mean the destination was reached
+func parseTracerouteTCP(srcPort uint16, dstPort uint16, tcpSeq uint32, segment []byte) (reply tracerouteReply, ok bool) {
+	if len(segment) < 20 {
+		return
+	}
+	if binary.BigEndian.Uint16(segment[0:2]) != dstPort || binary.BigEndian.Uint16(segment[2:4]) != srcPort {
+		return
+	}
+	flags := segment[13]
+	const flagRST, flagACK = 0x04, 0x10
+	if flags&(flagRST|flagACK) == 0 {
+		return
+	}
+	ack := binary.BigEndian.Uint32(segment[8:12])
+	reply.probe = int(int32(ack - 1 - tcpSeq))
+	if reply.probe < 0 {
+		return
+	}
+	reply.final = true
+	return reply, true
+}
+
+// split the packet embedded in an ICMP error into protocol, destination and transport header
+// IPv6 extension headers are not followed
+func parseEmbeddedPacket(af string, data []byte) (proto int, dst netip.Addr, transport []byte, ok bool) {
+	if af == "4" {
+		if len(data) < 20 || data[0]>>4 != 4 {
+			return
+		}
+		headerLength := int(data[0]&0x0f) * 4
+		if len(data) < headerLength {
+			return
+		}
+		dst, _ = netip.AddrFromSlice(data[16:20])
+		return int(data[9]), dst, data[headerLength:], true
+	}
+	if len(data) < 40 || data[0]>>4 != 6 {
+		return
+	}
+	dst, _ = netip.AddrFromSlice(data[24:40])
+	return int(data[6]), dst, data[40:], true
+}
+
+// the traditional traceroute annotation of ICMP unreachable codes
+// "!P" is port unreachable, which is expected from the destination with UDP probes
+func unreachableAnnotation(af string, code int) string {
+	if af == "4" {
+		switch code {
+		case 0:
+			return "!N"
+		case 1:
+			return "!H"
+		case 2, 3:
+			return "!P"
+		case 9, 10, 13:
+			return "!X"
+		}
+		return fmt.Sprintf("!<%d>", code)
+	}
+	switch code {
+	case 0:
+		return "!N"
+	case 1, 5, 6:
+		return "!X"
+	case 3:
+		return "!H"
+	case 4:
+		return "!P"
+	}
+	return fmt.Sprintf("!<%d>", code)
+}
+
+// build a TCP SYN segment, including the checksum over the pseudo header
+func buildTCPSyn(src netip.Addr, dst netip.Addr, srcPort uint16, dstPort uint16, seq uint32) []byte {
+	segment := make([]byte, 20)
+	binary.BigEndian.PutUint16(segment[0:2], srcPort)
+	binary.BigEndian.PutUint16(segment[2:4], dstPort)
+	binary.BigEndian.PutUint32(segment[4:8], seq)
+	segment[12] = 5 << 4 // data offset: 5 words
+	segment[13] = 0x02   // SYN
+	binary.BigEndian.PutUint16(segment[14:16], 65535)
+
+	var pseudo []byte
+	pseudo = append(pseudo, src.AsSlice()...)
+	pseudo = append(pseudo, dst.AsSlice()...)
+	if src.Is4() {
+		pseudo = append(pseudo, 0, 6)
+		pseudo = binary.BigEndian.AppendUint16(pseudo, uint16(len(segment)))
+	} else {
+		pseudo = binary.BigEndian.AppendUint32(pseudo, uint32(len(segment)))
+		pseudo = append(pseudo, 0, 0, 0, 6)
+	}
+	binary.BigEndian.PutUint16(segment[16:18], internetChecksum(append(pseudo, segment...)))
+	return segment
+}
+
+// the one's complement checksum of RFC 1071
+func internetChecksum(data []byte) uint16 {
+	var sum uint32
+	for i := 0; i+1 < len(data); i += 2 {
+		sum += uint32(binary.BigEndian.Uint16(data[i : i+2]))
+	}
+	if len(data)%2 == 1 {
+		sum += uint32(data[len(data)-1]) << 8
+	}
+	for sum > 0xffff {
+		sum = (sum >> 16) + (sum & 0xffff)
+	}
+	return ^uint16(sum)
+}
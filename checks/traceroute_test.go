@@ -0,0 +1,264 @@
+package checks
+
+import (
+	"encoding/hex"
+	"net/netip"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// ICMP messages (without the IP header) and TCP segments captured from traceroutes to 10.9.2.2 and fd09:2::2,
+// via the router 10.9.1.2 / fd09:1::2
+const (
+	// ICMP echo probe, id 0x748e, seq 0: time exceeded
+	capturedICMPv4TimeExceeded = "0b00f4ff000000004500002556d0400001010bf40a0901010a090202080058ce748e00006e65746973636f7065"
+	// ICMP echo probe, id 0x748e, seq 1: echo reply
+	capturedICMPv4EchoReply = "000060cd748e00016e65746973636f7065"
+	// UDP probe from port 0xde25 to 33434: time exceeded
+	capturedUDPv4TimeExceeded = "0b0052540000000045000025bc2b40000111a6880a0901010a090202de25829a001117376e65746973636f7065"
+	// UDP probe from port 0xde25 to 33435: port unreachable
+	capturedUDPv4PortUnreachable = "03035a500000000045000025bc2c40000111a6870a0901010a090202de25829b001117376e65746973636f7065"
+	// TCP SYN from port 0x9aab, seq 0xd472e78d: time exceeded
+	capturedTCPv4TimeExceeded = "0b000c2f0000000045000028b0dc40000106b1df0a0901010a0902029aab01bbd472e78d000000005002ffff40670000"
+	// ICMPv6 echo probe, id 0xef6b, seq 0: time exceeded
+	capturedICMPv6TimeExceeded = "0300f36f000000006007752d00113a01fd090001000000000000000000000001fd09000200000000000000000000000280006b8bef6b00006e65746973636f7065"
+	// ICMPv6 echo probe, id 0xef6b, seq 1: echo reply
+	capturedICMPv6EchoReply = "81006a8aef6b00016e65746973636f7065"
+	// UDP probe from port 0xc07e to 33434: time exceeded
+	capturedUDPv6TimeExceeded = "030082b4000000006004ac7d00111101fd090001000000000000000000000001fd090002000000000000000000000002c07e829a0011fa3b6e65746973636f7065"
+	// UDP probe from port 0xc07e to 33435: port unreachable
+	capturedUDPv6PortUnreachable = "01045613000000006001db1b00111101fd090001000000000000000000000001fd090002000000000000000000000002c07e829b0011fa3b6e65746973636f7065"
+	// SYN-ACK to port 0xd98a from 443, for the SYN with seq 0xdaf1fe1b
+	capturedTCPv4SynAck = "01bbd98a92c03fafdaf1fe1c6012faf017330000020405b4"
+	// RST to port 0xe2d5 from 444, for the SYN with seq 0x5a948bbb
+	capturedTCPv4Reset = "01bce2d5000000005a948bbc50140000cdd90000"
+	// the SYN-ACK above with only the SYN flag
+	capturedTCPv4Syn = "01bbd98a92c03fafdaf1fe1c6002faf017330000020405b4"
+)
+
+func decodeHex(t *testing.T, s string) []byte {
+	t.Helper()
+	data, err := hex.DecodeString(s)
+	if err != nil {
+		t.Fatalf("invalid hex %q: %v", s, err)
+	}
+	return data
+}
+
+func TestParseTracerouteICMP(t *testing.T) {
+	target4 := netip.MustParseAddr("10.9.2.2")
+	router4 := netip.MustParseAddr("10.9.1.2")
+	target6 := netip.MustParseAddr("fd09:2::2")
+	router6 := netip.MustParseAddr("fd09:1::2")
+
+	tests := []struct {
+		name   string
+		af     string
+		method string
+		id     uint16
+		tcpSeq uint32
+		target netip.Addr
+		from   netip.Addr
+		msg    string
+		ok     bool
+		want   tracerouteReply
+	}{
+		{"IPv4 ICMP time exceeded", "4", "icmp", 0x748e, 0, target4, router4, capturedICMPv4TimeExceeded, true, tracerouteReply{from: router4, probe: 0}},
+		{"IPv4 ICMP echo reply", "4", "icmp", 0x748e, 0, target4, target4, capturedICMPv4EchoReply, true, tracerouteReply{from: target4, probe: 1, final: true}},
+		{"IPv4 echo reply to someone else", "4", "icmp", 0x1234, 0, target4, target4, capturedICMPv4EchoReply, false, tracerouteReply{}},
+		{"IPv4 ICMP time exceeded in a UDP traceroute", "4", "udp", 0x748e, 0, target4, router4, capturedICMPv4TimeExceeded, false, tracerouteReply{}},
+		{"IPv4 UDP time exceeded", "4", "udp", 0xde25, 0, target4, router4, capturedUDPv4TimeExceeded, true, tracerouteReply{from: router4, probe: 0}},
+		{"IPv4 UDP port unreachable", "4", "udp", 0xde25, 0, target4, target4, capturedUDPv4PortUnreachable, true, tracerouteReply{from: target4, probe: 1, final: true}},
+		{"IPv4 UDP port unreachable for another target", "4", "udp", 0xde25, 0, netip.MustParseAddr("10.9.2.3"), target4, capturedUDPv4PortUnreachable, false, tracerouteReply{}},
+		{"IPv4 TCP time exceeded", "4", "tcp", 0x9aab, 0xd472e78d, target4, router4, capturedTCPv4TimeExceeded, true, tracerouteReply{from: router4, probe: 0}},
+		{"IPv6 ICMP time exceeded", "6", "icmp", 0xef6b, 0, target6, router6, capturedICMPv6TimeExceeded, true, tracerouteReply{from: router6, probe: 0}},
+		{"IPv6 ICMP echo reply", "6", "icmp", 0xef6b, 0, target6, target6, capturedICMPv6EchoReply, true, tracerouteReply{from: target6, probe: 1, final: true}},
+		{"IPv6 UDP time exceeded", "6", "udp", 0xc07e, 0, target6, router6, capturedUDPv6TimeExceeded, true, tracerouteReply{from: router6, probe: 0}},
+		{"IPv6 UDP port unreachable", "6", "udp", 0xc07e, 0, target6, target6, capturedUDPv6PortUnreachable, true, tracerouteReply{from: target6, probe: 1, final: true}},
+		{"IPv6 UDP port unreachable from a router", "6", "udp", 0xc07e, 0, target6, router6, capturedUDPv6PortUnreachable, true, tracerouteReply{from: router6, probe: 1, annotation: "!P"}},
+		{"IPv6 message parsed as IPv4", "4", "icmp", 0xef6b, 0, target6, router6, capturedICMPv6TimeExceeded, false, tracerouteReply{}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply, ok := parseTracerouteICMP(test.af, test.method, test.id, 33434, test.tcpSeq, test.target, test.from, decodeHex(t, test.msg))
+			if ok != test.ok {
+				t.Fatalf("ok = %v, want %v", ok, test.ok)
+			}
+			if ok && reply != test.want {
+				t.Errorf("reply = %+v, want %+v", reply, test.want)
+			}
+		})
+	}
+}
+
+func TestParseTracerouteTCP(t *testing.T) {
+	tests := []struct {
+		name    string
+		srcPort uint16
+		dstPort uint16
+		tcpSeq  uint32
+		segment string
+		ok      bool
+		probe   int
+	}{
+		{"SYN-ACK", 0xd98a, 443, 0xdaf1fe1a, capturedTCPv4SynAck, true, 1},
+		{"RST", 0xe2d5, 444, 0x5a948bba, capturedTCPv4Reset, true, 1},
+		{"other source port", 0xd98b, 443, 0xdaf1fe1a, capturedTCPv4SynAck, false, 0},
+		{"other destination port", 0xd98a, 80, 0xdaf1fe1a, capturedTCPv4SynAck, false, 0},
+		{"acknowledges an earlier sequence number", 0xd98a, 443, 0xdaf1fe1c, capturedTCPv4SynAck, false, 0},
+		{"neither ACK nor RST", 0xd98a, 443, 0xdaf1fe1a, capturedTCPv4Syn, false, 0},
+		{"too short", 0xd98a, 443, 0xdaf1fe1a, capturedTCPv4SynAck[:30], false, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			reply, ok := parseTracerouteTCP(test.srcPort, test.dstPort, test.tcpSeq, decodeHex(t, test.segment))
+			if ok != test.ok {
+				t.Fatalf("ok = %v, want %v", ok, test.ok)
+			}
+			if ok && (reply.probe != test.probe || !reply.final) {
+				t.Errorf("reply = %+v, want probe %d and final", reply, test.probe)
+			}
+		})
+	}
+}
+
+func TestAnalyseTraceroute(t *testing.T) {
+	a := netip.MustParseAddr("192.0.2.1")
+	b := netip.MustParseAddr("192.0.2.2")
+	c := netip.MustParseAddr("192.0.2.3")
+	d := netip.MustParseAddr("198.51.100.1")
+
+	// the hops, with nil for a silent one; the last address is the destination if reached
+	path := func(reached bool, annotation string, addrs ...[]netip.Addr) []tracerouteHop {
+		hops := newTracerouteHops(len(addrs) + 2)
+		for i, hopAddrs := range addrs {
+			hops[i].addrs = hopAddrs
+			hops[i].sent = 3
+		}
+		if len(addrs) > 0 {
+			hops[len(addrs)-1].final = reached
+			hops[len(addrs)-1].annotation = annotation
+		}
+		return hops
+	}
+	hop := func(addrs ...netip.Addr) []netip.Addr { return addrs }
+
+	tests := []struct {
+		name string
+		hops []tracerouteHop
+		want tracerouteAnalysis
+	}{
+		{
+			"reached with a silent hop",
+			path(true, "", hop(a), nil, hop(b), hop(d)),
+			tracerouteAnalysis{reached: true, hops: 4, lastAddr: d, silentHops: []int{2}},
+		},
+		{
+			"same address on both sides of a silent hop",
+			path(true, "", hop(a), nil, hop(a), hop(d)),
+			tracerouteAnalysis{reached: true, hops: 4, lastAddr: d, silentHops: []int{2}},
+		},
+		{
+			"same address at adjacent hops",
+			path(true, "", hop(a), hop(a), hop(b), hop(d)),
+			tracerouteAnalysis{reached: true, hops: 4, lastAddr: d},
+		},
+		{
+			"loop between two routers",
+			path(false, "", hop(a), hop(b), hop(c), hop(b), hop(c), nil, hop(b)),
+			tracerouteAnalysis{hops: 7, lastAddr: b, silentHops: []int{6}, loop: []netip.Addr{b, c}},
+		},
+		{
+			"loop with silent hops in between",
+			path(false, "", hop(a), hop(b), nil, hop(c), nil, hop(b)),
+			tracerouteAnalysis{hops: 6, lastAddr: b, silentHops: []int{3, 5}, loop: []netip.Addr{b}},
+		},
+		{
+			"load balanced hop",
+			path(true, "", hop(a), hop(b, c), hop(b), hop(d)),
+			tracerouteAnalysis{reached: true, hops: 4, lastAddr: d},
+		},
+		{
+			"unreachable",
+			path(false, "!H", hop(a), hop(b)),
+			tracerouteAnalysis{hops: 2, lastAddr: b, annotation: "!H"},
+		},
+		{
+			"nothing responded",
+			path(false, "", nil, nil),
+			tracerouteAnalysis{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			hops, analysis := analyseTraceroute(test.hops)
+			if len(hops) != test.want.hops {
+				t.Errorf("%d hops returned, want %d", len(hops), test.want.hops)
+			}
+			if !reflect.DeepEqual(analysis, test.want) {
+				t.Errorf("analysis = %+v, want %+v", analysis, test.want)
+			}
+		})
+	}
+}
+
+func TestAnalyseMTR(t *testing.T) {
+	// the number of responses to 10 probes at each hop, -1 for a silent hop
+	hops := func(received ...int) []tracerouteHop {
+		hops := newTracerouteHops(len(received))
+		for i, count := range received {
+			hops[i].sent = 10
+			if count < 0 {
+				continue
+			}
+			hops[i].addrs = []netip.Addr{netip.AddrFrom4([4]byte{192, 0, 2, byte(i + 1)})}
+			for j := range count {
+				hops[i].rtts = append(hops[i].rtts, time.Duration(i+1)*time.Millisecond+time.Duration(j)*time.Microsecond)
+			}
+		}
+		return hops
+	}
+
+	tests := []struct {
+		name        string
+		hops        []tracerouteHop
+		rateLimited []int
+		lossFrom    int
+	}{
+		{"no loss", hops(10, 10, 10, 10), nil, 0},
+		{"loss confined to a middle hop", hops(10, 6, 10, 10), []int{2}, 0},
+		{"loss at several middle hops", hops(10, 6, 9, 10), []int{2, 3}, 0},
+		{"loss persisting to the end", hops(10, 8, 7, 7), nil, 2},
+		{"loss persisting to the end after rate limiting", hops(10, 5, 10, 8, 8), []int{2}, 4},
+		{"loss growing towards the end", hops(10, 9, 8, 7), nil, 2},
+		{"loss only at the last hop", hops(10, 10, 10, 7), nil, 4},
+		{"silent hop before the loss", hops(10, -1, 9, 9), nil, 3},
+		{"silent hop within the loss", hops(10, 8, -1, 8), nil, 2},
+		{"silent hops and no loss", hops(10, -1, -1, 10), nil, 0},
+		{"nothing", nil, nil, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			rows, lossFrom := analyseMTR(test.hops)
+			if len(rows) != len(test.hops) {
+				t.Fatalf("%d rows, want %d", len(rows), len(test.hops))
+			}
+			var rateLimited []int
+			for i, row := range rows {
+				if row.ttl != i+1 || row.stats.Sent != 10 || row.stats.Received != len(test.hops[i].rtts) {
+					t.Errorf("row %d: TTL %d, %d of %d received", i, row.ttl, row.stats.Received, row.stats.Sent)
+				}
+				if row.rateLimited {
+					rateLimited = append(rateLimited, row.ttl)
+				}
+			}
+			if !reflect.DeepEqual(rateLimited, test.rateLimited) {
+				t.Errorf("rate limited hops %v, want %v", rateLimited, test.rateLimited)
+			}
+			if lossFrom != test.lossFrom {
+				t.Errorf("loss from hop %d, want %d", lossFrom, test.lossFrom)
+			}
+		})
+	}
+}
@@ -0,0 +1,326 @@
+package checks
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+// Transport makes connections (TCP, UDP, TLS, HTTP and DNS) pinned to an address family,
+// and optionally from a particular source address or interface, so that a finding about IPv4 or IPv6
+// is really about that address family. It remembers the addresses of the last connection it made.
+type Transport struct {
+	af          string        // "4" or "6", or any if empty
+	source      netip.Addr    // the local address to use, if valid
+	iface       string        // the network interface to use, if any
+	destination netip.Addr    // the address to connect to instead of the one asked for, if valid
+	timeout     time.Duration // for connecting, or for the whole request with HTTP; none if 0
+
+	mutex  sync.Mutex
+	local  net.Addr
+	remote net.Addr
+	http   *http.Transport // shared by the HTTP clients made with this transport
+}
+
+// how long an idle HTTP connection is kept open at most, in case it's not closed explicitly
+const httpIdleTimeout = 30 * time.Second
+
+// NewTransport makes a transport for an address family ("4", "6" or "" for any)
+// the interface and the source address are the configured ones, if any
+// timeout: for connecting, or for the whole request with HTTP; none if 0
+func NewTransport(af string, timeout time.Duration) *Transport {
+	return &Transport{
+		af:      af,
+		source:  util.GetSourceAddress(),
+		iface:   util.GetInterface(),
+		timeout: timeout,
+	}
+}
+
+// WithSource makes the transport use a particular source address
+// an invalid address means the one chosen by the system
+func (t *Transport) WithSource(source netip.Addr) *Transport {
+	t.source = source.Unmap()
+	return t
+}
+
+// WithDestination makes the transport connect to a particular address, whatever host the connections
+// (and HTTP requests) are for; the port and the TLS server name stay the same
+func (t *Transport) WithDestination(destination netip.Addr) *Transport {
+	t.destination = destination.Unmap()
+	return t
+}
+
+// the network pinned to the address family, like "tcp" to "tcp6" or "tcp-tls" to "tcp6-tls"
+func (t *Transport) network(network string) string {
+	base, tlsSuffix, _ := strings.Cut(network, "-")
+	base = strings.TrimRight(base, "46")
+	if t.af != "" {
+		base += t.af
+	} else if t.source.Is4() {
+		// otherwise the source address decides the family
+		base += "4"
+	} else if t.source.Is6() {
+		base += "6"
+	}
+	if tlsSuffix != "" {
+		return base + "-" + tlsSuffix
+	}
+	return base
+}
+
+// the source address to use for a (pinned) network, if any
+func (t *Transport) sourceAddress(network string) netip.Addr {
+	if t.source.IsValid() || t.iface == "" || bindsToDevice {
+		return t.source
+	}
+	// without binding to the interface, use its address
+	network, _, _ = strings.Cut(network, ":")
+	switch {
+	case strings.HasSuffix(network, "4"):
+		return interfaceAddress(t.iface, "4")
+	case strings.HasSuffix(network, "6"):
+		return interfaceAddress(t.iface, "6")
+	}
+	return t.source
+}
+
+// a dialer for a (pinned) network, with the source address and the interface set if there are such
+func (t *Transport) dialer(network string) *net.Dialer {
+	dialer := &net.Dialer{Timeout: t.timeout}
+	if source := t.sourceAddress(strings.TrimSuffix(network, "-tls")); source.IsValid() {
+		addr := netip.AddrPortFrom(source, 0)
+		if strings.HasPrefix(network, "udp") {
+			dialer.LocalAddr = net.UDPAddrFromAddrPort(addr)
+		} else {
+			dialer.LocalAddr = net.TCPAddrFromAddrPort(addr)
+		}
+	}
+	if t.iface != "" {
+		dialer.Control = bindToDeviceControl(t.iface)
+	}
+	return dialer
+}
+
+// check that the source address is of the same address family
+func (t *Transport) validate() error {
+	if t.source.IsValid() && t.af != "" && (t.af == "4") != t.source.Is4() {
+		return fmt.Errorf("source address %s cannot be used over IPv%s", t.source, t.af)
+	}
+	return nil
+}
+
+// DialContext connects to an address, network is "tcp" or "udp"
+func (t *Transport) DialContext(ctx context.Context, network string, address string) (net.Conn, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	network = t.network(network)
+	if t.destination.IsValid() {
+		if _, port, err := net.SplitHostPort(address); err == nil {
+			address = net.JoinHostPort(t.destination.String(), port)
+		}
+	}
+	conn, err := t.dialer(network).DialContext(ctx, network, address)
+	if err != nil {
+		return nil, err
+	}
+	t.remember(conn)
+	return conn, nil
+}
+
+// Dial connects to an address, network is "tcp" or "udp"
+func (t *Transport) Dial(network string, address string) (net.Conn, error) {
+	return t.DialContext(context.Background(), network, address)
+}
+
+// DialTLS makes a TCP connection and a TLS handshake with it
+// the server name is taken from the address if the config doesn't have one
+func (t *Transport) DialTLS(address string, config *tls.Config) (*tls.Conn, error) {
+	ctx := context.Background()
+	if t.timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, t.timeout)
+		defer cancel()
+	}
+	conn, err := t.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	if config.ServerName == "" {
+		config = config.Clone()
+		config.ServerName, _, _ = net.SplitHostPort(address)
+	}
+	tlsConn := tls.Client(conn, config)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	return tlsConn, nil
+}
+
+// ListenPacket opens a socket to send and receive packets with, without connecting
+// network is "udp", or a raw one with the address family in it like "ip4:icmp" or "ip6:ipv6-icmp"
+// control: additional socket options to set, if not nil
+func (t *Transport) ListenPacket(network string, control func(string, string, syscall.RawConn) error) (net.PacketConn, error) {
+	if err := t.validate(); err != nil {
+		return nil, err
+	}
+	raw := strings.HasPrefix(network, "ip")
+	if !raw {
+		network = t.network(network)
+	}
+
+	address := ""
+	if source := t.sourceAddress(network); source.IsValid() {
+		address = source.String()
+	}
+	switch {
+	case !raw:
+		address = net.JoinHostPort(address, "0")
+	case address == "" && strings.HasPrefix(network, "ip6"):
+		address = "::"
+	case address == "":
+		address = "0.0.0.0"
+	}
+
+	config := net.ListenConfig{
+		Control: func(network string, address string, c syscall.RawConn) error {
+			if bind := bindToDeviceControl(t.iface); bind != nil && t.iface != "" {
+				if err := bind(network, address, c); err != nil {
+					return err
+				}
+			}
+			if control != nil {
+				return control(network, address, c)
+			}
+			return nil
+		},
+	}
+	return config.ListenPacket(context.Background(), network, address)
+}
+
+// HTTPClient makes an HTTP client using this transport, the clients share their idle connections
+// proxies are only used if nothing is pinned or overridden, as otherwise the proxy would make the connection
+// call CloseIdleConnections when done with the clients
+func (t *Transport) HTTPClient() *http.Client {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.http == nil {
+		t.http = &http.Transport{DialContext: t.DialContext, IdleConnTimeout: httpIdleTimeout}
+		if t.af == "" && !t.source.IsValid() && t.iface == "" && !t.destination.IsValid() {
+			t.http.Proxy = http.ProxyFromEnvironment
+		}
+	}
+	return &http.Client{Timeout: t.timeout, Transport: t.http}
+}
+
+// CloseIdleConnections closes the connections kept open by the HTTP clients of this transport
+func (t *Transport) CloseIdleConnections() {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.http != nil {
+		t.http.CloseIdleConnections()
+	}
+}
+
+// Resolver makes a resolver that looks names up like the system does (with the hosts file and the
+// resolvers of resolv.conf), but sends its queries using this transport
+// a resolver on loopback, like a local stub, is reached directly as it cannot be reached over an interface
+func (t *Transport) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network string, address string) (net.Conn, error) {
+			if server, err := netip.ParseAddrPort(address); err == nil && server.Addr().IsLoopback() {
+				return (&net.Dialer{Timeout: t.timeout}).DialContext(ctx, network, address)
+			}
+			return t.DialContext(ctx, network, address)
+		},
+	}
+}
+
+// DNSClient makes a DNS client using this transport, network is "udp", "tcp" or "tcp-tls"
+// use Exchange to make queries with it, so that the addresses are remembered
+func (t *Transport) DNSClient(network string) *dns.Client {
+	network = t.network(network)
+	dialer := t.dialer(network)
+	if dialer.Timeout == 0 {
+		// the default of the DNS package
+		dialer.Timeout = 2 * time.Second
+	}
+	client := &dns.Client{Net: network, Dialer: dialer}
+	if t.timeout > 0 {
+		client.Timeout = t.timeout
+	}
+	return client
+}
+
+// Exchange makes a DNS query with a client made by DNSClient
+func (t *Transport) Exchange(client *dns.Client, query *dns.Msg, server string) (*dns.Msg, time.Duration, error) {
+	if err := t.validate(); err != nil {
+		return nil, 0, err
+	}
+	conn, err := client.Dial(server)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	t.remember(conn)
+	return client.ExchangeWithConn(query, conn)
+}
+
+// remember the addresses of a connection
+func (t *Transport) remember(conn net.Conn) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	t.local = conn.LocalAddr()
+	t.remote = conn.RemoteAddr()
+}
+
+// Connection describes the last connection as "local -> remote", or returns an empty string if there was none
+func (t *Transport) Connection() string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if t.local == nil || t.remote == nil {
+		return ""
+	}
+	if t.iface != "" {
+		return fmt.Sprintf("%s -> %s via %s", t.local, t.remote, t.iface)
+	}
+	return fmt.Sprintf("%s -> %s", t.local, t.remote)
+}
+
+// the address of an interface in an address family ("4" or "6") to use as a source, global ones preferred
+func interfaceAddress(name string, af string) (found netip.Addr) {
+	iface, err := net.InterfaceByName(name)
+	if err != nil {
+		return
+	}
+	addrs, _ := iface.Addrs()
+	for _, addr := range addrs {
+		prefix, err := netip.ParsePrefix(addr.String())
+		if err != nil || (af == "4") != prefix.Addr().Is4() {
+			continue
+		}
+		switch util.ClassifyAddr(prefix.Addr()) {
+		case util.IPClassGlobal:
+			return prefix.Addr()
+		case util.IPClassLinkLocal, util.IPClassLoopback:
+		default:
+			if !found.IsValid() {
+				found = prefix.Addr()
+			}
+		}
+	}
+	return
+}
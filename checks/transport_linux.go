@@ -0,0 +1,22 @@
+package checks
+
+import (
+	"syscall"
+)
+
+// the interface is selected with SO_BINDTODEVICE, the system picks the source address
+const bindsToDevice = true
+
+// bind sockets to a network interface, so that it's used regardless of the routing table
+func bindToDeviceControl(iface string) func(network string, address string, c syscall.RawConn) error {
+	return func(network string, address string, c syscall.RawConn) error {
+		var sockErr error
+		err := c.Control(func(fd uintptr) {
+			sockErr = syscall.BindToDevice(int(fd), iface)
+		})
+		if err != nil {
+			return err
+		}
+		return sockErr
+	}
+}
@@ -0,0 +1,15 @@
+//go:build !linux
+
+package checks
+
+import (
+	"syscall"
+)
+
+// binding to an interface is only implemented on Linux, elsewhere the address of the interface is used
+const bindsToDevice = false
+
+// bind sockets to a network interface: nothing to do here
+func bindToDeviceControl(iface string) func(network string, address string, c syscall.RawConn) error {
+	return nil
+}
@@ -8,13 +8,13 @@ require (
 	github.com/miekg/dns v1.1.72
 	github.com/prometheus-community/pro-bing v0.8.0
 	golang.org/x/crypto v0.53.0
+	golang.org/x/net v0.55.0
 )
 
 require (
 	github.com/google/uuid v1.6.0 // indirect
 	github.com/stretchr/testify v1.8.4 // indirect
 	golang.org/x/mod v0.31.0 // indirect
-	golang.org/x/net v0.55.0 // indirect
 	golang.org/x/sync v0.19.0 // indirect
 	golang.org/x/sys v0.46.0 // indirect
 	golang.org/x/tools v0.40.0 // indirect
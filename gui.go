@@ -34,6 +34,7 @@ func runGui() {
 	http.Handle("/", http.FileServer(http.FS(serverRoot)))
 	http.HandleFunc("/api/version", guiControlGetVersion)
 	http.HandleFunc("/api/control/checks", guiControlListChecks)
+	http.HandleFunc("/api/control/interfaces", guiControlListInterfaces)
 	http.HandleFunc("/api/control/start", guiControlStart)
 	http.HandleFunc("/api/control/stop", guiControlStop)
 	http.Handle("/api/results/", resultsWsHandle{upgrader: websocket.Upgrader{}})
@@ -55,9 +56,12 @@ func guiControlStart(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("Content-Type", "application/json")
 
 	type RequestData struct {
-		Checks []string `json:"checks"`
-		IPv4   bool     `json:"ipv4"`
-		IPv6   bool     `json:"ipv6"`
+		Checks        []string `json:"checks"`
+		IPv4          bool     `json:"ipv4"`
+		IPv6          bool     `json:"ipv6"`
+		Interface     string   `json:"interface"`
+		Source        string   `json:"source"`
+		EachInterface bool     `json:"each_interface"`
 	}
 
 	if r.Method != http.MethodPost {
@@ -74,6 +78,15 @@ func guiControlStart(w http.ResponseWriter, r *http.Request) {
 
 	util.GuiIPv4 = data.IPv4
 	util.GuiIPv6 = data.IPv6
+	util.GuiInterface = data.Interface
+	util.GuiSource = data.Source
+	util.GuiEachIface = data.EachInterface
+	if err := util.CheckBinding(); err != nil {
+		fmt.Fprint(w, string(
+			makeGuiControlResponse(guiResponse{Code: "ERROR", Message: err.Error(), Params: nil})),
+		)
+		return
+	}
 	go startChecks(data.Checks, false)
 
 	fmt.Fprint(w, string(
@@ -118,6 +131,13 @@ func guiControlListChecks(w http.ResponseWriter, r *http.Request) {
 	fmt.Fprint(w, string(b))
 }
 
+func guiControlListInterfaces(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+
+	b := makeGuiControlResponse(guiResponse{Code: "OK", Message: "", Params: checks.UsableInterfaces()})
+	fmt.Fprint(w, string(b))
+}
+
 func makeGuiControlResponse(response guiResponse) []byte {
 	b, err := json.Marshal(response)
 	if err != nil {
@@ -11,6 +11,7 @@ import (
 	"fmt"
 	"github.com/robert-kisteleki/netiscope/checks"
 	"github.com/robert-kisteleki/netiscope/util"
+	"os"
 	"runtime"
 )
 
@@ -26,6 +27,11 @@ func main() {
 	util.ReadCIDRConfig()
 	checks.SetLogLevel(util.GetLogLevel(), util.Verbose())
 
+	if err := util.CheckBinding(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	if util.GuiRequested() {
 		runGui()
 	} else {
@@ -42,6 +48,16 @@ func startChecks(checksToDo []string, close bool) {
 	if util.SkipIPv6() {
 		checks.AdminCheck.Log(checks.LogLevelAdmin, "SKIP_IPV6", "IPv6 checks are disabled")
 	}
-	checks.ExecuteChecks(checksToDo)
+	if iface := util.GetInterface(); iface != "" {
+		checks.AdminCheck.Log(checks.LogLevelAdmin, "INTERFACE", "Connections are made from interface "+iface)
+	}
+	if source := util.GetSourceAddress(); source.IsValid() {
+		checks.AdminCheck.Log(checks.LogLevelAdmin, "SOURCE", "Connections are made from source address "+source.String())
+	}
+	if util.EachInterface() {
+		checks.ExecuteChecksOnEachInterface(checksToDo)
+	} else {
+		checks.ExecuteChecks(checksToDo)
+	}
 	checks.Finish(close)
 }
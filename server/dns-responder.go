@@ -0,0 +1,76 @@
+/*
+  A minimal authoritative DNS responder for the source port and query ID
+  randomisation check. For TXT queries of any name in the zone it responds
+  with the source address, source port, query ID and query name (in the case
+  it was received, to detect 0x20 encoding by resolvers) it saw. If the query
+  contained an EDNS client subnet option, that is echoed back too, so it can
+  also stand in for an ECS echo server.
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+var flagZone string
+
+// answer one DNS query
+func handleDNS(w dns.ResponseWriter, req *dns.Msg) {
+	resp := new(dns.Msg)
+	resp.SetReply(req)
+	resp.Authoritative = true
+
+	if len(req.Question) != 1 {
+		resp.Rcode = dns.RcodeFormatError
+		w.WriteMsg(resp)
+		return
+	}
+	question := req.Question[0]
+	logger.Printf("DNS %v %v %s %s %d", w.LocalAddr(), w.RemoteAddr(), question.Name, dns.TypeToString[question.Qtype], req.Id)
+
+	if !dns.IsSubDomain(flagZone, question.Name) {
+		resp.Rcode = dns.RcodeRefused
+		w.WriteMsg(resp)
+		return
+	}
+
+	if question.Qtype == dns.TypeTXT && question.Qclass == dns.ClassINET {
+		host, port, _ := net.SplitHostPort(w.RemoteAddr().String())
+		txt := []string{fmt.Sprintf("source=%s port=%s id=%d qname=%s", host, port, req.Id, question.Name)}
+		if opt := req.IsEdns0(); opt != nil {
+			for _, option := range opt.Option {
+				if ecs, ok := option.(*dns.EDNS0_SUBNET); ok {
+					txt = append(txt, fmt.Sprintf("edns0-client-subnet %s/%d", ecs.Address, ecs.SourceNetmask))
+				}
+			}
+		}
+		for _, t := range txt {
+			resp.Answer = append(resp.Answer, &dns.TXT{
+				Hdr: dns.RR_Header{Name: question.Name, Rrtype: dns.TypeTXT, Class: dns.ClassINET, Ttl: 0},
+				Txt: []string{t},
+			})
+		}
+	}
+
+	w.WriteMsg(resp)
+}
+
+// serve DNS over UDP and TCP on the given port
+func serveDNS(port int) error {
+	flagZone = dns.Fqdn(strings.ToLower(flagZone))
+	dns.HandleFunc(".", handleDNS)
+
+	errors := make(chan error, 2)
+	for _, network := range []string{"udp", "tcp"} {
+		server := &dns.Server{Addr: fmt.Sprintf(":%d", port), Net: network}
+		go func() {
+			errors <- server.ListenAndServe()
+		}()
+	}
+	return <-errors
+}
@@ -0,0 +1,71 @@
+/*
+  A minimal HTTP responder compatible with the RIPE Atlas anchors, for the
+  path MTU HTTP check. A request for /N returns a JSON object with the name of
+  the anchor, the client address as seen by the server and a payload of N
+  bytes. Any query string (like the padding used to make requests larger) is
+  ignored.
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// the largest payload to send
+const maxAnchorPayload = 1 << 20
+
+var flagAnchorName string
+
+// the response format of the anchors
+type anchorResponse struct {
+	Anchor  string `json:"anchor"`
+	Client  string `json:"client"`
+	Payload string `json:"payload"`
+}
+
+// answer one HTTP request
+func handleAnchor(w http.ResponseWriter, req *http.Request) {
+	client, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		client = req.RemoteAddr
+	}
+	logger.Printf("HTTP %v %v %s (%d bytes query)", req.Context().Value(http.LocalAddrContextKey), req.RemoteAddr, req.URL.Path, len(req.URL.RawQuery))
+
+	size := 0
+	if path := strings.TrimPrefix(req.URL.Path, "/"); path != "" {
+		size, err = strconv.Atoi(path)
+		if err != nil || size < 0 || size > maxAnchorPayload {
+			http.NotFound(w, req)
+			return
+		}
+	}
+
+	body, err := json.Marshal(anchorResponse{
+		Anchor:  flagAnchorName,
+		Client:  client,
+		Payload: strings.Repeat("X", size),
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+// serve HTTP on the given port
+func serveHTTPAnchor(port int) error {
+	if flagAnchorName == "" {
+		flagAnchorName, _ = os.Hostname()
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", handleAnchor)
+	return http.ListenAndServe(fmt.Sprintf(":%d", port), mux)
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHandleAnchor(t *testing.T) {
+	logger = log.New(io.Discard, "", 0)
+	flagAnchorName = "anchor.example"
+
+	tests := []struct {
+		name    string
+		target  string
+		status  int
+		payload int
+	}{
+		{"no payload", "/", http.StatusOK, 0},
+		{"payload", "/1400", http.StatusOK, 1400},
+		{"padding is ignored", "/100?padding=" + strings.Repeat("X", 2000), http.StatusOK, 100},
+		{"largest payload", "/1048576", http.StatusOK, maxAnchorPayload},
+		{"payload too large", "/1048577", http.StatusNotFound, 0},
+		{"negative payload", "/-1", http.StatusNotFound, 0},
+		{"not a number", "/index.html", http.StatusNotFound, 0},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest("GET", test.target, nil)
+			req.RemoteAddr = "[2001:db8::1]:54321"
+			recorder := httptest.NewRecorder()
+			handleAnchor(recorder, req)
+
+			if recorder.Code != test.status {
+				t.Fatalf("status = %d, want %d", recorder.Code, test.status)
+			}
+			if test.status != http.StatusOK {
+				return
+			}
+			if contentType := recorder.Header().Get("Content-Type"); contentType != "application/json" {
+				t.Errorf("content type = %q, want application/json", contentType)
+			}
+
+			// the anchors send exactly these fields
+			var fields map[string]string
+			if err := json.Unmarshal(recorder.Body.Bytes(), &fields); err != nil {
+				t.Fatalf("invalid response %q: %v", recorder.Body.String(), err)
+			}
+			if len(fields) != 3 {
+				t.Errorf("fields = %v, want anchor, client and payload", fields)
+			}
+			if fields["anchor"] != "anchor.example" {
+				t.Errorf("anchor = %q, want anchor.example", fields["anchor"])
+			}
+			if fields["client"] != "2001:db8::1" {
+				t.Errorf("client = %q, want 2001:db8::1", fields["client"])
+			}
+			if fields["payload"] != strings.Repeat("X", test.payload) {
+				t.Errorf("payload is %d bytes, want %d", len(fields["payload"]), test.payload)
+			}
+		})
+	}
+}
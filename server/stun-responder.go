@@ -0,0 +1,129 @@
+/*
+  A minimal STUN (RFC 5389) responder. It answers binding requests with the
+  address and port it saw. If an alternate address is given, it listens on
+  two addresses and two ports (port and port+1) and supports the NAT
+  behaviour discovery of RFC 5780 (OTHER-ADDRESS and CHANGE-REQUEST).
+*/
+
+package main
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+
+	"github.com/robert-kisteleki/netiscope/util"
+)
+
+var (
+	flagSTUNAddr    string
+	flagSTUNAltAddr string
+)
+
+// serve STUN on the given port, optionally on the alternate address and port too
+func serveSTUN(port int) error {
+	if flagSTUNAltAddr == "" {
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{Port: port})
+		if err != nil {
+			return err
+		}
+		serveSTUNSocket(conn, nil, netip.AddrPort{})
+		return nil
+	}
+
+	primary, err := netip.ParseAddr(flagSTUNAddr)
+	if err != nil {
+		return fmt.Errorf("the primary address is needed with an alternate address: %v", err)
+	}
+	alternate, err := netip.ParseAddr(flagSTUNAltAddr)
+	if err != nil {
+		return err
+	}
+
+	// sockets[ip][port]: 0 is the primary, 1 is the alternate
+	var sockets [2][2]*net.UDPConn
+	for i, addr := range []netip.Addr{primary, alternate} {
+		for j, p := range []int{port, port + 1} {
+			conn, err := net.ListenUDP("udp", net.UDPAddrFromAddrPort(netip.AddrPortFrom(addr, uint16(p))))
+			if err != nil {
+				return err
+			}
+			sockets[i][j] = conn
+		}
+	}
+
+	other := netip.AddrPortFrom(alternate, uint16(port+1))
+	for i := range 2 {
+		for j := range 2 {
+			if i == 1 && j == 1 {
+				break
+			}
+			go serveSTUNSocket(sockets[i][j], &sockets, other)
+		}
+	}
+	serveSTUNSocket(sockets[1][1], &sockets, other)
+	return nil
+}
+
+// answer binding requests arriving on one socket
+// sockets: all sockets, to be able to answer change requests (nil if not supported)
+// other: the alternate address and port to advertise
+func serveSTUNSocket(conn *net.UDPConn, sockets *[2][2]*net.UDPConn, other netip.AddrPort) {
+	buffer := make([]byte, 1500)
+	for {
+		n, from, err := conn.ReadFromUDPAddrPort(buffer)
+		if err != nil {
+			fmt.Println(err)
+			continue
+		}
+		request, err := util.ParseSTUNMessage(buffer[:n])
+		if err != nil || request.Type != util.STUNBindingRequest {
+			logThis(conn.LocalAddr().String(), from.String(), buffer, n)
+			continue
+		}
+		logger.Printf("STUN %v %v change-ip=%v change-port=%v", conn.LocalAddr(), from, request.ChangeIP, request.ChangePort)
+
+		// pick the socket to respond from
+		out := conn
+		if request.ChangeIP || request.ChangePort {
+			if sockets == nil {
+				// RFC 5780: respond with 420 if change requests are not supported
+				response := &util.STUNMessage{Type: util.STUNBindingError, TransactionID: request.TransactionID, ErrorCode: 420}
+				conn.WriteToUDPAddrPort(response.Encode(), from)
+				continue
+			}
+			i, j := socketIndex(sockets, conn)
+			if request.ChangeIP {
+				i = 1 - i
+			}
+			if request.ChangePort {
+				j = 1 - j
+			}
+			out = sockets[i][j]
+		}
+
+		response := &util.STUNMessage{
+			Type:           util.STUNBindingResponse,
+			TransactionID:  request.TransactionID,
+			MappedAddress:  from,
+			ResponseOrigin: out.LocalAddr().(*net.UDPAddr).AddrPort(),
+			OtherAddress:   other,
+			Software:       "netiscope",
+		}
+		if _, err := out.WriteToUDPAddrPort(response.Encode(), from); err != nil {
+			fmt.Println(err)
+		}
+	}
+}
+
+// find which address and port a socket belongs to
+func socketIndex(sockets *[2][2]*net.UDPConn, conn *net.UDPConn) (int, int) {
+	for i := range 2 {
+		for j := range 2 {
+			if sockets[i][j] == conn {
+				return i, j
+			}
+		}
+	}
+	return 0, 0
+}
@@ -2,6 +2,10 @@
   A simple TCP+UDP "replier". It is far from perfect but it kinda works.
   It doesn't care what the client says. It just reponds with a short string.
   It must be run with parameters to specify the logfile, proto and port to listen on.
+  With proto DNS it acts as a minimal authoritative server for the given zone
+  instead (see dns-responder.go), with proto STUN it's a STUN server (see
+  stun-responder.go), with proto HTTP it's an anchor-like HTTP server for the
+  path MTU HTTP check (see http-anchor.go).
 
   For copyright, license, documentation, full source code and others see
   https://github.com/robert-kisteleki/netiscope
@@ -100,9 +104,13 @@ func main() {
 		flag.PrintDefaults()
 		return
 	}
-	flag.StringVar(&flagProto, "proto", "", "Listen using TCP or UDP")
+	flag.StringVar(&flagProto, "proto", "", "Listen using TCP, UDP, DNS (UDP+TCP), STUN (UDP) or HTTP (anchor)")
 	flag.IntVar(&flagPort, "port", 0, "What port to listen on")
 	flag.StringVar(&flagLog, "log", "", "Log file to write to")
+	flag.StringVar(&flagZone, "zone", "", "Zone to answer for in DNS mode")
+	flag.StringVar(&flagSTUNAddr, "stun-addr", "", "Primary address to listen on in STUN mode (needed with -stun-alt-addr)")
+	flag.StringVar(&flagSTUNAltAddr, "stun-alt-addr", "", "Alternate address to listen on in STUN mode, for NAT behaviour discovery (RFC 5780)")
+	flag.StringVar(&flagAnchorName, "anchor-name", "", "Name to report in HTTP mode (default: the host name)")
 	flag.Parse()
 
 	// insist on all parameters to be specified with reasonable values
@@ -111,8 +119,12 @@ func main() {
 		flag.PrintDefaults()
 		return
 	}
-	if flagProto != "UDP" && flagProto != "TCP" {
-		fmt.Println("Protocol must be TCP or UDP")
+	if flagProto != "UDP" && flagProto != "TCP" && flagProto != "DNS" && flagProto != "STUN" && flagProto != "HTTP" {
+		fmt.Println("Protocol must be TCP, UDP, DNS, STUN or HTTP")
+		return
+	}
+	if flagProto == "DNS" && flagZone == "" {
+		fmt.Println("Zone must be specified in DNS mode")
 		return
 	}
 	if flagPort <= 0 || flagPort >= 65536 {
@@ -134,6 +146,28 @@ func main() {
 	defer logFile.Close()
 	logger = log.New(logFile, "", log.LstdFlags|log.LUTC)
 
+	// the DNS server is handled by the DNS package
+	if flagProto == "DNS" {
+		if err := serveDNS(flagPort); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if flagProto == "STUN" {
+		if err := serveSTUN(flagPort); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
+	if flagProto == "HTTP" {
+		if err := serveHTTPAnchor(flagPort); err != nil {
+			fmt.Println(err)
+		}
+		return
+	}
+
 	// the TCP server is simple
 	if flagProto == "TCP" {
 		listener, err := net.Listen("tcp", fmt.Sprintf(":%d", flagPort))
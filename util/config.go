@@ -4,7 +4,10 @@ import (
 	"flag"
 	"fmt"
 	"net"
+	"net/netip"
 	"os"
+	"slices"
+	"strconv"
 	"strings"
 
 	"github.com/go-ini/ini"
@@ -30,8 +33,17 @@ var (
 	flagListen    string
 	flagVersion   bool
 	flagJSON      bool
+	flagInterface string
+	flagSource    string
+	flagEachIface bool
 	GuiIPv4       bool
 	GuiIPv6       bool
+	GuiInterface  string
+	GuiSource     string
+	GuiEachIface  bool
+
+	// the interface of the current run when running the checks once per interface
+	runInterface string
 
 	// network interface check can signal if there were no routable addresses found
 	noUsableIPv4 bool
@@ -58,6 +70,9 @@ func SetupFlags() {
 	flag.StringVar(&flagListen, "listen", "localhost:8080", "What host:port to listen on for the GUI")
 	flag.BoolVar(&flagVersion, "version", false, "Show version")
 	flag.BoolVar(&flagJSON, "json", false, "Output results in JSON format")
+	flag.StringVar(&flagInterface, "interface", "", "Make all connections from this network interface")
+	flag.StringVar(&flagSource, "source", "", "Make all connections from this source address")
+	flag.BoolVar(&flagEachIface, "each-interface", false, "Run the checks once on each network interface and compare the results")
 
 	flag.Parse()
 }
@@ -128,6 +143,9 @@ func GetConfigBoolParam(section string, key string, deflt bool) bool {
 
 // SkipIPv4 decides if IPv4 related checks should be skipped
 func SkipIPv4() bool {
+	if GetSourceAddress().Is6() {
+		return true
+	}
 	if flagGui {
 		return !GuiIPv4
 	} else {
@@ -137,6 +155,9 @@ func SkipIPv4() bool {
 
 // SkipIPv6 decides if IPv6 related checks should be skipped
 func SkipIPv6() bool {
+	if GetSourceAddress().Is4() {
+		return true
+	}
 	if flagGui {
 		return !GuiIPv6
 	} else {
@@ -144,6 +165,85 @@ func SkipIPv6() bool {
 	}
 }
 
+// GetInterface returns the name of the network interface all connections should be made from, if any
+func GetInterface() string {
+	switch {
+	case runInterface != "":
+		return runInterface
+	case flagGui:
+		return GuiInterface
+	default:
+		return flagInterface
+	}
+}
+
+// GetSourceAddress returns the source address all connections should be made from
+// it's not valid if there's none
+func GetSourceAddress() netip.Addr {
+	source := flagSource
+	if flagGui {
+		source = GuiSource
+	}
+	addr, _ := netip.ParseAddr(source)
+	return addr.Unmap()
+}
+
+// EachInterface tells if the checks should be run once on each network interface
+func EachInterface() bool {
+	if flagGui {
+		return GuiEachIface
+	}
+	return flagEachIface
+}
+
+// SetRunInterface sets the interface of the current run when running the checks once per interface
+// what was learnt about usable addresses on the previous interface is forgotten
+func SetRunInterface(name string) {
+	runInterface = name
+	noUsableIPv4 = false
+	noUsableIPv6 = false
+}
+
+// CheckBinding verifies that the interface and the source address, if any, can be used
+func CheckBinding() error {
+	if name := GetInterface(); name != "" {
+		if _, err := net.InterfaceByName(name); err != nil {
+			return fmt.Errorf("unknown interface %s: %v", name, err)
+		}
+	}
+	source := flagSource
+	if flagGui {
+		source = GuiSource
+	}
+	if source == "" {
+		return nil
+	}
+	if !GetSourceAddress().IsValid() {
+		return fmt.Errorf("invalid source address %s", source)
+	}
+	if EachInterface() {
+		return fmt.Errorf("a source address cannot be used when running on each interface")
+	}
+	if !isLocalAddress(GetSourceAddress()) {
+		return fmt.Errorf("source address %s is not assigned to any interface", source)
+	}
+	return nil
+}
+
+// isLocalAddress tells if an address is assigned to one of the network interfaces
+func isLocalAddress(addr netip.Addr) bool {
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return false
+	}
+	for _, ifaceAddr := range addrs {
+		if prefix, err := netip.ParsePrefix(ifaceAddr.String()); err == nil && prefix.Addr().Unmap() == addr.WithZone("") {
+			return true
+		}
+	}
+	return false
+}
+
 // GetPingCount returns how many ping packets should be sent
 func GetPingCount() int {
 	return cfg.Section("main").Key("ping_packets").MustInt(3)
@@ -151,7 +251,33 @@ func GetPingCount() int {
 
 // GetDNSNamesToLookup returns the list of FQDNs to look up with DNS resolvers
 func GetDNSNamesToLookup() []string {
-	return cfg.Section("dns").Key("name").ValueWithShadows()
+	var names []string
+	for _, item := range splitConfigKeyList("dns", "name") {
+		names = append(names, strings.TrimSpace(item[0]))
+	}
+	return names
+}
+
+// GetDNSQueryTypesForName returns the additional query types (besides A and AAAA)
+// to ask for a particular name, as in: name = "example.com,HTTPS,MX"
+func GetDNSQueryTypesForName(name string) []string {
+	var qtypes []string
+	for _, item := range splitConfigKeyList("dns", "name") {
+		if strings.TrimSpace(item[0]) != name {
+			continue
+		}
+		for _, qtype := range item[1:] {
+			if qtype = strings.TrimSpace(qtype); qtype != "" {
+				qtypes = append(qtypes, strings.ToUpper(qtype))
+			}
+		}
+	}
+	return qtypes
+}
+
+// GetResolvConfPath returns where the local resolver configuration is read from
+func GetResolvConfPath() string {
+	return cfg.Section("dns").Key("resolv_conf").MustString("/etc/resolv.conf")
 }
 
 // GetOpenResolverList returns the list of open resolvers to check
@@ -232,6 +358,122 @@ func GetPathMTUHTTPCheckTimeout() int {
 	return cfg.Section("path_mtu_http").Key("timeout").MustInt(3000)
 }
 
+// GetPathMTUHTTPSizes returns the request or response sizes to try, in increasing order
+func GetPathMTUHTTPSizes(key string) []int {
+	var sizes []int
+	for _, item := range strings.Split(cfg.Section("path_mtu_http").Key(key).MustString("100,500,1000,1200,1400,1600,2000,4000"), ",") {
+		if size, err := strconv.Atoi(strings.TrimSpace(item)); err == nil && size > 0 {
+			sizes = append(sizes, size)
+		}
+	}
+	slices.Sort(sizes)
+	return sizes
+}
+
+// GetPathMTUHTTPPrecision returns how close the search for the failing size should get (bytes)
+func GetPathMTUHTTPPrecision() int {
+	return cfg.Section("path_mtu_http").Key("precision").MustInt(8)
+}
+
+// GetPathMTUHTTPParallel returns how many targets are checked at the same time
+func GetPathMTUHTTPParallel() int {
+	return cfg.Section("path_mtu_http").Key("parallel").MustInt(4)
+}
+
+// GetPathMTUICMPTargets returns the list of targets (names or addresses) for ICMP path MTU discovery
+func GetPathMTUICMPTargets() []string {
+	targets := cfg.Section("path_mtu_icmp").Key("target").ValueWithShadows()
+	if len(targets) == 1 && targets[0] == "" {
+		return nil
+	}
+	return targets
+}
+
+// GetPathMTUICMPMaxMTU returns the largest packet size to try
+func GetPathMTUICMPMaxMTU() int {
+	return cfg.Section("path_mtu_icmp").Key("max_mtu").MustInt(1500)
+}
+
+// GetPathMTUICMPTimeout returns how long to wait (ms) for the answer to a probe
+func GetPathMTUICMPTimeout() int {
+	return cfg.Section("path_mtu_icmp").Key("timeout").MustInt(1000)
+}
+
+// GetPathMTUICMPAttempts returns how many times a probe of a given size is sent before it's considered lost
+func GetPathMTUICMPAttempts() int {
+	return cfg.Section("path_mtu_icmp").Key("attempts").MustInt(2)
+}
+
+// GetHappyEyeballsTargets returns the list of dual-stack names to check with Happy Eyeballs
+// if there are none then the [dns] names are used
+func GetHappyEyeballsTargets() []string {
+	targets := cfg.Section("happy_eyeballs").Key("target").ValueWithShadows()
+	if len(targets) == 1 && targets[0] == "" {
+		return nil
+	}
+	return targets
+}
+
+// GetHappyEyeballsPort returns the (TLS) port to connect to
+func GetHappyEyeballsPort() int {
+	return cfg.Section("happy_eyeballs").Key("port").MustInt(443)
+}
+
+// GetHappyEyeballsTimeout returns how long to wait (ms) for a lookup, a connection or a TLS handshake
+func GetHappyEyeballsTimeout() int {
+	return cfg.Section("happy_eyeballs").Key("timeout").MustInt(3000)
+}
+
+// GetHappyEyeballsAttempts returns how many connections are made per address family
+func GetHappyEyeballsAttempts() int {
+	return cfg.Section("happy_eyeballs").Key("attempts").MustInt(3)
+}
+
+// GetHappyEyeballsSlowerThreshold returns how much slower (ms) IPv6 connections can be before it's flagged
+func GetHappyEyeballsSlowerThreshold() int {
+	return cfg.Section("happy_eyeballs").Key("slower_threshold").MustInt(100)
+}
+
+// GetCaptivePortalProbes returns the list of [url,expected] probes for captive portal detection
+// expected is either a status code or some content of the response
+func GetCaptivePortalProbes() [][]string {
+	return splitConfigKeyList("captive_portal", "probe")
+}
+
+// GetCaptivePortalAPIURLs returns the configured captive portal API (RFC 8908) URLs
+// if there are none then the ones announced by the network are used
+func GetCaptivePortalAPIURLs() []string {
+	urls := cfg.Section("captive_portal").Key("api").ValueWithShadows()
+	if len(urls) == 1 && urls[0] == "" {
+		return nil
+	}
+	return urls
+}
+
+// GetCaptivePortalTimeout returns the network timeout (ms) for captive portal probes
+func GetCaptivePortalTimeout() int {
+	return cfg.Section("captive_portal").Key("timeout").MustInt(3000)
+}
+
+// GetPopularServices returns the list of popular service names to check
+func GetPopularServices() []string {
+	services := cfg.Section("popular_services").Key("service").ValueWithShadows()
+	if len(services) == 1 && services[0] == "" {
+		return nil
+	}
+	return services
+}
+
+// GetPopularServicesTimeout returns the network timeout (ms) for popular service requests
+func GetPopularServicesTimeout() int {
+	return cfg.Section("popular_services").Key("timeout").MustInt(5000)
+}
+
+// GetPopularServicesMaxClockSkew returns how much (seconds) the local clock can differ from the servers' one
+func GetPopularServicesMaxClockSkew() int {
+	return cfg.Section("popular_services").Key("max_clock_skew").MustInt(300)
+}
+
 func Verbose() bool {
 	return flagVerbose
 }
@@ -292,3 +534,316 @@ func VersionRequested() bool {
 func GetCDNList() []string {
 	return cfg.Section("cdns").Key("cdn").ValueWithShadows()
 }
+
+// GetRootZoneTransferServers returns the list of servers to transfer the root zone from
+func GetRootZoneTransferServers() []string {
+	return cfg.Section("dns_root_zone").Key("server").ValueWithShadows()
+}
+
+// GetRootZoneTrustAnchors returns the DS records the root zone DNSKEYs are validated against
+func GetRootZoneTrustAnchors() []string {
+	return cfg.Section("dns_root_zone").Key("trust_anchor").ValueWithShadows()
+}
+
+// GetRootZoneTransferTimeout returns the timeout (ms) to connect and for each read of root zone transfers
+func GetRootZoneTransferTimeout() int {
+	return cfg.Section("dns_root_zone").Key("timeout").MustInt(30000)
+}
+
+// GetRootZoneCompareSerials decides if the transferred serial should be compared with the root servers
+func GetRootZoneCompareSerials() bool {
+	return cfg.Section("dns_root_zone").Key("compare_serials").MustBool(true)
+}
+
+// GetProcRoot returns where the proc filesystem is, to read the routing tables and IPv6 address flags from
+func GetProcRoot() string {
+	return cfg.Section("network_interfaces").Key("proc_root").MustString("/proc")
+}
+
+// GetSysRoot returns where the sys filesystem is, to read interface details from
+func GetSysRoot() string {
+	return cfg.Section("network_interfaces").Key("sys_root").MustString("/sys")
+}
+
+// GetInterfaceCounterInterval returns the time (ms) between two samples of the interface counters
+func GetInterfaceCounterInterval() int {
+	return cfg.Section("network_interfaces").Key("counter_interval").MustInt(1000)
+}
+
+// GetPingGateways decides if the default gateways should be pinged
+func GetPingGateways() bool {
+	return cfg.Section("network_interfaces").Key("ping_gateways").MustBool(true)
+}
+
+// GetDDREnabled decides if designated resolvers (RFC 9462) of the local resolvers should be discovered and tested
+func GetDDREnabled() bool {
+	return cfg.Section("dns").Key("ddr").MustBool(true)
+}
+
+// GetDNSBenchmarkName returns the name to use for repeated benchmark queries
+func GetDNSBenchmarkName() string {
+	names := GetDNSNamesToLookup()
+	deflt := ""
+	if len(names) > 0 {
+		deflt = names[0]
+	}
+	return cfg.Section("dns_benchmark").Key("name").MustString(deflt)
+}
+
+// GetDNSBenchmarkCacheBustingDomain returns the domain under which random names are queried
+func GetDNSBenchmarkCacheBustingDomain() string {
+	return cfg.Section("dns_benchmark").Key("cache_busting_domain").MustString(GetDNSBenchmarkName())
+}
+
+// GetDNSBenchmarkQueries returns how many queries of each kind should be sent to each resolver
+func GetDNSBenchmarkQueries() int {
+	return cfg.Section("dns_benchmark").Key("queries").MustInt(10)
+}
+
+// GetDNSBenchmarkTimeout returns the timeout (ms) for DoH benchmark queries
+func GetDNSBenchmarkTimeout() int {
+	return cfg.Section("dns_benchmark").Key("timeout").MustInt(3000)
+}
+
+// GetDNSBenchmarkThreshold returns one of the benchmark thresholds (ms or %), 0 means disabled
+func GetDNSBenchmarkThreshold(key string, deflt int) int {
+	return cfg.Section("dns_benchmark").Key(key).MustInt(deflt)
+}
+
+// GetDNSCapabilitiesName returns the name to use for resolver capability queries
+func GetDNSCapabilitiesName() string {
+	names := GetDNSNamesToLookup()
+	deflt := ""
+	if len(names) > 0 {
+		deflt = names[0]
+	}
+	return cfg.Section("dns_resolver_capabilities").Key("name").MustString(deflt)
+}
+
+// GetDNSCapabilitiesSignedName returns a name with valid DNSSEC signatures
+func GetDNSCapabilitiesSignedName() string {
+	return cfg.Section("dns_resolver_capabilities").Key("dnssec_signed").MustString("isc.org")
+}
+
+// GetDNSCapabilitiesBrokenName returns a name with deliberately broken DNSSEC signatures
+func GetDNSCapabilitiesBrokenName() string {
+	return cfg.Section("dns_resolver_capabilities").Key("dnssec_broken").MustString("dnssec-failed.org")
+}
+
+// GetDNSCapabilitiesClientSubnet returns the client subnet to send in ECS queries
+func GetDNSCapabilitiesClientSubnet() string {
+	return cfg.Section("dns_resolver_capabilities").Key("client_subnet").MustString("198.51.100.0/24")
+}
+
+// GetECSLeakEchoName returns the [name,type] that echoes the client subnet received by the authoritative server
+func GetECSLeakEchoName() []string {
+	return strings.Split(cfg.Section("dns_ecs_leak").Key("echo").MustString("o-o.myaddr.l.google.com,TXT"), ",")
+}
+
+// GetECSLeakClientSubnets returns the client subnets to send in ECS queries
+func GetECSLeakClientSubnets() []string {
+	subnets := cfg.Section("dns_ecs_leak").Key("client_subnet").ValueWithShadows()
+	if len(subnets) == 0 || (len(subnets) == 1 && subnets[0] == "") {
+		return []string{"198.51.100.0/24", "2001:db8:5e:c500::/56"}
+	}
+	return subnets
+}
+
+// GetECSLeakResolvers returns the resolvers (host or host:port) to use instead of the local and open ones
+func GetECSLeakResolvers() []string {
+	resolvers := cfg.Section("dns_ecs_leak").Key("resolver").ValueWithShadows()
+	if len(resolvers) == 1 && resolvers[0] == "" {
+		return nil
+	}
+	return resolvers
+}
+
+// GetDNSRandomisationZone returns the zone served by the randomisation responder
+func GetDNSRandomisationZone() string {
+	return cfg.Section("dns_randomisation").Key("zone").MustString("")
+}
+
+// GetDNSRandomisationQueries returns how many queries to send to each resolver in a burst
+func GetDNSRandomisationQueries() int {
+	return cfg.Section("dns_randomisation").Key("queries").MustInt(20)
+}
+
+// GetDNSRandomisationResolvers returns the resolvers (host or host:port) to use instead of the local ones
+func GetDNSRandomisationResolvers() []string {
+	resolvers := cfg.Section("dns_randomisation").Key("resolver").ValueWithShadows()
+	if len(resolvers) == 1 && resolvers[0] == "" {
+		return nil
+	}
+	return resolvers
+}
+
+// GetEncryptedDNSDecoySNI returns the innocent looking name used as SNI to detect SNI based blocking
+func GetEncryptedDNSDecoySNI() string {
+	return cfg.Section("encrypted_dns_blocking").Key("decoy_sni").MustString("www.wikipedia.org")
+}
+
+// GetEncryptedDNSDoTHosts returns the DoH host names that offer DoT as well
+func GetEncryptedDNSDoTHosts() []string {
+	hosts := cfg.Section("encrypted_dns_blocking").Key("dot").ValueWithShadows()
+	if len(hosts) == 1 && hosts[0] == "" {
+		return nil
+	}
+	return hosts
+}
+
+// GetEncryptedDNSTimeout returns the timeout (ms) for connections and TLS handshakes
+func GetEncryptedDNSTimeout() int {
+	return cfg.Section("encrypted_dns_blocking").Key("timeout").MustInt(3000)
+}
+
+// GetHostsFilePath returns the location of the hosts file
+func GetHostsFilePath() string {
+	return cfg.Section("local_name_resolution").Key("hosts").MustString("/etc/hosts")
+}
+
+// GetNSSwitchPath returns the location of nsswitch.conf
+func GetNSSwitchPath() string {
+	return cfg.Section("local_name_resolution").Key("nsswitch").MustString("/etc/nsswitch.conf")
+}
+
+// GetLocalNameResolutionMulticast decides if mDNS and LLMNR should be probed on the local link
+func GetLocalNameResolutionMulticast() bool {
+	return cfg.Section("local_name_resolution").Key("multicast").MustBool(false)
+}
+
+// GetLocalNameResolutionMDNSName returns the name to ask via mDNS (default: this host's name in .local)
+func GetLocalNameResolutionMDNSName() string {
+	deflt := ""
+	if hostname, err := os.Hostname(); err == nil {
+		deflt = strings.Split(hostname, ".")[0] + ".local"
+	}
+	return cfg.Section("local_name_resolution").Key("mdns_name").MustString(deflt)
+}
+
+// GetLocalNameResolutionLLMNRName returns the name to ask via LLMNR (default: this host's name)
+func GetLocalNameResolutionLLMNRName() string {
+	deflt := ""
+	if hostname, err := os.Hostname(); err == nil {
+		deflt = strings.Split(hostname, ".")[0]
+	}
+	return cfg.Section("local_name_resolution").Key("llmnr_name").MustString(deflt)
+}
+
+// GetLocalNameResolutionTimeout returns the timeout (ms) for system and multicast lookups
+func GetLocalNameResolutionTimeout() int {
+	return cfg.Section("local_name_resolution").Key("timeout").MustInt(2000)
+}
+
+// GetSTUNServers returns the list of STUN servers (host:port) to use
+func GetSTUNServers() []string {
+	servers := cfg.Section("stun").Key("server").ValueWithShadows()
+	if len(servers) == 1 && servers[0] == "" {
+		return nil
+	}
+	return servers
+}
+
+// GetSTUNTimeout returns the timeout (ms) of one STUN request
+func GetSTUNTimeout() int {
+	return cfg.Section("stun").Key("timeout").MustInt(1000)
+}
+
+// GetSTUNRetries returns how many times a STUN request is retried
+func GetSTUNRetries() int {
+	return cfg.Section("stun").Key("retries").MustInt(2)
+}
+
+// GetTracerouteMethods returns the traceroute methods to use (icmp, udp, tcp)
+func GetTracerouteMethods() []string {
+	var methods []string
+	for _, method := range strings.Split(cfg.Section("traceroute").Key("method").MustString("icmp"), ",") {
+		if method = strings.ToLower(strings.TrimSpace(method)); method != "" {
+			methods = append(methods, method)
+		}
+	}
+	return methods
+}
+
+// GetTracerouteTargets returns the additional hosts (names or addresses) to trace
+func GetTracerouteTargets() []string {
+	targets := cfg.Section("traceroute").Key("target").ValueWithShadows()
+	if len(targets) == 1 && targets[0] == "" {
+		return nil
+	}
+	return targets
+}
+
+// GetTracerouteLocalResolvers returns if the local resolvers should be traced
+func GetTracerouteLocalResolvers() bool {
+	return cfg.Section("traceroute").Key("local_resolvers").MustBool(true)
+}
+
+// GetTracerouteOpenResolvers returns if the open resolvers should be traced
+func GetTracerouteOpenResolvers() bool {
+	return cfg.Section("traceroute").Key("open_resolvers").MustBool(false)
+}
+
+// GetTracerouteRootServers returns the letters of the root servers to trace
+func GetTracerouteRootServers() []string {
+	var letters []string
+	for _, letter := range strings.Split(cfg.Section("traceroute").Key("root_servers").MustString(""), ",") {
+		if letter = strings.ToUpper(strings.TrimSpace(letter)); letter != "" {
+			letters = append(letters, letter)
+		}
+	}
+	return letters
+}
+
+// GetTracerouteMaxHops returns the maximum TTL to probe with
+func GetTracerouteMaxHops() int {
+	return cfg.Section("traceroute").Key("max_hops").MustInt(30)
+}
+
+// GetTracerouteQueries returns how many probes to send per hop
+func GetTracerouteQueries() int {
+	return cfg.Section("traceroute").Key("queries").MustInt(3)
+}
+
+// GetTracerouteTimeout returns how long to wait for responses (ms) after a round of probes
+func GetTracerouteTimeout() int {
+	return cfg.Section("traceroute").Key("timeout").MustInt(2000)
+}
+
+// GetTracerouteMTRRounds returns how many MTR style rounds to do after a traceroute (0: none)
+func GetTracerouteMTRRounds() int {
+	return cfg.Section("traceroute").Key("mtr_rounds").MustInt(0)
+}
+
+// GetTraceroutePort returns the destination port for the UDP (base port) and TCP methods
+func GetTraceroutePort(method string) int {
+	switch method {
+	case "udp":
+		return cfg.Section("traceroute").Key("udp_port").MustInt(33434)
+	case "tcp":
+		return cfg.Section("traceroute").Key("tcp_port").MustInt(443)
+	}
+	return 0
+}
+
+// GetEgressWhoamiNames returns the list of [name,type(,server...)] that return the querier's address
+func GetEgressWhoamiNames() [][]string {
+	return splitConfigKeyList("egress_identity", "whoami")
+}
+
+// GetEgressHTTPEchoURLs returns the list of [af,url] services that return the client's address
+func GetEgressHTTPEchoURLs() [][]string {
+	return splitConfigKeyList("egress_identity", "http_echo")
+}
+
+// GetEgressPrefixLength returns the prefix length used to decide if two addresses are in the same network
+func GetEgressPrefixLength(af string) int {
+	if af == "6" {
+		return cfg.Section("egress_identity").Key("prefix_length6").MustInt(48)
+	}
+	return cfg.Section("egress_identity").Key("prefix_length4").MustInt(16)
+}
+
+// GetEgressTimeout returns the timeout (ms) for HTTP echo requests
+func GetEgressTimeout() int {
+	return cfg.Section("egress_identity").Key("timeout").MustInt(3000)
+}
@@ -0,0 +1,81 @@
+package util
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/go-ini/ini"
+)
+
+// use a configuration for the duration of a test
+func loadTestConfig(t *testing.T, config string) {
+	t.Helper()
+	loaded, err := ini.LoadSources(ini.LoadOptions{AllowBooleanKeys: true, AllowShadows: true}, []byte(config))
+	if err != nil {
+		t.Fatal(err)
+	}
+	saved := cfg
+	cfg = loaded
+	t.Cleanup(func() { cfg = saved })
+}
+
+func TestGetDNSQueryTypesForName(t *testing.T) {
+	loadTestConfig(t, `
+[dns]
+name = example.com
+name = example.org,HTTPS,MX
+name = example.net, ch txt ,svcb,
+name = example.org,CAA
+`)
+
+	if names, want := GetDNSNamesToLookup(), []string{"example.com", "example.org", "example.net", "example.org"}; !reflect.DeepEqual(names, want) {
+		t.Errorf("names = %v, want %v", names, want)
+	}
+
+	tests := []struct {
+		name   string
+		qtypes []string
+	}{
+		{"example.com", nil},
+		{"example.org", []string{"HTTPS", "MX", "CAA"}},
+		{"example.net", []string{"CH TXT", "SVCB"}},
+		{"example.edu", nil},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if qtypes := GetDNSQueryTypesForName(test.name); !reflect.DeepEqual(qtypes, test.qtypes) {
+				t.Errorf("query types = %q, want %q", qtypes, test.qtypes)
+			}
+		})
+	}
+}
+
+func TestCheckBinding(t *testing.T) {
+	iface, source, eachInterface := flagInterface, flagSource, flagEachIface
+	t.Cleanup(func() { flagInterface, flagSource, flagEachIface = iface, source, eachInterface })
+
+	tests := []struct {
+		name          string
+		iface         string
+		source        string
+		eachInterface bool
+		err           bool
+	}{
+		{"nothing", "", "", false, false},
+		{"loopback source", "", "127.0.0.1", false, false},
+		{"IPv4-mapped loopback source", "", "::ffff:127.0.0.1", false, false},
+		{"invalid source", "", "127.0.0.300", false, true},
+		{"source not assigned locally", "", "192.0.2.1", false, true},
+		{"source on each interface", "", "127.0.0.1", true, true},
+		{"each interface", "", "", true, false},
+		{"unknown interface", "no-such-interface0", "", false, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			flagInterface, flagSource, flagEachIface = test.iface, test.source, test.eachInterface
+			if err := CheckBinding(); (err != nil) != test.err {
+				t.Errorf("error = %v, want error: %v", err, test.err)
+			}
+		})
+	}
+}
@@ -2,28 +2,157 @@ package util
 
 import (
 	"fmt"
-	"math/big"
 	"net"
+	"net/netip"
 	"strings"
 )
 
-var (
-	// well-known IPv4 CIDR blocks
-	cidrIPv4NAT = []net.IPNet{
-		makeIPNet("10.0.0.0/8"),
-		makeIPNet("172.16.0.0/12"),
-		makeIPNet("192.168.0.0/16"),
+// IPClass is the classification of an address according to the IANA special-purpose address registries
+type IPClass int
+
+const (
+	IPClassInvalid IPClass = iota
+	IPClassGlobal
+	IPClassUnspecified
+	IPClassThisNetwork
+	IPClassLoopback
+	IPClassLinkLocal
+	IPClassPrivate
+	IPClassCGNAT
+	IPClassULA
+	IPClassDocumentation
+	IPClassBenchmarking
+	IPClassProtocolAssignment
+	IPClassMulticast
+	IPClassBroadcast
+	IPClassReserved
+	IPClassDiscardOnly
+	IPClass6to4
+	IPClassTeredo
+	IPClassNAT64
+	IPClassIPv4Mapped
+	IPClassSuspicious
+)
+
+// human readable descriptions of the classes
+var ipClassNames = map[IPClass]string{
+	IPClassInvalid:            "invalid",
+	IPClassGlobal:             "global",
+	IPClassUnspecified:        "unspecified",
+	IPClassThisNetwork:        "this network (RFC 791)",
+	IPClassLoopback:           "loopback",
+	IPClassLinkLocal:          "link local",
+	IPClassPrivate:            "NAT, RFC1918",
+	IPClassCGNAT:              "carrier-grade NAT, RFC 6598",
+	IPClassULA:                "ULA, RFC 4193",
+	IPClassDocumentation:      "documentation",
+	IPClassBenchmarking:       "benchmarking, RFC 2544",
+	IPClassProtocolAssignment: "IETF protocol assignment",
+	IPClassMulticast:          "multicast",
+	IPClassBroadcast:          "limited broadcast",
+	IPClassReserved:           "reserved",
+	IPClassDiscardOnly:        "discard-only, RFC 6666",
+	IPClass6to4:               "6to4, RFC 3056",
+	IPClassTeredo:             "Teredo, RFC 4380",
+	IPClassNAT64:              "NAT64, RFC 6052",
+	IPClassIPv4Mapped:         "IPv4-mapped",
+	IPClassSuspicious:         "suspicious",
+}
+
+// String returns a human readable description of the class
+func (class IPClass) String() string {
+	return ipClassNames[class]
+}
+
+// IsGlobal tells if addresses of the class are reachable on the Internet
+func (class IPClass) IsGlobal() bool {
+	switch class {
+	case IPClassGlobal, IPClass6to4, IPClassTeredo, IPClassNAT64, IPClassSuspicious:
+		return true
+	default:
+		return false
+	}
+}
+
+// the special-purpose prefixes; the first match wins, so more specific ones come first
+var specialPrefixes = []struct {
+	prefix netip.Prefix
+	class  IPClass
+}{
+	// IPv4
+	{netip.MustParsePrefix("0.0.0.0/32"), IPClassUnspecified},
+	{netip.MustParsePrefix("0.0.0.0/8"), IPClassThisNetwork},
+	{netip.MustParsePrefix("10.0.0.0/8"), IPClassPrivate},
+	{netip.MustParsePrefix("100.64.0.0/10"), IPClassCGNAT},
+	{netip.MustParsePrefix("127.0.0.0/8"), IPClassLoopback},
+	{netip.MustParsePrefix("169.254.0.0/16"), IPClassLinkLocal},
+	{netip.MustParsePrefix("172.16.0.0/12"), IPClassPrivate},
+	{netip.MustParsePrefix("192.0.0.9/32"), IPClassGlobal},  // PCP anycast
+	{netip.MustParsePrefix("192.0.0.10/32"), IPClassGlobal}, // TURN anycast
+	{netip.MustParsePrefix("192.0.0.0/24"), IPClassProtocolAssignment},
+	{netip.MustParsePrefix("192.0.2.0/24"), IPClassDocumentation},
+	{netip.MustParsePrefix("192.88.99.0/24"), IPClassReserved}, // formerly 6to4 relay anycast
+	{netip.MustParsePrefix("192.168.0.0/16"), IPClassPrivate},
+	{netip.MustParsePrefix("198.18.0.0/15"), IPClassBenchmarking},
+	{netip.MustParsePrefix("198.51.100.0/24"), IPClassDocumentation},
+	{netip.MustParsePrefix("203.0.113.0/24"), IPClassDocumentation},
+	{netip.MustParsePrefix("224.0.0.0/4"), IPClassMulticast},
+	{netip.MustParsePrefix("255.255.255.255/32"), IPClassBroadcast},
+	{netip.MustParsePrefix("240.0.0.0/4"), IPClassReserved},
+	{netip.MustParsePrefix("1.0.0.0/24"), IPClassSuspicious},
+	{netip.MustParsePrefix("1.2.3.0/24"), IPClassSuspicious},
+
+	// IPv6
+	{netip.MustParsePrefix("::/128"), IPClassUnspecified},
+	{netip.MustParsePrefix("::1/128"), IPClassLoopback},
+	{netip.MustParsePrefix("::ffff:0:0/96"), IPClassIPv4Mapped},
+	{netip.MustParsePrefix("64:ff9b::/96"), IPClassNAT64},
+	{netip.MustParsePrefix("64:ff9b:1::/48"), IPClassNAT64},
+	{netip.MustParsePrefix("100::/64"), IPClassDiscardOnly},
+	{netip.MustParsePrefix("2001::/32"), IPClassTeredo},
+	{netip.MustParsePrefix("2001:1::1/128"), IPClassGlobal},   // PCP anycast
+	{netip.MustParsePrefix("2001:1::2/128"), IPClassGlobal},   // TURN anycast
+	{netip.MustParsePrefix("2001:1::3/128"), IPClassGlobal},   // DNS-SD SRP anycast
+	{netip.MustParsePrefix("2001:3::/32"), IPClassGlobal},     // AMT
+	{netip.MustParsePrefix("2001:4:112::/48"), IPClassGlobal}, // AS112-v6
+	{netip.MustParsePrefix("2001:20::/28"), IPClassGlobal},    // ORCHIDv2
+	{netip.MustParsePrefix("2001:30::/28"), IPClassGlobal},    // DRIP
+	{netip.MustParsePrefix("2001:2::/48"), IPClassBenchmarking},
+	{netip.MustParsePrefix("2001:db8::/32"), IPClassDocumentation},
+	{netip.MustParsePrefix("2001::/23"), IPClassProtocolAssignment},
+	{netip.MustParsePrefix("2002::/16"), IPClass6to4},
+	{netip.MustParsePrefix("3fff::/20"), IPClassDocumentation},
+	{netip.MustParsePrefix("fc00::/7"), IPClassULA},
+	{netip.MustParsePrefix("fe80::/10"), IPClassLinkLocal},
+	{netip.MustParsePrefix("ff00::/8"), IPClassMulticast},
+	{netip.MustParsePrefix("::/8"), IPClassReserved},
+}
+
+// ClassifyIP determines what kind of address an IP is
+func ClassifyIP(ip string) IPClass {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return IPClassInvalid
 	}
-	cidrIPv4DHCP       = []net.IPNet{makeIPNet("169.254.0.0/16")}
-	cidrIPv4Suspicious = []net.IPNet{
-		makeIPNet("1.0.0.0/24"),
-		makeIPNet("1.2.3.0/24"),
+	return ClassifyAddr(addr)
+}
+
+// ClassifyAddr determines what kind of address a netip.Addr is
+func ClassifyAddr(addr netip.Addr) IPClass {
+	if !addr.IsValid() {
+		return IPClassInvalid
 	}
+	addr = addr.WithZone("")
+	for _, special := range specialPrefixes {
+		if special.prefix.Contains(addr) {
+			return special.class
+		}
+	}
+	return IPClassGlobal
+}
 
-	// well-known IPv6 CIDR blocks
-	cidrIPv6ULA   = []net.IPNet{makeIPNet("fc00::/7")}
-	cidrIPv6NAT64 = []net.IPNet{makeIPNet("64:ff9b::/96")}
-)
+// the NAT64 well-known prefix, which embeds the IPv4 address in the last 32 bits
+var nat64WellKnownPrefix = netip.MustParsePrefix("64:ff9b::/96")
 
 // CIDR ranges for predefined providers. Contents are loaded from the config file
 var cidrProviders = make(map[string][]net.IPNet)
@@ -40,6 +169,10 @@ func IsAF(af int, ip string) bool {
 
 // IsIPv6 determines if an IP address is IPv6
 func IsIPv6(ip string) bool {
+	if addr, err := netip.ParseAddr(ip); err == nil {
+		return addr.Is6()
+	}
+	// not an address (a host name, or an address with a port): guess
 	return strings.Contains(ip, ":")
 }
 
@@ -61,27 +194,28 @@ func IsInCIDRList(address string, cidrlist []net.IPNet) bool {
 
 // IsIPv6ULA determines if an IP address is IPv6 ULA (RFC 4193)
 func IsIPv6ULA(ip string) bool {
-	return IsInCIDRList(ip, cidrIPv6ULA)
+	return ClassifyIP(ip) == IPClassULA
 }
 
-// IsIPv6NAT64 determines if an IP address is IPv6 NAT64 (RFC 6052)
+// IsIPv6NAT64 determines if an IP address is in the IPv6 NAT64 well-known prefix (RFC 6052)
 func IsIPv6NAT64(ip string) bool {
-	return IsInCIDRList(ip, cidrIPv6NAT64)
+	addr, err := netip.ParseAddr(ip)
+	return err == nil && nat64WellKnownPrefix.Contains(addr)
 }
 
 // IsIPv4NAT determines if an IPv4 address is private (RFC 1918)
 func IsIPv4NAT(ip string) bool {
-	return IsInCIDRList(ip, cidrIPv4NAT)
+	return IsIPv4(ip) && ClassifyIP(ip) == IPClassPrivate
 }
 
 // IsIPv4Suspicious determines if an IPv4 address is weird as an end user IP
 func IsIPv4Suspicious(ip string) bool {
-	return IsInCIDRList(ip, cidrIPv4Suspicious)
+	return ClassifyIP(ip) == IPClassSuspicious
 }
 
 // IsIPv4DCHP determines if an IPv4 address is link local (DHCP failed? RFC 3927)
 func IsIPv4DCHP(ip string) bool {
-	return IsInCIDRList(ip, cidrIPv4DHCP)
+	return IsIPv4(ip) && ClassifyIP(ip) == IPClassLinkLocal
 }
 
 // IsIPInProviderCIDRBlock checks if a provider's CIDR blocks contain a particular IP
@@ -92,8 +226,8 @@ func IsIPInNetworkCIDRBlock(ip string, provider string) (bool, error) {
 	}
 	if IsIPv6NAT64(ip) {
 		// NAT64, unwrap the IPv4 address
-		b := big.NewInt(0).SetBytes(net.ParseIP(ip)).Bytes()
-		ip = net.IPv4(b[11], b[12], b[13], b[14]).String()
+		b := netip.MustParseAddr(ip).As16()
+		ip = netip.AddrFrom4([4]byte(b[12:16])).String()
 	}
 	return IsInCIDRList(ip, cidrs), nil
 }
@@ -0,0 +1,137 @@
+package util
+
+import (
+	"net/netip"
+	"testing"
+)
+
+func TestClassifyIP(t *testing.T) {
+	tests := []struct {
+		ip    string
+		class IPClass
+	}{
+		// carrier-grade NAT, 100.64.0.0/10
+		{"100.63.255.255", IPClassGlobal},
+		{"100.64.0.0", IPClassCGNAT},
+		{"100.127.255.255", IPClassCGNAT},
+		{"100.128.0.0", IPClassGlobal},
+
+		// the IETF protocol assignments in 192.0.0.0/24, but for the globally reachable anycast ones
+		{"191.255.255.255", IPClassGlobal},
+		{"192.0.0.0", IPClassProtocolAssignment},
+		{"192.0.0.8", IPClassProtocolAssignment},
+		{"192.0.0.9", IPClassGlobal},
+		{"192.0.0.10", IPClassGlobal},
+		{"192.0.0.11", IPClassProtocolAssignment},
+		{"192.0.0.170", IPClassProtocolAssignment},
+		{"192.0.0.255", IPClassProtocolAssignment},
+		{"192.0.1.0", IPClassGlobal},
+		{"192.0.2.0", IPClassDocumentation},
+
+		{"0.0.0.0", IPClassUnspecified},
+		{"0.1.2.3", IPClassThisNetwork},
+		{"172.15.255.255", IPClassGlobal},
+		{"172.16.0.0", IPClassPrivate},
+		{"172.31.255.255", IPClassPrivate},
+		{"172.32.0.0", IPClassGlobal},
+		{"198.17.255.255", IPClassGlobal},
+		{"198.19.255.255", IPClassBenchmarking},
+		{"198.20.0.0", IPClassGlobal},
+		{"255.255.255.254", IPClassReserved},
+		{"255.255.255.255", IPClassBroadcast},
+
+		// the IETF protocol assignments in 2001::/23, and the ranges in it
+		{"2001::1", IPClassTeredo},
+		{"2001:0:ffff:ffff:ffff:ffff:ffff:ffff", IPClassTeredo},
+		{"2001:1::", IPClassProtocolAssignment},
+		{"2001:1::1", IPClassGlobal},
+		{"2001:1::2", IPClassGlobal},
+		{"2001:1::3", IPClassGlobal},
+		{"2001:1::4", IPClassProtocolAssignment},
+		{"2001:2::1", IPClassBenchmarking},
+		{"2001:2:1::", IPClassProtocolAssignment},
+		{"2001:3::1", IPClassGlobal},
+		{"2001:4:112::1", IPClassGlobal},
+		{"2001:4:113::1", IPClassProtocolAssignment},
+		{"2001:10::1", IPClassProtocolAssignment}, // the deprecated ORCHID
+		{"2001:1f::1", IPClassProtocolAssignment},
+		{"2001:20::1", IPClassGlobal},
+		{"2001:2f:ffff::", IPClassGlobal},
+		{"2001:30::1", IPClassGlobal},
+		{"2001:3f:ffff::", IPClassGlobal},
+		{"2001:40::1", IPClassProtocolAssignment},
+		{"2001:1ff:ffff::", IPClassProtocolAssignment},
+		{"2001:200::1", IPClassGlobal},
+		{"2001:db8::1", IPClassDocumentation},
+		{"2002::1", IPClass6to4},
+
+		// NAT64, 64:ff9b::/96 and 64:ff9b:1::/48
+		{"64:ff9b::", IPClassNAT64},
+		{"64:ff9b::192.0.2.1", IPClassNAT64},
+		{"64:ff9b::ffff:ffff", IPClassNAT64},
+		{"64:ff9b::1:0:0", IPClassReserved},
+		{"64:ff9b:1::1", IPClassNAT64},
+		{"64:ff9b:2::1", IPClassReserved},
+
+		// IPv4-mapped addresses are not taken for the IPv4 address in them
+		{"::ffff:8.8.8.8", IPClassIPv4Mapped},
+		{"::ffff:10.0.0.1", IPClassIPv4Mapped},
+		{"::ffff:0:0", IPClassIPv4Mapped},
+		{"::fffe:ffff:ffff", IPClassReserved},
+		{"::1:0:0:0", IPClassReserved},
+
+		{"::", IPClassUnspecified},
+		{"::1", IPClassLoopback},
+		{"100::1", IPClassDiscardOnly},
+		{"100:0:0:1::", IPClassGlobal},
+		{"3fff:fff::1", IPClassDocumentation},
+		{"3fff:1000::", IPClassGlobal},
+		{"fbff:ffff::", IPClassGlobal},
+		{"fc00::1", IPClassULA},
+		{"fdff:ffff::", IPClassULA},
+		{"fe80::1%eth0", IPClassLinkLocal},
+		{"febf:ffff::", IPClassLinkLocal},
+		{"fec0::1", IPClassGlobal},
+		{"ff02::fb", IPClassMulticast},
+		{"2a00:1450::1", IPClassGlobal},
+
+		{"", IPClassInvalid},
+		{"192.0.2.1:53", IPClassInvalid},
+		{"example.com", IPClassInvalid},
+	}
+	for _, test := range tests {
+		t.Run(test.ip, func(t *testing.T) {
+			if class := ClassifyIP(test.ip); class != test.class {
+				t.Errorf("class = %s, want %s", class, test.class)
+			}
+		})
+	}
+}
+
+func TestClassifyAddr(t *testing.T) {
+	tests := []struct {
+		addr   netip.Addr
+		class  IPClass
+		global bool
+	}{
+		{netip.AddrFrom4([4]byte{100, 64, 0, 1}), IPClassCGNAT, false},
+		{netip.AddrFrom4([4]byte{192, 0, 0, 9}), IPClassGlobal, true},
+		{netip.AddrFrom4([4]byte{1, 2, 3, 4}), IPClassSuspicious, true},
+		// the 16 byte form of an IPv4 address is the IPv4-mapped address
+		{netip.AddrFrom16(netip.MustParseAddr("::ffff:192.0.0.9").As16()), IPClassIPv4Mapped, false},
+		{netip.MustParseAddr("::ffff:192.0.0.9").Unmap(), IPClassGlobal, true},
+		{netip.MustParseAddr("fe80::1").WithZone("eth0"), IPClassLinkLocal, false},
+		{netip.MustParseAddr("64:ff9b::192.0.2.1"), IPClassNAT64, true},
+		{netip.MustParseAddr("2001:2::1"), IPClassBenchmarking, false},
+		{netip.MustParseAddr("2001:20::1"), IPClassGlobal, true},
+		{netip.Addr{}, IPClassInvalid, false},
+	}
+	for _, test := range tests {
+		t.Run(test.addr.String(), func(t *testing.T) {
+			class := ClassifyAddr(test.addr)
+			if class != test.class || class.IsGlobal() != test.global {
+				t.Errorf("class = %s (global: %v), want %s (global: %v)", class, class.IsGlobal(), test.class, test.global)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package util
+
+/*
+  A minimal STUN (RFC 5389) message codec with the NAT behaviour discovery
+  attributes of RFC 5780, shared by the STUN check and the STUN responder
+*/
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net/netip"
+)
+
+// STUN message types
+const (
+	STUNBindingRequest  uint16 = 0x0001
+	STUNBindingResponse uint16 = 0x0101
+	STUNBindingError    uint16 = 0x0111
+)
+
+// STUN attribute types
+const (
+	stunAttrMappedAddress    uint16 = 0x0001
+	stunAttrChangeRequest    uint16 = 0x0003
+	stunAttrErrorCode        uint16 = 0x0009
+	stunAttrXORMappedAddress uint16 = 0x0020
+	stunAttrSoftware         uint16 = 0x8022
+	stunAttrResponseOrigin   uint16 = 0x802b
+	stunAttrOtherAddress     uint16 = 0x802c
+)
+
+// CHANGE-REQUEST flags
+const (
+	stunChangeIP   uint32 = 0x04
+	stunChangePort uint32 = 0x02
+)
+
+const stunMagicCookie uint32 = 0x2112A442
+const stunHeaderLength = 20
+
+// STUNMessage is a STUN message with the attributes we care about
+type STUNMessage struct {
+	Type           uint16
+	TransactionID  [12]byte
+	MappedAddress  netip.AddrPort // from XOR-MAPPED-ADDRESS, or MAPPED-ADDRESS
+	ResponseOrigin netip.AddrPort
+	OtherAddress   netip.AddrPort
+	ChangeIP       bool
+	ChangePort     bool
+	ErrorCode      int
+	Software       string
+}
+
+// NewSTUNBindingRequest creates a binding request with a random transaction ID
+func NewSTUNBindingRequest(changeIP bool, changePort bool) *STUNMessage {
+	msg := &STUNMessage{Type: STUNBindingRequest, ChangeIP: changeIP, ChangePort: changePort}
+	rand.Read(msg.TransactionID[:])
+	return msg
+}
+
+// Encode returns the on-the-wire format of a STUN message
+func (msg *STUNMessage) Encode() []byte {
+	var attrs []byte
+	addAttr := func(attrType uint16, value []byte) {
+		attrs = binary.BigEndian.AppendUint16(attrs, attrType)
+		attrs = binary.BigEndian.AppendUint16(attrs, uint16(len(value)))
+		attrs = append(attrs, value...)
+		for len(attrs)%4 != 0 {
+			attrs = append(attrs, 0)
+		}
+	}
+
+	if msg.MappedAddress.IsValid() {
+		addAttr(stunAttrXORMappedAddress, msg.encodeAddress(msg.MappedAddress, true))
+		addAttr(stunAttrMappedAddress, msg.encodeAddress(msg.MappedAddress, false))
+	}
+	if msg.ResponseOrigin.IsValid() {
+		addAttr(stunAttrResponseOrigin, msg.encodeAddress(msg.ResponseOrigin, false))
+	}
+	if msg.OtherAddress.IsValid() {
+		addAttr(stunAttrOtherAddress, msg.encodeAddress(msg.OtherAddress, false))
+	}
+	if msg.ChangeIP || msg.ChangePort {
+		var flags uint32
+		if msg.ChangeIP {
+			flags |= stunChangeIP
+		}
+		if msg.ChangePort {
+			flags |= stunChangePort
+		}
+		addAttr(stunAttrChangeRequest, binary.BigEndian.AppendUint32(nil, flags))
+	}
+	if msg.ErrorCode != 0 {
+		addAttr(stunAttrErrorCode, []byte{0, 0, byte(msg.ErrorCode / 100), byte(msg.ErrorCode % 100)})
+	}
+	if msg.Software != "" {
+		addAttr(stunAttrSoftware, []byte(msg.Software))
+	}
+
+	wire := binary.BigEndian.AppendUint16(nil, msg.Type)
+	wire = binary.BigEndian.AppendUint16(wire, uint16(len(attrs)))
+	wire = binary.BigEndian.AppendUint32(wire, stunMagicCookie)
+	wire = append(wire, msg.TransactionID[:]...)
+	return append(wire, attrs...)
+}
+
+// ParseSTUNMessage parses the on-the-wire format of a STUN message
+func ParseSTUNMessage(wire []byte) (*STUNMessage, error) {
+	if len(wire) < stunHeaderLength {
+		return nil, fmt.Errorf("STUN message too short (%d bytes)", len(wire))
+	}
+	if binary.BigEndian.Uint32(wire[4:8]) != stunMagicCookie {
+		return nil, fmt.Errorf("not a STUN message (wrong magic cookie)")
+	}
+	length := int(binary.BigEndian.Uint16(wire[2:4]))
+	if len(wire) < stunHeaderLength+length {
+		return nil, fmt.Errorf("STUN message truncated")
+	}
+
+	msg := &STUNMessage{Type: binary.BigEndian.Uint16(wire[0:2])}
+	copy(msg.TransactionID[:], wire[8:20])
+
+	attrs := wire[stunHeaderLength : stunHeaderLength+length]
+	for len(attrs) >= 4 {
+		attrType := binary.BigEndian.Uint16(attrs[0:2])
+		attrLength := int(binary.BigEndian.Uint16(attrs[2:4]))
+		if len(attrs) < 4+attrLength {
+			return nil, fmt.Errorf("STUN attribute 0x%04x truncated", attrType)
+		}
+		value := attrs[4 : 4+attrLength]
+
+		switch attrType {
+		case stunAttrXORMappedAddress:
+			msg.MappedAddress = msg.decodeAddress(value, true)
+		case stunAttrMappedAddress:
+			if !msg.MappedAddress.IsValid() {
+				msg.MappedAddress = msg.decodeAddress(value, false)
+			}
+		case stunAttrResponseOrigin:
+			msg.ResponseOrigin = msg.decodeAddress(value, false)
+		case stunAttrOtherAddress:
+			msg.OtherAddress = msg.decodeAddress(value, false)
+		case stunAttrChangeRequest:
+			if len(value) == 4 {
+				flags := binary.BigEndian.Uint32(value)
+				msg.ChangeIP = flags&stunChangeIP != 0
+				msg.ChangePort = flags&stunChangePort != 0
+			}
+		case stunAttrErrorCode:
+			if len(value) >= 4 {
+				msg.ErrorCode = int(value[2]&0x07)*100 + int(value[3])
+			}
+		case stunAttrSoftware:
+			msg.Software = string(value)
+		}
+
+		// attributes are padded to 4 bytes
+		next := 4 + (attrLength+3)/4*4
+		if next > len(attrs) {
+			break
+		}
+		attrs = attrs[next:]
+	}
+	return msg, nil
+}
+
+// encode an address attribute, optionally XOR-ed with the magic cookie and transaction ID
+func (msg *STUNMessage) encodeAddress(addrPort netip.AddrPort, xor bool) []byte {
+	addr := addrPort.Addr().Unmap()
+	family := byte(0x01)
+	if addr.Is6() {
+		family = 0x02
+	}
+	port := addrPort.Port()
+	ip := addr.AsSlice()
+	if xor {
+		port ^= uint16(stunMagicCookie >> 16)
+		ip = msg.xorIP(ip)
+	}
+	value := []byte{0, family}
+	value = binary.BigEndian.AppendUint16(value, port)
+	return append(value, ip...)
+}
+
+// decode an address attribute
+func (msg *STUNMessage) decodeAddress(value []byte, xor bool) netip.AddrPort {
+	if len(value) < 8 {
+		return netip.AddrPort{}
+	}
+	port := binary.BigEndian.Uint16(value[2:4])
+	ip := value[4:]
+	switch {
+	case value[1] == 0x01 && len(ip) >= 4:
+		ip = ip[:4]
+	case value[1] == 0x02 && len(ip) >= 16:
+		ip = ip[:16]
+	default:
+		return netip.AddrPort{}
+	}
+	if xor {
+		port ^= uint16(stunMagicCookie >> 16)
+		ip = msg.xorIP(ip)
+	}
+	addr, _ := netip.AddrFromSlice(ip)
+	return netip.AddrPortFrom(addr, port)
+}
+
+// XOR an address with the magic cookie (and the transaction ID for IPv6)
+func (msg *STUNMessage) xorIP(ip []byte) []byte {
+	key := binary.BigEndian.AppendUint32(nil, stunMagicCookie)
+	key = append(key, msg.TransactionID[:]...)
+	out := make([]byte, len(ip))
+	for i := range ip {
+		out[i] = ip[i] ^ key[i]
+	}
+	return out
+}
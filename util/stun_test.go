@@ -0,0 +1,127 @@
+package util
+
+import (
+	"encoding/hex"
+	"net/netip"
+	"testing"
+)
+
+// the sample IPv4 response of RFC 5769 section 2.2, with MESSAGE-INTEGRITY and FINGERPRINT (which are skipped)
+const rfc5769IPv4Response = "0101003c2112a442b7e7a701bc34d686fa87dfae" +
+	"8022000b7465737420766563746f7220" +
+	"002000080001a147e112a643" +
+	"000800142b91f599fd9e90c38c7489f92af9ba53f06be7d7" +
+	"80280004c07d4c96"
+
+func TestParseSTUNMessageRFC5769(t *testing.T) {
+	wire, _ := hex.DecodeString(rfc5769IPv4Response)
+	msg, err := ParseSTUNMessage(wire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if msg.Type != STUNBindingResponse {
+		t.Errorf("type = %#04x, want %#04x", msg.Type, STUNBindingResponse)
+	}
+	if want := netip.MustParseAddrPort("192.0.2.1:32853"); msg.MappedAddress != want {
+		t.Errorf("mapped address = %s, want %s", msg.MappedAddress, want)
+	}
+	if msg.Software != "test vector" {
+		t.Errorf("software = %q, want %q", msg.Software, "test vector")
+	}
+}
+
+func TestSTUNMessageRoundTrip(t *testing.T) {
+	transactionID := [12]byte{0xb7, 0xe7, 0xa7, 0x01, 0xbc, 0x34, 0xd6, 0x86, 0xfa, 0x87, 0xdf, 0xae}
+	tests := []struct {
+		name string
+		msg  STUNMessage
+	}{
+		{"binding request", STUNMessage{Type: STUNBindingRequest}},
+		{"change IP and port", STUNMessage{Type: STUNBindingRequest, ChangeIP: true, ChangePort: true}},
+		{"change port", STUNMessage{Type: STUNBindingRequest, ChangePort: true}},
+		{
+			"IPv4 response",
+			STUNMessage{
+				Type:           STUNBindingResponse,
+				MappedAddress:  netip.MustParseAddrPort("192.0.2.1:32853"),
+				ResponseOrigin: netip.MustParseAddrPort("198.51.100.1:3478"),
+				OtherAddress:   netip.MustParseAddrPort("198.51.100.2:3479"),
+				Software:       "netiscope",
+			},
+		},
+		{
+			"IPv6 response",
+			STUNMessage{
+				Type:           STUNBindingResponse,
+				MappedAddress:  netip.MustParseAddrPort("[2001:db8:1234:5678:11:2233:4455:6677]:32853"),
+				ResponseOrigin: netip.MustParseAddrPort("[2001:db8::1]:3478"),
+				OtherAddress:   netip.MustParseAddrPort("[2001:db8::2]:3479"),
+			},
+		},
+		{"error", STUNMessage{Type: STUNBindingError, ErrorCode: 420}},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			test.msg.TransactionID = transactionID
+			wire := test.msg.Encode()
+			if len(wire)%4 != 0 {
+				t.Errorf("message length %d is not a multiple of 4", len(wire))
+			}
+			msg, err := ParseSTUNMessage(wire)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if *msg != test.msg {
+				t.Errorf("parsed %+v, want %+v", *msg, test.msg)
+			}
+		})
+	}
+}
+
+func TestSTUNXORMappedAddress(t *testing.T) {
+	msg := STUNMessage{
+		Type:          STUNBindingResponse,
+		TransactionID: [12]byte{0xb7, 0xe7, 0xa7, 0x01, 0xbc, 0x34, 0xd6, 0x86, 0xfa, 0x87, 0xdf, 0xae},
+	}
+	tests := []struct {
+		name    string
+		address string
+		value   string // XOR-MAPPED-ADDRESS, as in RFC 5769
+	}{
+		{"IPv4", "192.0.2.1:32853", "0001a147e112a643"},
+		{"IPv6", "[2001:db8:1234:5678:11:2233:4455:6677]:32853", "0002a1470113a9faa5d3f179bc25f4b5bed2b9d9"},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			value := hex.EncodeToString(msg.encodeAddress(netip.MustParseAddrPort(test.address), true))
+			if value != test.value {
+				t.Errorf("XOR-MAPPED-ADDRESS = %s, want %s", value, test.value)
+			}
+		})
+	}
+}
+
+func TestParseSTUNMessageErrors(t *testing.T) {
+	valid := (&STUNMessage{Type: STUNBindingRequest, ChangeIP: true}).Encode()
+	wrongCookie := append([]byte{}, valid...)
+	wrongCookie[4] ^= 0xff
+	truncatedAttribute := append([]byte{}, valid...)
+	truncatedAttribute[23] = 8 // the CHANGE-REQUEST attribute claims to be longer
+
+	tests := []struct {
+		name string
+		wire []byte
+	}{
+		{"too short", valid[:19]},
+		{"wrong magic cookie", wrongCookie},
+		{"truncated message", valid[:len(valid)-1]},
+		{"truncated attribute", truncatedAttribute},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if _, err := ParseSTUNMessage(test.wire); err == nil {
+				t.Error("no error")
+			}
+		})
+	}
+}